@@ -1,8 +1,11 @@
 package main
 
 import (
+	"atlasq/internal/auth"
 	"atlasq/internal/database"
 	"atlasq/internal/handlers"
+	"atlasq/internal/ratelimit"
+	"atlasq/internal/usage"
 	"log"
 	"time"
 
@@ -10,6 +13,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/hibiken/asynq"
 	"github.com/hibiken/asynqmon"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -28,7 +32,13 @@ func main() {
 
 	log.Println("Connected to PostgreSQL successfully")
 
-	_ = asynq.NewClient(asynq.RedisClientOpt{Addr: "127.0.0.1:6379"})
+	inspector := asynq.NewInspector(asynq.RedisClientOpt{Addr: "127.0.0.1:6379"})
+	defer inspector.Close()
+
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:6379"})
+	defer rdb.Close()
+	limiter := ratelimit.New(rdb)
+	meter := usage.NewMeter(rdb)
 
 	app := fiber.New()
 
@@ -54,13 +64,97 @@ func main() {
 	// API routes
 	api := app.Group("/api/v1")
 
-	api.Post("/tenants", handlers.CreateTenant(pool.Pool))
-	api.Post("/products", handlers.CreateProduct(pool.Pool))
-	api.Post("/orders-old", handlers.CreateOrderOld(pool.Pool))
-	api.Post("/orders-queue", handlers.CreateOrderQueue(client))
-	api.Get("/orders/:id", handlers.GetOrderByID(pool.Pool))
-	// api.Post("/orders", handlers.CreateOrder(pool.Pool))
-	api.Post("/stock-issue", handlers.StockIssueHandler(pool.Pool))
+	// admin routes ใช้ ADMIN_TOKEN
+	adminAuth := auth.Admin()
+
+	api.Post("/tenants", adminAuth, handlers.CreateTenant(pool))
+	api.Get("/tenants", adminAuth, handlers.ListTenants(pool))
+	api.Get("/tenants/:id", adminAuth, handlers.GetTenant(pool))
+	api.Patch("/tenants/:id", adminAuth, handlers.UpdateTenant(pool))
+	api.Delete("/tenants/:id", adminAuth, handlers.DeleteTenant(pool))
+	api.Post("/tenants/:id/activate", adminAuth, handlers.ActivateTenant(pool))
+	api.Post("/tenants/:id/deactivate", adminAuth, handlers.DeactivateTenant(pool))
+	api.Post("/tenants/:id/rotate-secret", adminAuth, handlers.RotateTenantSecret(pool))
+	api.Post("/tenants/:id/approver-token", adminAuth, handlers.IssueApproverToken(pool))
+	api.Get("/tenants/:id/export", adminAuth, handlers.ExportTenant(pool))
+	api.Post("/tenants/:id/purge", adminAuth, handlers.PurgeTenant(pool, client))
+	api.Get("/tenants/:id/purge/:job_id", adminAuth, handlers.GetPurgeJob(inspector))
+	api.Get("/callbacks/dead-letters", adminAuth, handlers.ListDeadCallbacks(inspector))
+	api.Post("/callbacks/dead-letters/:id/replay", adminAuth, handlers.ReplayDeadCallback(inspector))
+	api.Get("/usage", adminAuth, handlers.GetUsageReport(pool))
+
+	// tenant routes ต้อง sign ด้วย key/secret ของ tenant, โดน rate limit ตาม tenants.type และถูกนับ usage
+	// group นี้ใช้ prefix เดียวกับ admin routes ด้านบน route admin ใหม่ต้องประกาศก่อน group นี้
+	tenantAPI := api.Group("", auth.HMAC(pool, rdb), ratelimit.Middleware(limiter), meter.Middleware())
+
+	tenantAPI.Post("/credentials/rotate", handlers.RotateSecret(pool))
+	tenantAPI.Post("/credentials/rotate/complete", handlers.CompleteSecretRotation(pool))
+	tenantAPI.Get("/settings", handlers.GetSettings(pool))
+	tenantAPI.Patch("/settings", handlers.UpdateSettings(pool))
+	tenantAPI.Post("/warehouses", handlers.CreateWarehouse(pool))
+	tenantAPI.Get("/warehouses", handlers.ListWarehouses(pool))
+	tenantAPI.Get("/warehouses/:id", handlers.GetWarehouse(pool))
+	tenantAPI.Patch("/warehouses/:id", handlers.UpdateWarehouse(pool))
+	tenantAPI.Delete("/warehouses/:id", handlers.DeleteWarehouse(pool))
+	tenantAPI.Get("/warehouses/:id/locations", handlers.ListLocations(pool))
+	tenantAPI.Post("/warehouses/:id/locations", handlers.CreateLocation(pool))
+	tenantAPI.Get("/locations/:id", handlers.GetLocation(pool))
+	tenantAPI.Patch("/locations/:id", handlers.UpdateLocation(pool))
+	tenantAPI.Delete("/locations/:id", handlers.DeleteLocation(pool))
+	tenantAPI.Get("/locations/:id/stock", handlers.GetLocationStock(pool))
+	tenantAPI.Post("/location-moves", handlers.MoveLocationStock(pool))
+	tenantAPI.Post("/products", handlers.CreateProduct(pool))
+	tenantAPI.Get("/products", handlers.ListProducts(pool))
+	tenantAPI.Post("/products/import", handlers.ImportProducts(pool, client))
+	tenantAPI.Get("/products/imports/:id", handlers.GetProductImport(pool))
+	tenantAPI.Get("/products/imports/:id/errors", handlers.GetProductImportErrors(pool))
+	tenantAPI.Get("/products/lookup", handlers.LookupProduct(pool))
+	tenantAPI.Get("/products/:id", handlers.GetProduct(pool))
+	tenantAPI.Patch("/products/:id", handlers.UpdateProduct(pool))
+	tenantAPI.Post("/products/:id/archive", handlers.ArchiveProduct(pool))
+	tenantAPI.Post("/products/:id/unarchive", handlers.UnarchiveProduct(pool))
+	tenantAPI.Get("/products/:id/barcodes", handlers.ListBarcodes(pool))
+	tenantAPI.Post("/products/:id/barcodes", handlers.AddBarcode(pool))
+	tenantAPI.Delete("/products/:id/barcodes/:barcode", handlers.DeleteBarcode(pool))
+	tenantAPI.Get("/products/:id/bundle", handlers.GetBundle(pool))
+	tenantAPI.Put("/products/:id/bundle", handlers.SetBundle(pool))
+	tenantAPI.Get("/products/:id/units", handlers.ListProductUnits(pool))
+	tenantAPI.Put("/products/:id/units/:code", handlers.PutProductUnit(pool))
+	tenantAPI.Delete("/products/:id/units/:code", handlers.DeleteProductUnit(pool))
+	tenantAPI.Get("/products/:id/price", handlers.GetProductPrice(pool))
+	tenantAPI.Post("/price-lists", handlers.CreatePriceList(pool))
+	tenantAPI.Get("/price-lists", handlers.ListPriceLists(pool))
+	tenantAPI.Patch("/price-lists/:id", handlers.UpdatePriceList(pool))
+	tenantAPI.Get("/price-lists/:id/prices", handlers.ListProductPrices(pool))
+	tenantAPI.Post("/price-lists/:id/prices", handlers.AddProductPrice(pool))
+	tenantAPI.Post("/orders-old", handlers.CreateOrderOld(pool))
+	tenantAPI.Post("/orders-queue", handlers.CreateOrderQueue(pool, client, meter))
+	tenantAPI.Get("/orders/:id", handlers.GetOrderByID(pool))
+	tenantAPI.Get("/orders/:id/allocations", handlers.GetOrderAllocations(pool))
+	tenantAPI.Post("/orders", handlers.CreateOrder(pool))
+	tenantAPI.Get("/orders/:id/reservations", handlers.ListOrderReservations(pool))
+	tenantAPI.Post("/orders/:id/reserve", handlers.ReserveOrder(pool))
+	tenantAPI.Post("/orders/:id/release", handlers.ReleaseOrder(pool))
+	tenantAPI.Post("/orders/:id/issue", handlers.IssueOrder(pool))
+	tenantAPI.Post("/orders/:id/cancel", handlers.CancelOrder(pool))
+	tenantAPI.Post("/orders/:id/returns", handlers.ReturnOrder(pool))
+	// stock query ส่ง ETag ไปด้วย client ที่ poll ส่ง If-None-Match มาจะได้ 304 ถ้า stock ไม่เปลี่ยน
+	// handler เช็ค If-None-Match เองก่อน query หน้า data จึงไม่ใช้ etag middleware
+	tenantAPI.Get("/stock", handlers.ListStock(pool))
+	tenantAPI.Get("/stock/summary", handlers.StockSummaryHandler(pool))
+	tenantAPI.Post("/stock-issue", handlers.StockIssueHandler(pool))
+	tenantAPI.Post("/stock-receive", handlers.StockReceiveHandler(pool))
+	tenantAPI.Get("/adjustments", handlers.ListAdjustments(pool))
+	tenantAPI.Post("/adjustments", handlers.CreateAdjustment(pool))
+	tenantAPI.Get("/adjustments/:id", handlers.GetAdjustment(pool))
+	// approve / reject ต้องมี approver token ของ tenant นอกจาก HMAC
+	approver := auth.Approver(pool)
+	tenantAPI.Post("/adjustments/:id/approve", approver, handlers.ApproveAdjustment(pool))
+	tenantAPI.Post("/adjustments/:id/reject", approver, handlers.RejectAdjustment(pool))
+	tenantAPI.Post("/transfers", handlers.CreateTransfer(pool))
+	tenantAPI.Get("/transfers", handlers.ListTransfers(pool))
+	tenantAPI.Get("/transfers/:id", handlers.GetTransfer(pool))
+	tenantAPI.Post("/transfers/:id/receive", handlers.ReceiveTransfer(pool))
 
 	if err := app.Listen(":8080"); err != nil {
 		log.Fatalf("failed to start Fiber app: %v", err)
@@ -128,6 +128,8 @@ func aliasForType(typ string) string {
 		return "atlasq-debug-write"
 	case "query":
 		return "atlasq-queries-write"
+	case "security":
+		return "atlasq-security-write"
 	default:
 		return "atlasq-all-write"
 	}
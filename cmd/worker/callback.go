@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"atlasq/internal/auth"
+	tasks "atlasq/internal/tasks"
+	"atlasq/internal/usage"
+
+	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	callbackMaxRetry  = 8
+	callbackBaseDelay = 10 * time.Second
+	callbackMaxDelay  = 6 * time.Hour
+)
+
+var callbackHTTP = &http.Client{Timeout: 10 * time.Second}
+
+// retryDelay: callback ใช้ exponential backoff ส่วน task อื่นใช้ค่า default ของ asynq
+func retryDelay(n int, err error, t *asynq.Task) time.Duration {
+	if t.Type() != tasks.TypeTenantCallback {
+		return asynq.DefaultRetryDelayFunc(n, err, t)
+	}
+	d := callbackBaseDelay << uint(n)
+	if d <= 0 || d > callbackMaxDelay {
+		d = callbackMaxDelay
+	}
+	// jitter ±20% กันทุก callback retry พร้อมกัน
+	jitter := time.Duration(rand.Int63n(int64(d)/5*2+1)) - d/5
+	return d + jitter
+}
+
+// isFinalAttempt reports whether asynq will not retry the task after err.
+func isFinalAttempt(ctx context.Context, err error) bool {
+	if errors.Is(err, asynq.SkipRetry) {
+		return true
+	}
+	retried, ok1 := asynq.GetRetryCount(ctx)
+	maxRetry, ok2 := asynq.GetMaxRetry(ctx)
+	return ok1 && ok2 && retried >= maxRetry
+}
+
+// allocations มีเฉพาะตอนสำเร็จ บอกว่าแต่ละ line ตัดจาก warehouse ไหน
+func notifyOrderOutcome(payload tasks.DeductStockPayload, allocations []tasks.Allocation, outcome error) {
+	cb := tasks.CallbackPayload{
+		Event:       tasks.CallbackOrderDeducted,
+		TenantID:    payload.TenantID,
+		OrderID:     payload.OrderID,
+		OrderNumber: payload.OrderNumber,
+		WarehouseID: payload.WarehouseID,
+		Items:       payload.Items,
+		Allocations: allocations,
+		Status:      "success",
+		OccurredAt:  time.Now().UTC(),
+	}
+	if outcome != nil {
+		cb.Event = tasks.CallbackOrderFailed
+		cb.Status = "failed"
+		cb.Error = outcome.Error()
+	}
+
+	data, err := json.Marshal(cb)
+	if err != nil {
+		log.Printf("failed to marshal callback payload: %v", err)
+		return
+	}
+
+	task := asynq.NewTask(tasks.TypeTenantCallback, data,
+		asynq.Queue(tasks.QueueCallbacks),
+		asynq.MaxRetry(callbackMaxRetry),
+		asynq.Timeout(30*time.Second),
+	)
+	if _, err := client.Enqueue(task); err != nil {
+		log.Printf("failed to enqueue callback order=%s: %v", payload.OrderNumber, err)
+	}
+}
+
+// TenantCallbackTaskHandler POST outcome ไปที่ callback_url ของ tenant
+// แล้ว sign ด้วย scheme เดียวกับ request ขาเข้า (auth.Sign)
+func TenantCallbackTaskHandler(ctx context.Context, t *asynq.Task) error {
+	var payload tasks.CallbackPayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %v: %w", err, asynq.SkipRetry)
+	}
+
+	var callbackURL *string
+	var secret string
+	err := pool.QueryRow(ctx, `SELECT callback_url, secret FROM tenants WHERE id=$1`, payload.TenantID).Scan(&callbackURL, &secret)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("tenant %d not found: %w", payload.TenantID, asynq.SkipRetry)
+	}
+	if err != nil {
+		return err
+	}
+	if callbackURL == nil || *callbackURL == "" {
+		log.Printf("tenant %d has no callback_url, skip %s", payload.TenantID, payload.Event)
+		return nil
+	}
+
+	u, err := url.Parse(*callbackURL)
+	if err != nil {
+		return fmt.Errorf("invalid callback_url: %v: %w", err, asynq.SkipRetry)
+	}
+
+	deliveryErr := deliverCallback(ctx, u, secret, payload, t.Payload())
+	meter.Add(ctx, payload.TenantID, usage.MetricWebhookPushes, 1)
+
+	failed := 0
+	if deliveryErr != nil {
+		failed = 1
+	}
+	if _, err := pool.Exec(ctx,
+		`UPDATE tenants SET push_total=push_total+1, push_failed=push_failed+$1 WHERE id=$2`,
+		failed, payload.TenantID,
+	); err != nil {
+		log.Printf("failed to update push counters tenant=%d: %v", payload.TenantID, err)
+	}
+
+	if deliveryErr != nil {
+		log.Printf("callback to tenant=%d order=%s failed: %v", payload.TenantID, payload.OrderNumber, deliveryErr)
+		return deliveryErr
+	}
+	log.Printf("callback delivered tenant=%d order=%s event=%s", payload.TenantID, payload.OrderNumber, payload.Event)
+	return nil
+}
+
+func deliverCallback(ctx context.Context, u *url.URL, secret string, payload tasks.CallbackPayload, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	deliveryID, _ := asynq.GetTaskID(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(auth.HeaderTimestamp, timestamp)
+	req.Header.Set(auth.HeaderSignature, auth.Sign(secret, http.MethodPost, u.RequestURI(), timestamp, body))
+	req.Header.Set("X-Atlasq-Event", payload.Event)
+	req.Header.Set("X-Atlasq-Delivery", deliveryID)
+
+	resp, err := callbackHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("callback returned status=%d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"atlasq/internal/productimport"
+	tasks "atlasq/internal/tasks"
+
+	"github.com/hibiken/asynq"
+)
+
+func ProductImportTaskHandler(ctx context.Context, t *asynq.Task) error {
+	var payload tasks.ProductImportPayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %v: %w", err, asynq.SkipRetry)
+	}
+
+	err := productimport.Run(ctx, pool, payload.TenantID, payload.ImportID, payload.Format, payload.Data)
+	if err != nil {
+		log.Printf("product import=%d tenant=%d failed: %v", payload.ImportID, payload.TenantID, err)
+		if isFinalAttempt(ctx, err) {
+			if ferr := productimport.Fail(context.Background(), pool, payload.TenantID, payload.ImportID, err); ferr != nil {
+				log.Printf("failed to mark import=%d failed: %v", payload.ImportID, ferr)
+			}
+		}
+		return err
+	}
+	log.Printf("product import=%d tenant=%d finished", payload.ImportID, payload.TenantID)
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"atlasq/internal/opensearchclient"
+	tasks "atlasq/internal/tasks"
+	"atlasq/internal/tenantdata"
+
+	"github.com/hibiken/asynq"
+)
+
+// TenantPurgeTaskHandler ลบข้อมูลทั้งหมดของ tenant (หรือแค่นับถ้า dry run)
+// จำนวนแถวต่อตารางถูกเขียนเป็น result ของ task ให้ admin ดูผ่าน API
+func TenantPurgeTaskHandler(ctx context.Context, t *asynq.Task) error {
+	var payload tasks.TenantPurgePayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %v: %w", err, asynq.SkipRetry)
+	}
+
+	counts, err := tenantdata.Purge(ctx, pool, payload.TenantID, payload.DryRun)
+	if err == tenantdata.ErrTenantNotFound || err == tenantdata.ErrNotDeleted {
+		return fmt.Errorf("%v: %w", err, asynq.SkipRetry)
+	}
+	if err != nil {
+		log.Printf("purge tenant=%d failed: %v", payload.TenantID, err)
+		return err
+	}
+
+	result, err := json.Marshal(counts)
+	if err != nil {
+		return err
+	}
+	if _, err := t.ResultWriter().Write(result); err != nil {
+		log.Printf("failed to write purge result tenant=%d: %v", payload.TenantID, err)
+	}
+
+	if payload.DryRun {
+		log.Printf("purge dry run tenant=%d: %s", payload.TenantID, result)
+		return nil
+	}
+	opensearchclient.LogSecurity(payload.TenantID, "tenant_purged", "admin", string(result))
+	log.Printf("tenant purged tenant=%d: %s", payload.TenantID, result)
+	return nil
+}
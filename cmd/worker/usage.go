@@ -0,0 +1,17 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/hibiken/asynq"
+)
+
+func UsageRollupTaskHandler(ctx context.Context, _ *asynq.Task) error {
+	if err := meter.Rollup(ctx, pool); err != nil {
+		log.Printf("usage rollup failed: %v", err)
+		return err
+	}
+	log.Printf("usage rollup finished")
+	return nil
+}
@@ -5,14 +5,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"time"
 
+	"atlasq/internal/allocation"
 	"atlasq/internal/database"
+	"atlasq/internal/inventory"
 	"atlasq/internal/opensearchclient"
+	"atlasq/internal/stockissue"
 	tasks "atlasq/internal/tasks"
+	"atlasq/internal/tenant"
+	"atlasq/internal/usage"
 
 	"github.com/hibiken/asynq"
 	"github.com/jackc/pgx/v4"
-	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/redis/go-redis/v9"
 )
 
 type OrderItem struct {
@@ -20,22 +26,63 @@ type OrderItem struct {
 	Quantity  int64 `json:"quantity"`
 }
 
-var pool *pgxpool.Pool
+// pool เปิดครั้งเดียวตอน start ใช้ร่วมกันทุก task handler
+var pool *database.LoggingPool
+
+// client ใช้ enqueue task ต่อจาก handler เช่น callback
+var client *asynq.Client
+
+var meter *usage.Meter
 
 func main() {
+	redisOpt := asynq.RedisClientOpt{Addr: "127.0.0.1:6379"}
+
+	client = asynq.NewClient(redisOpt)
+	defer client.Close()
+
+	rdb := redis.NewClient(&redis.Options{Addr: redisOpt.Addr})
+	defer rdb.Close()
+	meter = usage.NewMeter(rdb)
+
+	db := &database.PostgreSQL{}
+	var err error
+	pool, err = db.Connect()
+	if err != nil {
+		log.Fatalf("could not connect DB: %v", err)
+	}
+	defer pool.Close()
+
+	// rollup usage counter จาก redis ลง tenant_usage_monthly เป็นระยะ
+	scheduler := asynq.NewScheduler(redisOpt, nil)
+	if _, err := scheduler.Register("@every 10m", asynq.NewTask(tasks.TypeUsageRollup, nil),
+		asynq.Unique(10*time.Minute), asynq.MaxRetry(0),
+	); err != nil {
+		log.Fatalf("could not register usage rollup: %v", err)
+	}
+	if err := scheduler.Start(); err != nil {
+		log.Fatalf("could not start scheduler: %v", err)
+	}
+	defer scheduler.Shutdown()
+
 	srv := asynq.NewServer(
-		asynq.RedisClientOpt{Addr: "127.0.0.1:6379"},
+		redisOpt,
 		asynq.Config{
 			Concurrency: 10,
 			Queues: map[string]int{
-				"default":  1,
-				"critical": 2,
+				"default":            1,
+				"critical":           2,
+				tasks.QueueCallbacks: 1,
 			},
+			RetryDelayFunc: retryDelay,
 		},
 	)
 
 	mux := asynq.NewServeMux()
-	mux.HandleFunc("order:deduct_stock", DeductStockTaskHandler)
+	mux.HandleFunc(tasks.TypeDeductStock, DeductStockTaskHandler)
+	mux.HandleFunc(tasks.TypeTenantCallback, TenantCallbackTaskHandler)
+	mux.HandleFunc(tasks.TypeUsageRollup, UsageRollupTaskHandler)
+	mux.HandleFunc(tasks.TypeTenantPurge, TenantPurgeTaskHandler)
+	mux.HandleFunc(tasks.TypeProductImport, ProductImportTaskHandler)
 
 	if err := srv.Run(mux); err != nil {
 		log.Fatalf("could not run server: %v", err)
@@ -51,131 +98,238 @@ func DeductStockTaskHandler(ctx context.Context, t *asynq.Task) error {
 		return fmt.Errorf("failed to unmarshal payload: %w", err)
 	}
 
-	db := &database.PostgreSQL{}
-	pool, err := db.Connect()
-	if err != nil {
-		log.Printf("failed to connect DB: %v", err)
-		opensearchclient.LogOrder(payload, "error", "failed to connect DB", err.Error())
-		return err // Asynq จะ retry ตาม MaxRetry
+	allocations, err := deductStock(ctx, payload)
+	if err == nil {
+		notifyOrderOutcome(payload, allocations, nil)
+		return nil
 	}
-	defer pool.Close()
 
-	conn, err := pool.Acquire(ctx)
-	if err != nil {
-		log.Printf("failed to acquire DB connection: %v", err)
-		opensearchclient.LogOrder(payload, "error", "failed to acquire DB connection", err.Error())
-		return err // Asynq retry
+	// แจ้ง tenant เฉพาะตอนที่ fail ถาวร (ไม่มี retry แล้ว)
+	if isFinalAttempt(ctx, err) {
+		notifyOrderOutcome(payload, nil, err)
 	}
-	defer conn.Release()
+	return err
+}
+
+func deductStock(ctx context.Context, payload tasks.DeductStockPayload) ([]tasks.Allocation, error) {
+	// scope tx ให้ tenant ของ order เพื่อให้ RLS ทำงาน
+	ctx = tenant.WithID(ctx, payload.TenantID)
 
-	tx, err := conn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
 	if err != nil {
 		log.Printf("failed to begin tx: %v", err)
 		opensearchclient.LogOrder(payload, "error", "failed to begin tx", err.Error())
-		return err
+		return nil, err
 	}
 	defer tx.Rollback(ctx)
 
-	if err := processStockTx(ctx, tx, payload); err != nil {
+	// tenant ที่ถูกปิดหรือลบระหว่างรอคิว ไม่ต้อง retry
+	if err := tenant.EnsureActive(ctx, tx, payload.TenantID); err != nil {
+		log.Printf("tenant check failed: %v", err)
+		opensearchclient.LogOrder(payload, "error", "tenant check failed", err.Error())
+		if err == tenant.ErrInactive {
+			return nil, fmt.Errorf("%v: %w", err, asynq.SkipRetry)
+		}
+		return nil, err
+	}
+
+	allocations, err := processStockTx(ctx, tx, payload)
+	if err != nil {
 		log.Printf("processStockTx error: %v", err)
 		opensearchclient.LogOrder(payload, "error", "processStockTx error", err.Error())
-		return err // Asynq retry
+		return nil, err // Asynq retry
 	}
 
 	if err := tx.Commit(ctx); err != nil {
 		log.Printf("commit error: %v", err)
 		opensearchclient.LogOrder(payload, "error", "commit error", err.Error())
-		return err // Asynq retry
+		return nil, err // Asynq retry
 	}
 
-	log.Printf("Order processed: tenant=%d warehouse=%d items=%d",
-		payload.TenantID, payload.WarehouseID, len(payload.Items))
+	log.Printf("Order processed: tenant=%d warehouse=%d allocation=%q items=%d",
+		payload.TenantID, payload.WarehouseID, payload.Allocation, len(payload.Items))
 	opensearchclient.LogOrder(payload, "success", "order processed", "")
-	return nil
+	return allocations, nil
 }
 
 // แยก logic ออกมาเพื่อให้อ่านง่าย
-func processStockTx(ctx context.Context, tx pgx.Tx, payload tasks.DeductStockPayload) error {
+// คืน allocation ของแต่ละ line ว่าตัดจาก warehouse ไหน (บันทึกลง order_allocations แล้ว)
+func processStockTx(ctx context.Context, tx pgx.Tx, payload tasks.DeductStockPayload) ([]tasks.Allocation, error) {
 	log.Printf("func processStockTx")
-	for _, item := range payload.Items {
-		var stockID int64
-		var stockQty, reserveQty, onHandQty float64
 
-		// Query stock
-		err := tx.QueryRow(
-			ctx,
-			`SELECT id, quantity, reserve, on_hand 
-            FROM stock 
-            WHERE product_id=$1 AND warehouse_id=$2 AND tenant_id=$3`,
-			item.ProductID, payload.WarehouseID, payload.TenantID,
-		).Scan(&stockID, &stockQty, &reserveQty, &onHandQty)
+	settings, err := tenant.LoadSettings(ctx, tx, payload.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tenant settings: %w", err)
+	}
+	if payload.Allocation != "" {
+		// strategy ไม่รู้จัก retry ไปก็ไม่ผ่าน
+		if _, err := allocation.Lookup(payload.Allocation); err != nil {
+			return nil, fmt.Errorf("%v: %w", err, asynq.SkipRetry)
+		}
+	} else {
+		payload.WarehouseID = settings.WarehouseOrDefault(payload.WarehouseID)
+		if payload.WarehouseID == 0 {
+			return nil, fmt.Errorf("warehouse_id is required, tenant has no default warehouse: %w", asynq.SkipRetry)
+		}
+		// warehouse ถูกปิดระหว่างรอคิว retry ไปก็ไม่ผ่าน
+		if err := inventory.EnsureWarehouseActive(ctx, tx, payload.TenantID, payload.WarehouseID); err != nil {
+			if inventory.IsInvalid(err) {
+				return nil, fmt.Errorf("%v: %w", err, asynq.SkipRetry)
+			}
+			return nil, err
+		}
+	}
 
-		if err != nil {
-			// insert ถ้ายังไม่มี stock
-
-			err = tx.QueryRow(
-				ctx,
-				`INSERT INTO stock (
-                    tenant_id, warehouse_id, product_id, minimum,
-                    quantity, reserve, on_hand, status,
-                    create_date, update_date, row_create_date, row_update_date
-                ) VALUES ($1,$2,$3,0,$4,0,$4,true,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP)
-                RETURNING id, quantity, reserve, on_hand`,
-				payload.TenantID, payload.WarehouseID, item.ProductID, float64(item.Quantity),
-			).Scan(&stockID, &stockQty, &reserveQty, &onHandQty)
-			if err != nil {
-				log.Printf("failed to insert stock: %v", err)
-				return fmt.Errorf("failed to insert stock: %w", err)
+	allocations := []tasks.Allocation{}
+	for _, item := range payload.Items {
+		// product ถูก archive ระหว่างรอคิว retry ไปก็ไม่ผ่าน
+		if err := inventory.EnsureProductActive(ctx, tx, payload.TenantID, item.ProductID); err != nil {
+			if inventory.IsInvalid(err) {
+				return nil, fmt.Errorf("%v: %w", err, asynq.SkipRetry)
 			}
+			return nil, err
 		}
 
-		if stockQty < float64(item.Quantity) {
-			log.Printf("not enough stock for product_id=%d", item.ProductID)
-			return fmt.Errorf("not enough stock for product_id=%d , stockQty=%f , item.required=%d", item.ProductID, stockQty, item.Quantity)
+		// bundle ตัด stock ที่ component แทน ใน tx เดียวกัน
+		lines, err := inventory.Explode(ctx, tx, payload.TenantID, item.ProductID, float64(item.Quantity))
+		if err != nil {
+			if inventory.IsInvalid(err) {
+				return nil, fmt.Errorf("%v: %w", err, asynq.SkipRetry)
+			}
+			return nil, err
 		}
+		for _, line := range lines {
+			// ไม่ใช่ allocation mode -> ทั้ง line ตัดจาก warehouse เดียว
+			splits := []allocation.Allocation{{WarehouseID: payload.WarehouseID, Quantity: line.Quantity}}
+			if payload.Allocation != "" {
+				// ของไม่พอทุก warehouse รวมกัน -> retry เผื่อมีของเข้า
+				splits, err = allocation.Allocate(ctx, tx, payload.TenantID, line.ProductID, line.Quantity, payload.Allocation)
+				if err != nil {
+					return nil, err
+				}
+			}
+			for _, split := range splits {
+				part := line
+				part.Quantity = split.Quantity
+				if err := deductLine(ctx, tx, payload, split.WarehouseID, settings, part); err != nil {
+					return nil, err
+				}
+				allocations = append(allocations, tasks.Allocation{
+					ProductID:       line.ProductID,
+					BundleProductID: line.BundleProductID,
+					WarehouseID:     split.WarehouseID,
+					Quantity:        split.Quantity,
+				})
+			}
+		}
+	}
 
-		// เช็ค stock พอไหม
-		newQty := stockQty - float64(item.Quantity)
+	if err := recordAllocations(ctx, tx, payload, allocations); err != nil {
+		return nil, err
+	}
+	return allocations, nil
+}
 
-		// update stock
-		_, err = tx.Exec(
-			ctx,
-			`UPDATE stock 
-             SET quantity=$1, on_hand=$1, update_date=CURRENT_TIMESTAMP, row_update_date=CURRENT_TIMESTAMP 
-             WHERE id=$2`,
-			newQty, stockID,
+// recordAllocations บันทึกว่า warehouse ไหน fulfill line ไหนของ order
+func recordAllocations(ctx context.Context, tx pgx.Tx, payload tasks.DeductStockPayload, allocations []tasks.Allocation) error {
+	var strategy *string
+	if payload.Allocation != "" {
+		strategy = &payload.Allocation
+	}
+	for _, a := range allocations {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO order_allocations (tenant_id, order_id, order_number, product_id, bundle_product_id, warehouse_id, quantity, strategy)
+			 VALUES ($1,$2,NULLIF($3,''),$4,$5,$6,$7,$8)`,
+			payload.TenantID, payload.OrderID, payload.OrderNumber, a.ProductID, a.BundleProductID, a.WarehouseID, a.Quantity, strategy,
 		)
-
 		if err != nil {
-			log.Printf("failed to update stock: %v", err)
-			return fmt.Errorf("failed to update stock: %v", err)
+			return fmt.Errorf("failed to insert order allocation: %w", err)
+		}
+	}
+	return nil
+}
+
+// model ของ stock_movement ที่ worker ตัด (ไม่มี order_id เพราะ order_id ของคิวเป็นค่าจาก client)
+const queueMovementModel = "order_queue"
+
+// deductLine ตัด line ผ่าน stockissue แบบเดียวกับ /stock-issue: เช็ค available = balance - reserve
+// ตัด lot, bin และ stock_balance แล้วยังบันทึก transaction log ไว้เหมือนเดิม
+func deductLine(ctx context.Context, tx pgx.Tx, payload tasks.DeductStockPayload, warehouseID int64, settings tenant.Settings, line inventory.Line) error {
+	var stockID int64
+	var stockQty, reserveQty, onHandQty float64
+
+	// Query stock (lock ไว้จนจบ tx)
+	err := tx.QueryRow(
+		ctx,
+		`SELECT id, quantity, reserve, on_hand 
+        FROM stock 
+        WHERE product_id=$1 AND warehouse_id=$2 AND tenant_id=$3
+        FOR UPDATE`,
+		line.ProductID, warehouseID, payload.TenantID,
+	).Scan(&stockID, &stockQty, &reserveQty, &onHandQty)
+
+	if err == pgx.ErrNoRows {
+		// insert ถ้ายังไม่มี stock (ถ้า tenant เปิด auto_create_stock)
+		// stock ใหม่เริ่มที่ 0 จึงตัดได้เฉพาะ tenant ที่เปิด allow_backorders
+		if !settings.AutoCreateStock {
+			return fmt.Errorf("stock not found for product_id=%d warehouse_id=%d", line.ProductID, warehouseID)
 		}
 
-		// insert transaction log
-		_, err = tx.Exec(
+		err = tx.QueryRow(
 			ctx,
-			`INSERT INTO transaction (
-                model,event,teanant_id,product_id,warehouse_id,stock_id,
-                quantity_old,quantity_change,quantity_new,
-                reserve_old,reserve_change,reserve_new,
-                on_hand_old,on_hand_change,on_hand_new,
-                status,create_date,update_date,row_create_date,row_update_date
-            ) VALUES (
-                $1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,
-                CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP
-            )`,
-			"ORDER", "ISSUE", payload.TenantID, item.ProductID, payload.WarehouseID, stockID,
-			stockQty, -float64(item.Quantity), newQty,
-			reserveQty, 0, reserveQty,
-			onHandQty, -float64(item.Quantity), newQty,
-			true,
-		)
-
+			`INSERT INTO stock (
+                tenant_id, warehouse_id, product_id, minimum,
+                quantity, balance, reserve, on_hand, status,
+                create_date, update_date, row_create_date, row_update_date
+            ) VALUES ($1,$2,$3,0,0,0,0,0,true,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP)
+            RETURNING id, quantity, reserve, on_hand`,
+			payload.TenantID, warehouseID, line.ProductID,
+		).Scan(&stockID, &stockQty, &reserveQty, &onHandQty)
 		if err != nil {
-			log.Printf("failed to insert transaction: %v", err)
-			return fmt.Errorf("failed to insert transaction: %v", err)
+			log.Printf("failed to insert stock: %v", err)
+			return fmt.Errorf("failed to insert stock: %w", err)
 		}
-		log.Printf("%v ###### finish insert transaction stockID=%d ######", payload.OrderNumber, stockID)
+	} else if err != nil {
+		log.Printf("failed to fetch stock: %v", err)
+		return fmt.Errorf("failed to fetch stock: %w", err)
+	}
+
+	// ไม่แตะของที่ reserve ไว้ให้ order อื่น
+	err = stockissue.Line(ctx, tx, payload.TenantID, settings, stockissue.Request{
+		AppID:       payload.AppID,
+		StoreID:     payload.StoreID,
+		WarehouseID: warehouseID,
+		Model:       queueMovementModel,
+	}, line)
+	if err != nil {
+		log.Printf("failed to issue product_id=%d: %v", line.ProductID, err)
+		return err
+	}
+
+	// insert transaction log (bundle_product_id บอกว่าตัดเพราะ bundle ไหน)
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO transaction (
+            model,event,teanant_id,product_id,warehouse_id,stock_id,
+            quantity_old,quantity_change,quantity_new,
+            reserve_old,reserve_change,reserve_new,
+            on_hand_old,on_hand_change,on_hand_new,
+            status,bundle_product_id,create_date,update_date,row_create_date,row_update_date
+        ) VALUES (
+            $1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,
+            CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP
+        )`,
+		"ORDER", "ISSUE", payload.TenantID, line.ProductID, warehouseID, stockID,
+		stockQty, -line.Quantity, stockQty-line.Quantity,
+		reserveQty, 0, reserveQty,
+		onHandQty, -line.Quantity, onHandQty-line.Quantity,
+		true, line.BundleProductID,
+	)
+
+	if err != nil {
+		log.Printf("failed to insert transaction: %v", err)
+		return fmt.Errorf("failed to insert transaction: %v", err)
 	}
+	log.Printf("%v ###### finish insert transaction stockID=%d ######", payload.OrderNumber, stockID)
 	return nil
 }
@@ -0,0 +1,400 @@
+// Package adjustment corrects stock after damage, loss or a physical count.
+// An adjustment is a signed variance; a negative one takes lots in the
+// tenant's costing order, a positive one becomes a new lot. When the
+// variance is above the tenant's approval threshold the adjustment waits
+// as pending until it is approved or rejected.
+package adjustment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"atlasq/internal/inventory"
+	"atlasq/internal/location"
+	"atlasq/internal/tenant"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusApplied  = "applied"
+	StatusRejected = "rejected"
+)
+
+// Reason codes accepted in reason_code.
+var Reasons = map[string]bool{
+	"damage":  true,
+	"loss":    true,
+	"theft":   true,
+	"expired": true,
+	"count":   true,
+	"found":   true,
+	"other":   true,
+}
+
+// model ของ stock_movement ที่ adjustment เขียน
+const movementModel = "adjustment"
+
+var (
+	ErrNotFound        = errors.New("adjustment not found")
+	ErrNotPending      = errors.New("adjustment is not pending")
+	ErrInvalidReason   = errors.New("reason_code must be one of damage, loss, theft, expired, count, found, other")
+	ErrQuantityMode    = errors.New("exactly one of counted_quantity or quantity is required")
+	ErrZeroQuantity    = errors.New("quantity must not be 0")
+	ErrNegativeCounted = errors.New("counted_quantity must be >= 0")
+	ErrNoRequester     = errors.New("user_id of the requester is required when the adjustment needs approval")
+	ErrNoReviewer      = errors.New("user_id of the reviewer is required")
+	ErrSelfReview      = errors.New("an adjustment cannot be reviewed by the user who requested it")
+	ErrBelowReserve    = errors.New("adjustment would leave less stock than is reserved for open orders, release the reservations first")
+)
+
+// IsInvalid reports whether err is a client error from this package,
+// including ErrNotFound, ErrNotPending, ErrSelfReview and ErrBelowReserve
+// which the API maps to their own status codes.
+func IsInvalid(err error) bool {
+	return location.IsInvalid(err) || errors.Is(err, ErrNotFound) || errors.Is(err, ErrNotPending) ||
+		errors.Is(err, ErrInvalidReason) || errors.Is(err, ErrQuantityMode) || errors.Is(err, ErrZeroQuantity) ||
+		errors.Is(err, ErrNegativeCounted) || errors.Is(err, ErrNoRequester) || errors.Is(err, ErrNoReviewer) ||
+		errors.Is(err, ErrSelfReview) || errors.Is(err, ErrBelowReserve)
+}
+
+// Request is an adjustment of one product in base units. Either Counted
+// (what was physically counted; of the bin when LocationID is set) or
+// Delta is set. UnitCost is the cost per base unit of a positive
+// adjustment; nil uses the cost of the stock's newest lot.
+type Request struct {
+	WarehouseID int64
+	ProductID   int64
+	LocationID  *int64
+	ReasonCode  string
+	Note        string
+	Counted     *float64
+	Delta       *float64
+	UnitCost    *float64
+	AppID       int64
+	StoreID     int64
+	RequestedBy *int64
+}
+
+// Create records the adjustment and applies it unless it needs approval.
+// It returns the adjustment id and its status.
+func Create(ctx context.Context, tx pgx.Tx, tenantID int64, settings tenant.Settings, req Request) (int64, string, error) {
+	if !Reasons[req.ReasonCode] {
+		return 0, "", ErrInvalidReason
+	}
+	if (req.Counted == nil) == (req.Delta == nil) {
+		return 0, "", ErrQuantityMode
+	}
+	if req.Delta != nil && *req.Delta == 0 {
+		return 0, "", ErrZeroQuantity
+	}
+	if req.Counted != nil && *req.Counted < 0 {
+		return 0, "", ErrNegativeCounted
+	}
+	if err := inventory.EnsureWarehouseActive(ctx, tx, tenantID, req.WarehouseID); err != nil {
+		return 0, "", err
+	}
+	if req.LocationID != nil {
+		if err := location.EnsureBin(ctx, tx, tenantID, req.WarehouseID, *req.LocationID); err != nil {
+			return 0, "", err
+		}
+	}
+	components, err := inventory.Components(ctx, tx, tenantID, req.ProductID)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to load bundle components: %w", err)
+	}
+	if len(components) > 0 {
+		return 0, "", fmt.Errorf("product_id=%d: %w", req.ProductID, inventory.ErrBundleNotStocked)
+	}
+
+	var stockID int64
+	var balance float64
+	err = tx.QueryRow(ctx,
+		`SELECT id, balance FROM stock WHERE product_id=$1 AND warehouse_id=$2 AND tenant_id=$3 FOR UPDATE`,
+		req.ProductID, req.WarehouseID, tenantID,
+	).Scan(&stockID, &balance)
+	if err == pgx.ErrNoRows {
+		return 0, "", fmt.Errorf("product_id=%d warehouse_id=%d: %w", req.ProductID, req.WarehouseID, inventory.ErrStockNotFound)
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to fetch stock: %w", err)
+	}
+
+	// count: variance = นับได้ - ที่ระบบมี (ของ bin ถ้าระบุ location)
+	var expected *float64
+	delta := 0.0
+	if req.Counted != nil {
+		current := balance
+		if req.LocationID != nil {
+			if err := tx.QueryRow(ctx,
+				`SELECT COALESCE(SUM(balance), 0) FROM lot_locations WHERE stock_id=$1 AND location_id=$2`,
+				stockID, *req.LocationID,
+			).Scan(&current); err != nil {
+				return 0, "", fmt.Errorf("failed to fetch location stock: %w", err)
+			}
+		}
+		expected = &current
+		delta = *req.Counted - current
+	} else {
+		delta = *req.Delta
+	}
+
+	status := StatusApplied
+	if settings.NeedsApproval(delta) {
+		// ต้องรู้ว่าใครขอ ไม่งั้นกันคนขอ approve เองไม่ได้
+		if req.RequestedBy == nil {
+			return 0, "", ErrNoRequester
+		}
+		status = StatusPending
+	}
+
+	var id int64
+	err = tx.QueryRow(ctx,
+		`INSERT INTO stock_adjustments (
+			tenant_id, warehouse_id, product_id, stock_id, location_id, reason_code, note,
+			expected_quantity, counted_quantity, quantity, unit_cost, status, app_id, store_id, requested_by
+		) VALUES ($1,$2,$3,$4,$5,$6,NULLIF($7,''),$8,$9,$10,$11,$12,$13,$14,$15) RETURNING id`,
+		tenantID, req.WarehouseID, req.ProductID, stockID, req.LocationID, req.ReasonCode, req.Note,
+		expected, req.Counted, delta, req.UnitCost, status, req.AppID, req.StoreID, req.RequestedBy,
+	).Scan(&id)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to insert adjustment: %w", err)
+	}
+
+	if status == StatusApplied {
+		if err := apply(ctx, tx, tenantID, settings, id); err != nil {
+			return 0, "", err
+		}
+	}
+	return id, status, nil
+}
+
+// Approve applies a pending adjustment with the variance recorded when it
+// was created. The reviewer must differ from the requester; the route
+// itself is guarded by the tenant's approver token.
+func Approve(ctx context.Context, tx pgx.Tx, tenantID int64, settings tenant.Settings, adjustmentID int64, reviewedBy *int64) error {
+	if err := review(ctx, tx, tenantID, adjustmentID, StatusApplied, reviewedBy); err != nil {
+		return err
+	}
+	return apply(ctx, tx, tenantID, settings, adjustmentID)
+}
+
+// Reject closes a pending adjustment without touching stock.
+func Reject(ctx context.Context, tx pgx.Tx, tenantID, adjustmentID int64, reviewedBy *int64) error {
+	return review(ctx, tx, tenantID, adjustmentID, StatusRejected, reviewedBy)
+}
+
+func review(ctx context.Context, tx pgx.Tx, tenantID, adjustmentID int64, status string, reviewedBy *int64) error {
+	if reviewedBy == nil {
+		return ErrNoReviewer
+	}
+	var current string
+	var requestedBy *int64
+	err := tx.QueryRow(ctx,
+		`SELECT status, requested_by FROM stock_adjustments WHERE id=$1 AND tenant_id=$2 FOR UPDATE`, adjustmentID, tenantID,
+	).Scan(&current, &requestedBy)
+	if err == pgx.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch adjustment: %w", err)
+	}
+	if current != StatusPending {
+		return fmt.Errorf("adjustment_id=%d is %s: %w", adjustmentID, current, ErrNotPending)
+	}
+	if requestedBy == nil || *requestedBy == *reviewedBy {
+		return ErrSelfReview
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE stock_adjustments SET status=$1, reviewed_by=$2, reviewed_date=NOW(), updated_date=NOW() WHERE id=$3`,
+		status, reviewedBy, adjustmentID,
+	); err != nil {
+		return fmt.Errorf("failed to update adjustment: %w", err)
+	}
+	return nil
+}
+
+type adjustment struct {
+	ID         int64
+	StockID    int64
+	ProductID  int64
+	LocationID *int64
+	Quantity   float64
+	UnitCost   *float64
+	AppID      int64
+	StoreID    int64
+}
+
+// apply changes stock, stock_balance, lot (และ bin) ตาม variance และเขียน movement 'adjust'
+func apply(ctx context.Context, tx pgx.Tx, tenantID int64, settings tenant.Settings, adjustmentID int64) error {
+	var a adjustment
+	err := tx.QueryRow(ctx,
+		`SELECT id, stock_id, product_id, location_id, quantity, unit_cost, app_id, store_id
+		 FROM stock_adjustments WHERE id=$1 AND tenant_id=$2`,
+		adjustmentID, tenantID,
+	).Scan(&a.ID, &a.StockID, &a.ProductID, &a.LocationID, &a.Quantity, &a.UnitCost, &a.AppID, &a.StoreID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch adjustment: %w", err)
+	}
+
+	var balance, reserve float64
+	if err := tx.QueryRow(ctx,
+		`SELECT balance, reserve FROM stock WHERE id=$1 FOR UPDATE`, a.StockID,
+	).Scan(&balance, &reserve); err != nil {
+		return fmt.Errorf("failed to fetch stock: %w", err)
+	}
+
+	switch {
+	case a.Quantity > 0:
+		err = applyGain(ctx, tx, tenantID, a, balance, reserve)
+	case a.Quantity < 0:
+		err = applyLoss(ctx, tx, tenantID, settings, a, balance, reserve)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE stock_adjustments SET applied_date=NOW(), updated_date=NOW() WHERE id=$1`, a.ID,
+	); err != nil {
+		return fmt.Errorf("failed to update adjustment: %w", err)
+	}
+	return nil
+}
+
+// applyGain เพิ่มของเป็น lot ใหม่ ต้นทุน unit_cost หรือต้นทุนของ lot ล่าสุด
+func applyGain(ctx context.Context, tx pgx.Tx, tenantID int64, a adjustment, balance, reserve float64) error {
+	var cost float64
+	if a.UnitCost != nil {
+		cost = *a.UnitCost
+	} else if err := tx.QueryRow(ctx,
+		`SELECT COALESCE((SELECT cost_average FROM lot WHERE stock_id=$1 ORDER BY created_date DESC, id DESC LIMIT 1), 0)`,
+		a.StockID,
+	).Scan(&cost); err != nil {
+		return fmt.Errorf("failed to fetch lot cost: %w", err)
+	}
+
+	var lotID int64
+	if err := tx.QueryRow(ctx,
+		`INSERT INTO lot (stock_id, balance, cost_fifo, cost_average, created_date)
+		 VALUES ($1,$2,$3,$3,NOW()) RETURNING id`,
+		a.StockID, a.Quantity, cost,
+	).Scan(&lotID); err != nil {
+		return fmt.Errorf("failed to insert lot: %w", err)
+	}
+	if a.LocationID != nil {
+		if err := location.Put(ctx, tx, tenantID, a.StockID, lotID, *a.LocationID, a.Quantity); err != nil {
+			return err
+		}
+	}
+	if err := inventory.AddBalance(ctx, tx, a.StockID, a.Quantity, 0); err != nil {
+		return err
+	}
+	return insertMovement(ctx, tx, a, &lotID, a.LocationID, balance, reserve, a.Quantity, cost, cost)
+}
+
+// applyLoss ตัด lot ตาม costing_method ถ้าระบุ location ตัดเฉพาะ lot ที่อยู่ใน bin นั้น
+// ไม่ยอมให้ balance ต่ำกว่า reserve (available ติดลบ) ต้อง release reservation ก่อน
+func applyLoss(ctx context.Context, tx pgx.Tx, tenantID int64, settings tenant.Settings, a adjustment, balance, reserve float64) error {
+	if balance+a.Quantity < reserve {
+		return fmt.Errorf("product_id=%d balance=%v reserve=%v change=%v: %w", a.ProductID, balance, reserve, a.Quantity, ErrBelowReserve)
+	}
+	type lot struct {
+		ID          int64
+		Balance     float64 // ของ bin ถ้าระบุ location
+		LotBalance  float64
+		CostFIFO    float64
+		CostAverage float64
+	}
+	var rows pgx.Rows
+	var err error
+	if a.LocationID != nil {
+		rows, err = tx.Query(ctx, `
+			SELECT l.id, ll.balance, l.balance, l.cost_fifo, l.cost_average
+			FROM lot_locations ll
+			JOIN lot l ON l.id = ll.lot_id
+			WHERE ll.stock_id=$1 AND ll.location_id=$2 AND ll.balance > 0
+			ORDER BY l.created_date `+settings.LotOrder()+`
+			FOR UPDATE`, a.StockID, *a.LocationID)
+	} else {
+		rows, err = tx.Query(ctx, `
+			SELECT id, balance, balance, cost_fifo, cost_average FROM lot
+			WHERE stock_id=$1 AND balance > 0
+			ORDER BY created_date `+settings.LotOrder()+`
+			FOR UPDATE`, a.StockID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch lots: %w", err)
+	}
+	lots := []lot{}
+	for rows.Next() {
+		var l lot
+		if err := rows.Scan(&l.ID, &l.Balance, &l.LotBalance, &l.CostFIFO, &l.CostAverage); err != nil {
+			rows.Close()
+			return err
+		}
+		lots = append(lots, l)
+	}
+	rows.Close() // ต้องปิดก่อนใช้ tx ต่อ
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to fetch lots: %w", err)
+	}
+
+	remaining := -a.Quantity
+	for _, l := range lots {
+		if remaining <= 0 {
+			break
+		}
+		take := remaining
+		if l.Balance < take {
+			take = l.Balance
+		}
+		if _, err := tx.Exec(ctx, `UPDATE lot SET balance = balance - $1 WHERE id=$2`, take, l.ID); err != nil {
+			return fmt.Errorf("failed to update lot: %w", err)
+		}
+
+		picks := []location.Pick{{LocationID: a.LocationID, Quantity: take}}
+		if a.LocationID != nil {
+			if _, err := tx.Exec(ctx,
+				`UPDATE lot_locations SET balance = balance - $1, updated_date = CURRENT_TIMESTAMP
+				 WHERE lot_id=$2 AND location_id=$3`,
+				take, l.ID, *a.LocationID,
+			); err != nil {
+				return fmt.Errorf("failed to update lot location: %w", err)
+			}
+		} else {
+			picks, err = location.Take(ctx, tx, tenantID, l.ID, l.LotBalance, take)
+			if err != nil {
+				return err
+			}
+		}
+		for _, pick := range picks {
+			if err := insertMovement(ctx, tx, a, &l.ID, pick.LocationID, balance, reserve, -pick.Quantity, l.CostFIFO, l.CostAverage); err != nil {
+				return err
+			}
+			balance -= pick.Quantity
+		}
+		remaining -= take
+	}
+	if remaining > 0 {
+		return fmt.Errorf("product_id=%d: not enough lot quantity to adjust: %w", a.ProductID, inventory.ErrInsufficientStock)
+	}
+	return inventory.AddBalance(ctx, tx, a.StockID, a.Quantity, 0)
+}
+
+func insertMovement(ctx context.Context, tx pgx.Tx, a adjustment, lotID, locationID *int64, balance, reserve, change, costFIFO, costAverage float64) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO stock_movement (
+			app_id, store_id, stock_id, lot_id, balance_before, balance_after, balance_change,
+			reserve_before, reserve_after, reserve_change, cost_fifo, cost_average,
+			action, model, location_id, adjustment_id, created_date, updated_date
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$8,0,$9,$10,'adjust',$11,$12,$13,NOW(),NOW())`,
+		a.AppID, a.StoreID, a.StockID, lotID, balance, balance+change, change,
+		reserve, costFIFO, costAverage, movementModel, locationID, a.ID)
+	if err != nil {
+		return fmt.Errorf("failed to insert stock_movement: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Package allocation splits an order line over the tenant's warehouses
+// when the order does not name a warehouse. A Strategy decides the split;
+// strategies are registered by name in Strategies.
+package allocation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"atlasq/internal/inventory"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Strategy names accepted in the order's "allocation" field.
+const (
+	Priority     = "priority"
+	MostStock    = "most_stock"
+	FewestSplits = "fewest_splits"
+)
+
+var ErrUnknownStrategy = errors.New("unknown allocation strategy")
+
+// Candidate is a warehouse holding stock of the product.
+type Candidate struct {
+	WarehouseID int64
+	Priority    int
+	Available   float64
+}
+
+// Allocation is the part of a line taken from one warehouse.
+type Allocation struct {
+	WarehouseID int64
+	Quantity    float64
+}
+
+// Strategy picks warehouses for quantity from candidates, which come
+// sorted by warehouse priority then id. It may assume the candidates hold
+// at least quantity in total.
+type Strategy interface {
+	Allocate(quantity float64, candidates []Candidate) []Allocation
+}
+
+// StrategyFunc adapts a function to Strategy.
+type StrategyFunc func(quantity float64, candidates []Candidate) []Allocation
+
+func (f StrategyFunc) Allocate(quantity float64, candidates []Candidate) []Allocation {
+	return f(quantity, candidates)
+}
+
+// Strategies by name. เพิ่ม strategy ใหม่ได้โดยเพิ่มใน map นี้
+var Strategies = map[string]Strategy{
+	Priority:     StrategyFunc(byPriority),
+	MostStock:    StrategyFunc(mostStock),
+	FewestSplits: StrategyFunc(fewestSplits),
+}
+
+// Lookup returns the strategy registered as name.
+func Lookup(name string) (Strategy, error) {
+	s, ok := Strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("%q: %w", name, ErrUnknownStrategy)
+	}
+	return s, nil
+}
+
+// Candidates returns the active warehouses with available stock (balance
+// less what is reserved for other orders) of the product, by priority then id. Stock rows are locked so a concurrent order cannot
+// take the same stock.
+func Candidates(ctx context.Context, tx pgx.Tx, tenantID, productID int64) ([]Candidate, error) {
+	rows, err := tx.Query(ctx,
+		`SELECT s.warehouse_id, w.priority, s.balance - s.reserve
+		 FROM stock s
+		 JOIN warehouses w ON w.id = s.warehouse_id
+		 WHERE s.tenant_id=$1 AND s.product_id=$2 AND s.balance - s.reserve > 0
+		   AND w.status=1 AND w.deleted_date IS NULL
+		 ORDER BY w.priority, w.id
+		 FOR UPDATE OF s`,
+		tenantID, productID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []Candidate{}
+	for rows.Next() {
+		var c Candidate
+		if err := rows.Scan(&c.WarehouseID, &c.Priority, &c.Available); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+// Allocate splits quantity of the product with the named strategy. It
+// returns inventory.ErrInsufficientStock when all warehouses together do
+// not hold enough.
+func Allocate(ctx context.Context, tx pgx.Tx, tenantID, productID int64, quantity float64, strategy string) ([]Allocation, error) {
+	s, err := Lookup(strategy)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := Candidates(ctx, tx, tenantID, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch stock: %w", err)
+	}
+	total := 0.0
+	for _, c := range candidates {
+		total += c.Available
+	}
+	if total < quantity {
+		return nil, fmt.Errorf("product_id=%d required=%v available=%v: %w", productID, quantity, total, inventory.ErrInsufficientStock)
+	}
+	return s.Allocate(quantity, candidates), nil
+}
+
+// fill takes from candidates in the given order until quantity is covered.
+func fill(quantity float64, candidates []Candidate) []Allocation {
+	out := []Allocation{}
+	for _, c := range candidates {
+		if quantity <= 0 {
+			break
+		}
+		take := quantity
+		if c.Available < take {
+			take = c.Available
+		}
+		out = append(out, Allocation{WarehouseID: c.WarehouseID, Quantity: take})
+		quantity -= take
+	}
+	return out
+}
+
+func byPriority(quantity float64, candidates []Candidate) []Allocation {
+	return fill(quantity, candidates)
+}
+
+// mostStock เริ่มจาก warehouse ที่มีของมากสุด (เท่ากันใช้ priority)
+func mostStock(quantity float64, candidates []Candidate) []Allocation {
+	sorted := append([]Candidate(nil), candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Available > sorted[j].Available })
+	return fill(quantity, sorted)
+}
+
+// fewestSplits uses the highest-priority warehouse that can ship the whole
+// line; otherwise it falls back to largest stock first, which keeps the
+// number of warehouses small.
+func fewestSplits(quantity float64, candidates []Candidate) []Allocation {
+	for _, c := range candidates {
+		if c.Available >= quantity {
+			return []Allocation{{WarehouseID: c.WarehouseID, Quantity: quantity}}
+		}
+	}
+	return mostStock(quantity, candidates)
+}
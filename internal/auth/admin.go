@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const HeaderAdminToken = "X-Admin-Token"
+
+// Admin protects platform-level routes with the shared ADMIN_TOKEN.
+// When ADMIN_TOKEN is not set every request is rejected.
+func Admin() fiber.Handler {
+	token := os.Getenv("ADMIN_TOKEN")
+
+	return func(c *fiber.Ctx) error {
+		if token == "" {
+			return fiber.NewError(fiber.StatusServiceUnavailable, "admin api is disabled")
+		}
+		if subtle.ConstantTimeCompare([]byte(c.Get(HeaderAdminToken)), []byte(token)) != 1 {
+			return fiber.NewError(fiber.StatusUnauthorized, "invalid admin token")
+		}
+		return c.Next()
+	}
+}
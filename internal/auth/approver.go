@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+
+	"atlasq/internal/database"
+	"atlasq/internal/opensearchclient"
+	"atlasq/internal/tenant"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+)
+
+const HeaderApproverToken = "X-Approver-Token"
+
+var ErrTenantNotFound = errors.New("tenant not found")
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueApproverToken replaces the tenant's approver token and returns the
+// new one. The token is only returned here; the tenant keeps it apart from
+// the key/secret used by integrations.
+func IssueApproverToken(ctx context.Context, pool *database.LoggingPool, tenantID int64, actor string) (string, error) {
+	token, err := NewSecret()
+	if err != nil {
+		return "", err
+	}
+	tag, err := pool.Exec(ctx, `
+		UPDATE tenants
+		SET approver_token_hash=$1, updated_date=CURRENT_TIMESTAMP, row_updated_date=CURRENT_TIMESTAMP
+		WHERE id=$2 AND deleted_date IS NULL
+	`, hashToken(token), tenantID)
+	if err != nil {
+		return "", err
+	}
+	if tag.RowsAffected() == 0 {
+		return "", ErrTenantNotFound
+	}
+
+	opensearchclient.LogSecurity(tenantID, "approver_token_issued", actor, "approver token replaced")
+	return token, nil
+}
+
+// Approver allows the request only when X-Approver-Token matches the
+// tenant's approver token. It must run after HMAC.
+func Approver(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, ok := tenant.IDFromContext(c.UserContext())
+		if !ok {
+			return fiber.NewError(fiber.StatusUnauthorized, "tenant not authenticated")
+		}
+		token := c.Get(HeaderApproverToken)
+		if token == "" {
+			return fiber.NewError(fiber.StatusForbidden, "approver token is required")
+		}
+
+		var stored *string
+		err := pool.QueryRow(c.UserContext(),
+			`SELECT approver_token_hash FROM tenants WHERE id=$1`, tenantID,
+		).Scan(&stored)
+		if err != nil && err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to validate approver token")
+		}
+		if stored == nil || subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(*stored)) != 1 {
+			return fiber.NewError(fiber.StatusForbidden, "invalid approver token")
+		}
+		return c.Next()
+	}
+}
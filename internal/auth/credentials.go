@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewKey returns a random public API key.
+func NewKey() (string, error) {
+	k, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	return "ak_" + k, nil
+}
+
+// NewSecret returns a random signing secret.
+func NewSecret() (string, error) {
+	return randomHex(32)
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"atlasq/internal/database"
+	"atlasq/internal/tenant"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	HeaderAPIKey    = "X-Api-Key"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderSignature = "X-Signature"
+)
+
+// fiber.Ctx Locals keys set for authenticated requests.
+const (
+	LocalTenantID   = "tenant_id"
+	LocalTenantType = "tenant_type"
+)
+
+const defaultMaxSkew = 5 * time.Minute
+
+// Sign returns the hex HMAC-SHA256 of the canonical request string:
+//
+//	METHOD \n PATH \n TIMESTAMP \n hex(sha256(BODY))
+//
+// PATH is the request URI including the query string.
+func Sign(secret, method, path, timestamp string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	canonical := strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		timestamp,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(canonical))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// replayKey คือ key ใน redis ที่จำ signature ที่ใช้ไปแล้วของ tenant
+func replayKey(tenantID int64, signature string) string {
+	return "auth:hmac:seen:" + strconv.FormatInt(tenantID, 10) + ":" + signature
+}
+
+// HMAC authenticates requests signed with a tenant's key/secret pair and
+// stores the resolved tenant ID in the request's user context. Each
+// signature is accepted once: it is remembered in redis for as long as its
+// timestamp stays inside the skew window.
+func HMAC(pool *database.LoggingPool, rdb *redis.Client) fiber.Handler {
+	maxSkew := defaultMaxSkew
+	if v := os.Getenv("AUTH_MAX_SKEW"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			maxSkew = d
+		}
+	}
+
+	return func(c *fiber.Ctx) error {
+		key := c.Get(HeaderAPIKey)
+		timestamp := c.Get(HeaderTimestamp)
+		signature := c.Get(HeaderSignature)
+		if key == "" || timestamp == "" || signature == "" {
+			return fiber.NewError(fiber.StatusUnauthorized, "missing authentication headers")
+		}
+
+		// ปฏิเสธ request ที่ timestamp อยู่นอก window เพื่อกัน replay
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return fiber.NewError(fiber.StatusUnauthorized, "invalid timestamp")
+		}
+		skew := time.Since(time.Unix(ts, 0))
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > maxSkew {
+			return fiber.NewError(fiber.StatusUnauthorized, "request timestamp outside allowed window")
+		}
+
+		var tenantID int64
+		var tenantType, secret string
+		var pendingSecret *string
+		var graceExpired, active bool
+		err = pool.QueryRow(c.UserContext(),
+			`SELECT id, type, secret, pending_secret, COALESCE(secret_grace_until <= CURRENT_TIMESTAMP, false), `+tenant.ActiveSQL+`
+			 FROM tenants WHERE key=$1`, key,
+		).Scan(&tenantID, &tenantType, &secret, &pendingSecret, &graceExpired, &active)
+		if err == pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusUnauthorized, "invalid api key")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to validate api key")
+		}
+
+		// grace period หมดแล้ว -> pending secret กลายเป็น secret หลัก secret เก่าใช้ไม่ได้อีก
+		if pendingSecret != nil && graceExpired {
+			if err := PromoteSecret(c.UserContext(), pool, tenantID, "system"); err != nil && err != ErrNoPendingSecret {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to complete secret rotation")
+			}
+			secret, pendingSecret = *pendingSecret, nil
+		}
+
+		sig := []byte(strings.ToLower(signature))
+		ok := hmac.Equal([]byte(Sign(secret, c.Method(), c.OriginalURL(), timestamp, c.Body())), sig)
+		if !ok && pendingSecret != nil {
+			ok = hmac.Equal([]byte(Sign(*pendingSecret, c.Method(), c.OriginalURL(), timestamp, c.Body())), sig)
+		}
+		if !ok {
+			return fiber.NewError(fiber.StatusUnauthorized, "invalid signature")
+		}
+
+		// timestamp ห่างจากตอนนี้ได้ ±maxSkew signature เดียวกันจึงใช้ได้นานสุด 2*maxSkew
+		// redis ล่มให้ fail closed ต่างจาก rate limit เพราะถ้าปล่อยผ่านจะ replay ได้
+		fresh, err := rdb.SetNX(c.UserContext(), replayKey(tenantID, string(sig)), 1, 2*maxSkew).Result()
+		if err != nil {
+			log.Printf("hmac replay check failed tenant=%d: %v", tenantID, err)
+			return fiber.NewError(fiber.StatusServiceUnavailable, "failed to verify request")
+		}
+		if !fresh {
+			return fiber.NewError(fiber.StatusUnauthorized, "request already used")
+		}
+
+		// เช็คหลัง signature เพื่อไม่บอกสถานะ tenant กับคนที่ไม่มี secret
+		if !active {
+			return fiber.NewError(fiber.StatusForbidden, tenant.ErrInactive.Error())
+		}
+
+		c.Locals(LocalTenantID, tenantID)
+		c.Locals(LocalTenantType, tenantType)
+		c.SetUserContext(tenant.WithID(c.UserContext(), tenantID))
+		return c.Next()
+	}
+}
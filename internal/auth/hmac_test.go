@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestSign(t *testing.T) {
+	// ค่าที่คาดไว้คำนวณแยกด้วย openssl dgst -sha256 -hmac
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		timestamp string
+		body      []byte
+		want      string
+	}{
+		{
+			name: "post with query and body", method: "POST", path: "/api/v1/orders?dry_run=1",
+			timestamp: "1700000000", body: []byte(`{"sku":"A-1"}`),
+			want: "6f8b491830f8893add69ce6c676db3c4274b9c1e43719ab90d62024dcb5c9463",
+		},
+		{
+			name: "lowercase method", method: "post", path: "/api/v1/orders?dry_run=1",
+			timestamp: "1700000000", body: []byte(`{"sku":"A-1"}`),
+			want: "6f8b491830f8893add69ce6c676db3c4274b9c1e43719ab90d62024dcb5c9463",
+		},
+		{
+			name: "get without body", method: "GET", path: "/api/v1/stock",
+			timestamp: "1700000000", body: nil,
+			want: "732d99a2f2dbdad64fed379d28a0e319604e409ace45d3d2e99dfa5523e9d2a1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign("s3cret", tt.method, tt.path, tt.timestamp, tt.body); got != tt.want {
+				t.Errorf("Sign = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignCoversEveryField(t *testing.T) {
+	base := Sign("s3cret", "POST", "/api/v1/orders", "1700000000", []byte(`{}`))
+	tests := []struct {
+		name string
+		sig  string
+	}{
+		{"secret", Sign("other", "POST", "/api/v1/orders", "1700000000", []byte(`{}`))},
+		{"method", Sign("s3cret", "PUT", "/api/v1/orders", "1700000000", []byte(`{}`))},
+		{"path", Sign("s3cret", "POST", "/api/v1/orders?x=1", "1700000000", []byte(`{}`))},
+		{"timestamp", Sign("s3cret", "POST", "/api/v1/orders", "1700000001", []byte(`{}`))},
+		{"body", Sign("s3cret", "POST", "/api/v1/orders", "1700000000", []byte(`{ }`))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.sig == base {
+				t.Errorf("changing %s did not change the signature", tt.name)
+			}
+		})
+	}
+}
+
+// request ที่ header ไม่ครบหรือ timestamp ใช้ไม่ได้ต้องถูกปฏิเสธก่อนแตะ db/redis
+func TestHMACRejectsBeforeLookup(t *testing.T) {
+	t.Setenv("AUTH_MAX_SKEW", "")
+	app := fiber.New()
+	app.Get("/", HMAC(nil, nil), func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	now := time.Now().Unix()
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+	}{
+		{name: "missing signature", timestamp: strconv.FormatInt(now, 10)},
+		{name: "non-numeric timestamp", timestamp: "yesterday", signature: "ab"},
+		{name: "too old", timestamp: strconv.FormatInt(now-int64(defaultMaxSkew/time.Second)-60, 10), signature: "ab"},
+		{name: "too far ahead", timestamp: strconv.FormatInt(now+int64(defaultMaxSkew/time.Second)+60, 10), signature: "ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set(HeaderAPIKey, "key")
+			req.Header.Set(HeaderTimestamp, tt.timestamp)
+			if tt.signature != "" {
+				req.Header.Set(HeaderSignature, tt.signature)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != fiber.StatusUnauthorized {
+				t.Errorf("status = %d, want 401", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestReplayKeyPerTenant(t *testing.T) {
+	if replayKey(1, "ab") == replayKey(2, "ab") {
+		t.Error("replay key must differ per tenant")
+	}
+	if replayKey(1, "ab") == replayKey(1, "ac") {
+		t.Error("replay key must differ per signature")
+	}
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"atlasq/internal/database"
+	"atlasq/internal/opensearchclient"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const defaultRotationGrace = 24 * time.Hour
+
+var (
+	ErrRotationInProgress = errors.New("a secret rotation is already in progress")
+	ErrNoPendingSecret    = errors.New("no secret rotation in progress")
+)
+
+// RotationGrace is how long the old and the pending secret both verify.
+func RotationGrace() time.Duration {
+	if v := os.Getenv("SECRET_ROTATION_GRACE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultRotationGrace
+}
+
+type Rotation struct {
+	PendingSecret string    `json:"pending_secret"`
+	GraceUntil    time.Time `json:"grace_until"`
+}
+
+// RotateSecret issues a pending secret for the tenant. Until GraceUntil
+// both the current and the pending secret are accepted; afterwards the
+// pending secret replaces the current one.
+func RotateSecret(ctx context.Context, pool *database.LoggingPool, tenantID int64, actor string) (*Rotation, error) {
+	secret, err := NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	grace := RotationGrace()
+	r := Rotation{PendingSecret: secret}
+	err = pool.QueryRow(ctx, `
+		UPDATE tenants
+		SET pending_secret=$1,
+			secret_grace_until=CURRENT_TIMESTAMP + $2 * INTERVAL '1 second',
+			secret_rotated_date=CURRENT_TIMESTAMP,
+			updated_date=CURRENT_TIMESTAMP, row_updated_date=CURRENT_TIMESTAMP
+		WHERE id=$3 AND pending_secret IS NULL AND deleted_date IS NULL
+		RETURNING secret_grace_until
+	`, secret, int64(grace/time.Second), tenantID).Scan(&r.GraceUntil)
+	if err == pgx.ErrNoRows {
+		return nil, ErrRotationInProgress
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	opensearchclient.LogSecurity(tenantID, "secret_rotation_started", actor,
+		"pending secret issued, grace until "+r.GraceUntil.Format(time.RFC3339))
+	return &r, nil
+}
+
+// PromoteSecret makes the pending secret current and expires the old one.
+func PromoteSecret(ctx context.Context, pool *database.LoggingPool, tenantID int64, actor string) error {
+	tag, err := pool.Exec(ctx, `
+		UPDATE tenants
+		SET secret=pending_secret, pending_secret=NULL, secret_grace_until=NULL,
+			updated_date=CURRENT_TIMESTAMP, row_updated_date=CURRENT_TIMESTAMP
+		WHERE id=$1 AND pending_secret IS NOT NULL
+	`, tenantID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoPendingSecret
+	}
+
+	opensearchclient.LogSecurity(tenantID, "secret_rotation_completed", actor, "previous secret expired")
+	return nil
+}
@@ -2,15 +2,21 @@ package database
 
 import (
 	"context"
+	"strconv"
 	"time"
 
 	"atlasq/internal/opensearchclient"
+	"atlasq/internal/tenant"
 
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
+// LoggingPool logs every statement and scopes it to the tenant in ctx.
+// When ctx carries a tenant ID (tenant.WithID) each call runs in a
+// transaction with app.tenant_id set, so the row-level security policies
+// apply even when a query forgets its tenant_id filter.
 type LoggingPool struct {
 	*pgxpool.Pool
 }
@@ -23,7 +29,20 @@ func (lp *LoggingPool) Query(ctx context.Context, sql string, args ...interface{
 		"timestamp": time.Now(),
 	}
 	opensearchclient.LogDebug("system", "query", event)
-	return lp.Pool.Query(ctx, sql, args...)
+
+	if _, ok := tenant.IDFromContext(ctx); !ok {
+		return lp.Pool.Query(ctx, sql, args...)
+	}
+	tx, err := lp.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return nil, err
+	}
+	return &txRows{Rows: rows, ctx: ctx, tx: tx}, nil
 }
 
 func (lp *LoggingPool) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
@@ -33,7 +52,21 @@ func (lp *LoggingPool) Exec(ctx context.Context, sql string, args ...interface{}
 		"timestamp": time.Now(),
 	}
 	opensearchclient.LogDebug("system", "exec", event)
-	return lp.Pool.Exec(ctx, sql, args...)
+
+	if _, ok := tenant.IDFromContext(ctx); !ok {
+		return lp.Pool.Exec(ctx, sql, args...)
+	}
+	tx, err := lp.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return tag, tx.Commit(ctx)
 }
 
 func (lp *LoggingPool) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
@@ -43,7 +76,95 @@ func (lp *LoggingPool) QueryRow(ctx context.Context, sql string, args ...interfa
 		"timestamp": time.Now(),
 	}
 	opensearchclient.LogDebug("system", "queryrow", event)
-	return lp.Pool.QueryRow(ctx, sql, args...)
+
+	if _, ok := tenant.IDFromContext(ctx); !ok {
+		return lp.Pool.QueryRow(ctx, sql, args...)
+	}
+	return &txRow{lp: lp, ctx: ctx, sql: sql, args: args}
+}
+
+// BeginTx starts a transaction scoped to the tenant in ctx.
+func (lp *LoggingPool) BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	tx, err := lp.Pool.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if id, ok := tenant.IDFromContext(ctx); ok {
+		if err := SetTenant(ctx, tx, id); err != nil {
+			_ = tx.Rollback(ctx)
+			return nil, err
+		}
+	}
+	return tx, nil
+}
+
+func (lp *LoggingPool) Begin(ctx context.Context) (pgx.Tx, error) {
+	return lp.BeginTx(ctx, pgx.TxOptions{})
+}
+
+// SetTenant ตั้ง app.tenant_id แบบ local (หายไปเมื่อจบ transaction)
+// ใช้ตรงๆ เมื่อรู้ tenant หลังเริ่ม tx แล้ว เช่นตอน provision tenant ใหม่
+func SetTenant(ctx context.Context, tx pgx.Tx, id int64) error {
+	_, err := tx.Exec(ctx, `SELECT set_config('app.tenant_id', $1, true)`, strconv.FormatInt(id, 10))
+	return err
+}
+
+// txRows commits the wrapping transaction once the rows are consumed or closed.
+type txRows struct {
+	pgx.Rows
+	ctx  context.Context
+	tx   pgx.Tx
+	done bool
+}
+
+func (r *txRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.finish()
+	return false
+}
+
+func (r *txRows) Close() {
+	r.finish()
+}
+
+func (r *txRows) finish() {
+	if r.done {
+		return
+	}
+	r.done = true
+	r.Rows.Close()
+	if r.Rows.Err() != nil {
+		_ = r.tx.Rollback(r.ctx)
+		return
+	}
+	_ = r.tx.Commit(r.ctx)
+}
+
+// txRow runs the query inside a tenant transaction when Scan is called.
+type txRow struct {
+	lp   *LoggingPool
+	ctx  context.Context
+	sql  string
+	args []interface{}
+}
+
+func (r *txRow) Scan(dest ...interface{}) error {
+	tx, err := r.lp.BeginTx(r.ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(r.ctx)
+
+	err = tx.QueryRow(r.ctx, r.sql, r.args...).Scan(dest...)
+	if err != nil && err != pgx.ErrNoRows {
+		return err
+	}
+	if cerr := tx.Commit(r.ctx); cerr != nil {
+		return cerr
+	}
+	return err
 }
 
 // WrapPool returns a LoggingPool that wraps a pgxpool.Pool
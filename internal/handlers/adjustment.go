@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"atlasq/internal/adjustment"
+	"atlasq/internal/database"
+	"atlasq/internal/inventory"
+	"atlasq/internal/tenant"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+)
+
+// Adjustment จำนวนเป็นหน่วยฐาน quantity คือ variance ที่ใช้กับ stock
+type Adjustment struct {
+	ID               int64      `json:"id"`
+	WarehouseID      int64      `json:"warehouse_id"`
+	ProductID        int64      `json:"product_id"`
+	StockID          int64      `json:"stock_id"`
+	LocationID       *int64     `json:"location_id,omitempty"`
+	ReasonCode       string     `json:"reason_code"`
+	Note             *string    `json:"note,omitempty"`
+	ExpectedQuantity *float64   `json:"expected_quantity,omitempty"`
+	CountedQuantity  *float64   `json:"counted_quantity,omitempty"`
+	Quantity         float64    `json:"quantity"`
+	UnitCost         *float64   `json:"unit_cost,omitempty"`
+	Status           string     `json:"status"`
+	AppID            int64      `json:"app_id"`
+	StoreID          int64      `json:"store_id"`
+	RequestedBy      *int64     `json:"requested_by,omitempty"`
+	ReviewedBy       *int64     `json:"reviewed_by,omitempty"`
+	ReviewedDate     *time.Time `json:"reviewed_date,omitempty"`
+	AppliedDate      *time.Time `json:"applied_date,omitempty"`
+	CreatedDate      time.Time  `json:"created_date"`
+	UpdatedDate      time.Time  `json:"updated_date"`
+}
+
+const adjustmentColumns = `id, warehouse_id, product_id, stock_id, location_id, reason_code, note,
+	expected_quantity, counted_quantity, quantity, unit_cost, status, app_id, store_id,
+	requested_by, reviewed_by, reviewed_date, applied_date, created_date, updated_date`
+
+func scanAdjustment(row pgx.Row, a *Adjustment) error {
+	return row.Scan(&a.ID, &a.WarehouseID, &a.ProductID, &a.StockID, &a.LocationID, &a.ReasonCode, &a.Note,
+		&a.ExpectedQuantity, &a.CountedQuantity, &a.Quantity, &a.UnitCost, &a.Status, &a.AppID, &a.StoreID,
+		&a.RequestedBy, &a.ReviewedBy, &a.ReviewedDate, &a.AppliedDate, &a.CreatedDate, &a.UpdatedDate)
+}
+
+// AdjustmentRequest ส่ง counted_quantity (ผลนับ) หรือ quantity (delta +/-) อย่างใดอย่างหนึ่ง
+// unit ใช้กับทั้งสองค่า unit_cost เป็นต้นทุนต่อ unit ที่ส่งมา ใช้เมื่อ variance เป็นบวก
+type AdjustmentRequest struct {
+	WarehouseID     int64    `json:"warehouse_id"`
+	ProductID       int64    `json:"product_id"`
+	SKU             string   `json:"sku,omitempty"`
+	LocationID      *int64   `json:"location_id,omitempty"`
+	ReasonCode      string   `json:"reason_code"`
+	Note            string   `json:"note"`
+	CountedQuantity *float64 `json:"counted_quantity,omitempty"`
+	Quantity        *float64 `json:"quantity,omitempty"`
+	Unit            string   `json:"unit,omitempty"` // ว่าง = หน่วยฐาน
+	UnitCost        *float64 `json:"unit_cost,omitempty"`
+	AppID           int64    `json:"app_id"`
+	StoreID         int64    `json:"store_id"`
+	UserID          *int64   `json:"user_id,omitempty"`
+}
+
+// CreateAdjustment ปรับ stock ทันที หรือคืน 202 กับ status pending ถ้า variance เกิน
+// adjustment_approval_threshold ของ tenant (กรณีนี้ต้องส่ง user_id ของผู้ขอ)
+func CreateAdjustment(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+
+		var req AdjustmentRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		if req.AppID == 0 || req.StoreID == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "app_id and store_id are required")
+		}
+		if req.UnitCost != nil && *req.UnitCost < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "unit_cost must be >= 0")
+		}
+
+		ctx := c.UserContext()
+		tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to begin transaction")
+		}
+		defer func() { _ = tx.Rollback(ctx) }()
+
+		settings, err := tenant.LoadSettings(ctx, tx, tenantID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to load tenant settings")
+		}
+		req.WarehouseID = settings.WarehouseOrDefault(req.WarehouseID)
+		if req.WarehouseID == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "warehouse_id is required")
+		}
+		if err := resolveItemProduct(ctx, tx, tenantID, &req.ProductID, req.SKU); err != nil {
+			return err
+		}
+		if err := inventory.EnsureProductActive(ctx, tx, tenantID, req.ProductID); err != nil {
+			return inventoryError(err)
+		}
+
+		ar := adjustment.Request{
+			WarehouseID: req.WarehouseID,
+			ProductID:   req.ProductID,
+			LocationID:  req.LocationID,
+			ReasonCode:  req.ReasonCode,
+			Note:        req.Note,
+			AppID:       req.AppID,
+			StoreID:     req.StoreID,
+			RequestedBy: req.UserID,
+		}
+		// แปลงเป็นหน่วยฐาน (ต้นทุนต่อหน่วยฐาน = unit_cost / อัตราแปลง)
+		factor := 1.0
+		if req.Unit != "" {
+			factor, err = inventory.ToBase(ctx, tx, tenantID, req.ProductID, req.Unit, 1)
+			if err != nil {
+				return inventoryError(err)
+			}
+		}
+		if req.CountedQuantity != nil {
+			v := *req.CountedQuantity * factor
+			ar.Counted = &v
+		}
+		if req.Quantity != nil {
+			v := *req.Quantity * factor
+			ar.Delta = &v
+		}
+		if req.UnitCost != nil {
+			v := *req.UnitCost / factor
+			ar.UnitCost = &v
+		}
+
+		id, status, err := adjustment.Create(ctx, tx, tenantID, settings, ar)
+		if err != nil {
+			return adjustmentError(err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to commit transaction")
+		}
+
+		a, err := loadAdjustment(ctx, pool, tenantID, id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch adjustment")
+		}
+		if status == adjustment.StatusPending {
+			return c.Status(fiber.StatusAccepted).JSON(a)
+		}
+		return c.Status(fiber.StatusCreated).JSON(a)
+	}
+}
+
+func ListAdjustments(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		page, limit, offset := pageParams(c)
+
+		args := []interface{}{tenantID}
+		where := []string{"tenant_id=$1"}
+		if v := c.Query("status"); v != "" {
+			args = append(args, v)
+			where = append(where, fmt.Sprintf("status=$%d", len(args)))
+		}
+		if v := c.Query("reason_code"); v != "" {
+			args = append(args, v)
+			where = append(where, fmt.Sprintf("reason_code=$%d", len(args)))
+		}
+		for _, col := range []string{"warehouse_id", "product_id"} {
+			if v := c.Query(col); v != "" {
+				n, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					return fiber.NewError(fiber.StatusBadRequest, "invalid "+col)
+				}
+				args = append(args, n)
+				where = append(where, fmt.Sprintf("%s=$%d", col, len(args)))
+			}
+		}
+		whereSQL := strings.Join(where, " AND ")
+
+		var total int64
+		if err := pool.QueryRow(c.UserContext(), `SELECT COUNT(*) FROM stock_adjustments WHERE `+whereSQL, args...).Scan(&total); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to count adjustments")
+		}
+
+		args = append(args, limit, offset)
+		rows, err := pool.Query(c.UserContext(), fmt.Sprintf(
+			`SELECT %s FROM stock_adjustments WHERE %s ORDER BY id DESC LIMIT $%d OFFSET $%d`,
+			adjustmentColumns, whereSQL, len(args)-1, len(args),
+		), args...)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list adjustments")
+		}
+		defer rows.Close()
+
+		adjustments := []Adjustment{}
+		for rows.Next() {
+			var a Adjustment
+			if err := scanAdjustment(rows, &a); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to read adjustment")
+			}
+			adjustments = append(adjustments, a)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list adjustments")
+		}
+
+		return c.JSON(fiber.Map{
+			"data":  adjustments,
+			"page":  page,
+			"limit": limit,
+			"total": total,
+		})
+	}
+}
+
+func GetAdjustment(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid adjustment id")
+		}
+		a, err := loadAdjustment(c.UserContext(), pool, tenantID, int64(id))
+		if err != nil {
+			return adjustmentError(err)
+		}
+		return c.JSON(a)
+	}
+}
+
+// ReviewAdjustmentRequest user_id ของผู้ review จำเป็น และต้องไม่ใช่คนที่ขอ adjustment
+type ReviewAdjustmentRequest struct {
+	UserID *int64 `json:"user_id"`
+}
+
+// ApproveAdjustment ใช้ variance ที่บันทึกไว้ตอนสร้างกับ stock
+// route นี้ต้องส่ง X-Approver-Token (auth.Approver) มาด้วย
+func ApproveAdjustment(pool *database.LoggingPool) fiber.Handler {
+	return reviewAdjustment(pool, true)
+}
+
+// RejectAdjustment ปิด adjustment ที่ pending โดยไม่แตะ stock
+func RejectAdjustment(pool *database.LoggingPool) fiber.Handler {
+	return reviewAdjustment(pool, false)
+}
+
+func reviewAdjustment(pool *database.LoggingPool, approve bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid adjustment id")
+		}
+		var req ReviewAdjustmentRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+			}
+		}
+
+		ctx := c.UserContext()
+		tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to begin transaction")
+		}
+		defer func() { _ = tx.Rollback(ctx) }()
+
+		if approve {
+			settings, err := tenant.LoadSettings(ctx, tx, tenantID)
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to load tenant settings")
+			}
+			err = adjustment.Approve(ctx, tx, tenantID, settings, int64(id), req.UserID)
+		} else {
+			err = adjustment.Reject(ctx, tx, tenantID, int64(id), req.UserID)
+		}
+		if err != nil {
+			return adjustmentError(err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to commit transaction")
+		}
+
+		a, err := loadAdjustment(ctx, pool, tenantID, int64(id))
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch adjustment")
+		}
+		return c.JSON(a)
+	}
+}
+
+// adjustmentError แปลง error จาก package adjustment เป็น response
+func adjustmentError(err error) error {
+	if errors.Is(err, adjustment.ErrNotFound) {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+	if errors.Is(err, adjustment.ErrNotPending) || errors.Is(err, adjustment.ErrBelowReserve) {
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	}
+	if errors.Is(err, adjustment.ErrSelfReview) {
+		return fiber.NewError(fiber.StatusForbidden, err.Error())
+	}
+	if adjustment.IsInvalid(err) {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return fiber.NewError(fiber.StatusInternalServerError, "failed to process adjustment")
+}
+
+func loadAdjustment(ctx context.Context, q inventory.Queryer, tenantID, id int64) (Adjustment, error) {
+	var a Adjustment
+	err := scanAdjustment(q.QueryRow(ctx,
+		`SELECT `+adjustmentColumns+` FROM stock_adjustments WHERE id=$1 AND tenant_id=$2`, id, tenantID), &a)
+	if err == pgx.ErrNoRows {
+		return Adjustment{}, adjustment.ErrNotFound
+	}
+	return a, err
+}
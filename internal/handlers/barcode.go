@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"time"
+
+	"atlasq/internal/database"
+	"atlasq/internal/inventory"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+)
+
+type Barcode struct {
+	ID          int64     `json:"id"`
+	ProductID   int64     `json:"product_id"`
+	Barcode     string    `json:"barcode"`
+	CreatedDate time.Time `json:"created_date"`
+}
+
+type BarcodeRequest struct {
+	Barcode string `json:"barcode"`
+}
+
+// LookupProduct resolves ?code= (a SKU or a barcode) to a product id for scanners.
+func LookupProduct(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		code := c.Query("code")
+		if code == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "code is required")
+		}
+
+		id, matchedBy, err := inventory.ResolveCode(c.UserContext(), pool, tenantID, code)
+		if inventory.IsInvalid(err) {
+			return fiber.NewError(fiber.StatusNotFound, "product not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to lookup product")
+		}
+
+		return c.JSON(fiber.Map{
+			"product_id": id,
+			"code":       code,
+			"matched_by": matchedBy,
+		})
+	}
+}
+
+func ListBarcodes(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid product id")
+		}
+
+		rows, err := pool.Query(c.UserContext(),
+			`SELECT id, product_id, barcode, created_date FROM product_barcodes
+			 WHERE tenant_id=$1 AND product_id=$2 ORDER BY id`, tenantID, id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list barcodes")
+		}
+		defer rows.Close()
+
+		barcodes := []Barcode{}
+		for rows.Next() {
+			var b Barcode
+			if err := rows.Scan(&b.ID, &b.ProductID, &b.Barcode, &b.CreatedDate); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to read barcode")
+			}
+			barcodes = append(barcodes, b)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list barcodes")
+		}
+
+		return c.JSON(fiber.Map{"data": barcodes})
+	}
+}
+
+func AddBarcode(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid product id")
+		}
+
+		var req BarcodeRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		if len(req.Barcode) == 0 || len(req.Barcode) > 64 {
+			return fiber.NewError(fiber.StatusBadRequest, "barcode is required and must be <= 64 characters")
+		}
+
+		// product ต้องเป็นของ tenant นี้ (RLS กันอยู่แล้วแต่ต้องตอบ 404 ให้ถูก)
+		var b Barcode
+		err = pool.QueryRow(c.UserContext(),
+			`INSERT INTO product_barcodes (tenant_id, product_id, barcode)
+			 SELECT $1, id, $3 FROM product WHERE id=$2 AND tenant_id=$1
+			 RETURNING id, product_id, barcode, created_date`,
+			tenantID, id, req.Barcode,
+		).Scan(&b.ID, &b.ProductID, &b.Barcode, &b.CreatedDate)
+		if err == pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "product not found")
+		}
+		if isUniqueViolation(err) {
+			return fiber.NewError(fiber.StatusConflict, "barcode already exists")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to add barcode")
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(b)
+	}
+}
+
+func DeleteBarcode(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid product id")
+		}
+
+		tag, err := pool.Exec(c.UserContext(),
+			`DELETE FROM product_barcodes WHERE tenant_id=$1 AND product_id=$2 AND barcode=$3`,
+			tenantID, id, c.Params("barcode"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to delete barcode")
+		}
+		if tag.RowsAffected() == 0 {
+			return fiber.NewError(fiber.StatusNotFound, "barcode not found")
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
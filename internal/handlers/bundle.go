@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"atlasq/internal/database"
+	"atlasq/internal/inventory"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+)
+
+type BundleRequest struct {
+	Components []inventory.Component `json:"components"`
+}
+
+func GetBundle(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid product id")
+		}
+
+		components, err := inventory.Components(c.UserContext(), pool, tenantID, int64(id))
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to load bundle components")
+		}
+		if components == nil {
+			components = []inventory.Component{}
+		}
+		return c.JSON(fiber.Map{
+			"product_id": id,
+			"components": components,
+		})
+	}
+}
+
+// SetBundle replaces the product's components. An empty list turns the
+// bundle back into a plain product. Bundles are one level deep: a bundle
+// cannot contain another bundle.
+func SetBundle(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid product id")
+		}
+		bundleID := int64(id)
+
+		var req BundleRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		seen := map[int64]bool{}
+		for _, comp := range req.Components {
+			if comp.ProductID == 0 || comp.Quantity <= 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "components need product_id and quantity > 0")
+			}
+			if comp.ProductID == bundleID {
+				return fiber.NewError(fiber.StatusBadRequest, "a bundle cannot contain itself")
+			}
+			if seen[comp.ProductID] {
+				return fiber.NewError(fiber.StatusBadRequest, "duplicate component product_id")
+			}
+			seen[comp.ProductID] = true
+		}
+
+		ctx := c.UserContext()
+		tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to start transaction")
+		}
+		defer tx.Rollback(ctx)
+
+		if err := inventory.EnsureProductActive(ctx, tx, tenantID, bundleID); err != nil {
+			return inventoryError(err)
+		}
+		if len(req.Components) > 0 {
+			var isComponent bool
+			if err := tx.QueryRow(ctx,
+				`SELECT EXISTS (SELECT 1 FROM product_bundle_components WHERE tenant_id=$1 AND component_product_id=$2)`,
+				tenantID, bundleID,
+			).Scan(&isComponent); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to check bundle")
+			}
+			if isComponent {
+				return fiber.NewError(fiber.StatusBadRequest, "product is a component of another bundle")
+			}
+		}
+
+		if _, err := tx.Exec(ctx,
+			`DELETE FROM product_bundle_components WHERE tenant_id=$1 AND bundle_product_id=$2`,
+			tenantID, bundleID,
+		); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to update bundle")
+		}
+
+		for _, comp := range req.Components {
+			if err := inventory.EnsureProductActive(ctx, tx, tenantID, comp.ProductID); err != nil {
+				return inventoryError(err)
+			}
+			nested, err := inventory.Components(ctx, tx, tenantID, comp.ProductID)
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to check bundle")
+			}
+			if len(nested) > 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "a bundle cannot contain another bundle")
+			}
+			if _, err := tx.Exec(ctx,
+				`INSERT INTO product_bundle_components (tenant_id, bundle_product_id, component_product_id, quantity)
+				 VALUES ($1,$2,$3,$4)`,
+				tenantID, bundleID, comp.ProductID, comp.Quantity,
+			); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to update bundle")
+			}
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to commit transaction")
+		}
+
+		if req.Components == nil {
+			req.Components = []inventory.Component{}
+		}
+		return c.JSON(fiber.Map{
+			"product_id": bundleID,
+			"components": req.Components,
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	tasks "atlasq/internal/tasks"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hibiken/asynq"
+)
+
+type DeadCallback struct {
+	ID           string                `json:"id"`
+	Retried      int                   `json:"retried"`
+	LastError    string                `json:"last_error"`
+	LastFailedAt time.Time             `json:"last_failed_at"`
+	Payload      tasks.CallbackPayload `json:"payload"`
+}
+
+// deadScanPageSize คือขนาดหน้าที่อ่านจาก asynq ตอนต้องกรอง tenant เอง
+const deadScanPageSize = 500
+
+// ListDeadCallbacks คืน callback ที่ retry ครบแล้ว (archived ใน queue callbacks)
+// ไม่กรอง tenant ใช้ paging ของ asynq ตรงๆ ถ้ากรอง tenant_id ต้องไล่ทุกหน้าก่อน
+// แล้วค่อยแบ่งหน้าจากรายการที่กรองแล้ว ไม่งั้นหน้าจะสั้นหรือว่างทั้งที่ tenant มี dead letter
+func ListDeadCallbacks(inspector *asynq.Inspector) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		page, limit, offset := pageParams(c)
+		tenantID := int64(c.QueryInt("tenant_id", 0))
+
+		var dead []DeadCallback
+		var total int
+		if tenantID == 0 {
+			infos, err := inspector.ListArchivedTasks(tasks.QueueCallbacks, asynq.Page(page), asynq.PageSize(limit))
+			if err != nil && !errors.Is(err, asynq.ErrQueueNotFound) {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to list dead-letter callbacks")
+			}
+			dead = deadCallbacks(infos, 0)
+			queue, err := inspector.GetQueueInfo(tasks.QueueCallbacks)
+			if err != nil && !errors.Is(err, asynq.ErrQueueNotFound) {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to list dead-letter callbacks")
+			}
+			if queue != nil {
+				total = queue.Archived
+			}
+		} else {
+			all := []DeadCallback{}
+			for p := 1; ; p++ {
+				infos, err := inspector.ListArchivedTasks(tasks.QueueCallbacks, asynq.Page(p), asynq.PageSize(deadScanPageSize))
+				if err != nil && !errors.Is(err, asynq.ErrQueueNotFound) {
+					return fiber.NewError(fiber.StatusInternalServerError, "failed to list dead-letter callbacks")
+				}
+				all = append(all, deadCallbacks(infos, tenantID)...)
+				if len(infos) < deadScanPageSize {
+					break
+				}
+			}
+			total = len(all)
+			from, to := offset, offset+limit
+			if from > total {
+				from = total
+			}
+			if to > total {
+				to = total
+			}
+			dead = all[from:to]
+		}
+
+		return c.JSON(fiber.Map{
+			"data":  dead,
+			"page":  page,
+			"limit": limit,
+			"total": total,
+		})
+	}
+}
+
+// deadCallbacks แปลง task ที่ archived เป็น DeadCallback tenantID 0 = ทุก tenant
+func deadCallbacks(infos []*asynq.TaskInfo, tenantID int64) []DeadCallback {
+	dead := []DeadCallback{}
+	for _, info := range infos {
+		if info.Type != tasks.TypeTenantCallback {
+			continue
+		}
+		var p tasks.CallbackPayload
+		if err := json.Unmarshal(info.Payload, &p); err != nil {
+			continue
+		}
+		if tenantID != 0 && p.TenantID != tenantID {
+			continue
+		}
+		dead = append(dead, DeadCallback{
+			ID:           info.ID,
+			Retried:      info.Retried,
+			LastError:    info.LastErr,
+			LastFailedAt: info.LastFailedAt,
+			Payload:      p,
+		})
+	}
+	return dead
+}
+
+// ReplayDeadCallback moves an archived callback back to pending.
+func ReplayDeadCallback(inspector *asynq.Inspector) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+
+		err := inspector.RunTask(tasks.QueueCallbacks, id)
+		if errors.Is(err, asynq.ErrTaskNotFound) || errors.Is(err, asynq.ErrQueueNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "callback not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"message": "Callback queued for replay",
+			"id":      id,
+		})
+	}
+}
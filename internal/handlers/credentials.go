@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"atlasq/internal/auth"
+	"atlasq/internal/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RotateSecret ให้ tenant ขอ secret ใหม่ของตัวเอง secret ใหม่จะถูกส่งกลับครั้งเดียวเท่านั้น
+func RotateSecret(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		return rotateSecret(c, pool, tenantID, "tenant")
+	}
+}
+
+// CompleteSecretRotation expires the old secret before the grace period ends.
+func CompleteSecretRotation(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+
+		err = auth.PromoteSecret(c.UserContext(), pool, tenantID, "tenant")
+		if err == auth.ErrNoPendingSecret {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to complete secret rotation")
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Secret rotation completed",
+		})
+	}
+}
+
+// RotateTenantSecret is the admin variant, e.g. when a secret has leaked.
+func RotateTenantSecret(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid tenant id")
+		}
+		return rotateSecret(c, pool, int64(id), "admin")
+	}
+}
+
+// IssueApproverToken (admin) ออก approver token ใหม่ให้ tenant ใช้ approve / reject
+// stock adjustment token เดิมใช้ไม่ได้ทันที token จะถูกส่งกลับครั้งเดียวเท่านั้น
+func IssueApproverToken(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid tenant id")
+		}
+		token, err := auth.IssueApproverToken(c.UserContext(), pool, int64(id), "admin")
+		if err == auth.ErrTenantNotFound {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to issue approver token")
+		}
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"approver_token": token})
+	}
+}
+
+func rotateSecret(c *fiber.Ctx, pool *database.LoggingPool, tenantID int64, actor string) error {
+	r, err := auth.RotateSecret(c.UserContext(), pool, tenantID, actor)
+	if err == auth.ErrRotationInProgress {
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to rotate secret")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":        "Secret rotation started",
+		"pending_secret": r.PendingSecret,
+		"grace_until":    r.GraceUntil,
+	})
+}
@@ -0,0 +1,13 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/jackc/pgconn"
+)
+
+// isUniqueViolation reports a Postgres unique_violation (23505).
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
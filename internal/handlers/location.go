@@ -0,0 +1,465 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"atlasq/internal/database"
+	"atlasq/internal/inventory"
+	"atlasq/internal/location"
+	"atlasq/internal/tenant"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+)
+
+type Location struct {
+	ID           int64      `json:"id"`
+	WarehouseID  int64      `json:"warehouse_id"`
+	ParentID     *int64     `json:"parent_id,omitempty"`
+	Type         string     `json:"type"`
+	Code         string     `json:"code"`
+	Name         *string    `json:"name,omitempty"`
+	PickSequence int        `json:"pick_sequence"`
+	Status       int16      `json:"status"`
+	DeletedDate  *time.Time `json:"deleted_date,omitempty"`
+	CreatedDate  time.Time  `json:"created_date"`
+	UpdatedDate  time.Time  `json:"updated_date"`
+}
+
+const locationColumns = `id, warehouse_id, parent_id, type, code, name, pick_sequence, status, deleted_date, created_date, updated_date`
+
+func scanLocation(row pgx.Row, l *Location) error {
+	return row.Scan(&l.ID, &l.WarehouseID, &l.ParentID, &l.Type, &l.Code, &l.Name, &l.PickSequence, &l.Status,
+		&l.DeletedDate, &l.CreatedDate, &l.UpdatedDate)
+}
+
+type LocationRequest struct {
+	ParentID     *int64 `json:"parent_id"`
+	Type         string `json:"type"`
+	Code         string `json:"code"`
+	Name         string `json:"name"`
+	PickSequence int    `json:"pick_sequence"`
+}
+
+// CreateLocation เพิ่ม zone/aisle/bin ใต้ warehouse
+// zone อยู่บนสุด aisle ต้องอยู่ใต้ zone และ bin ต้องอยู่ใต้ aisle
+func CreateLocation(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		warehouseID, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid warehouse id")
+		}
+
+		var req LocationRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		req.Type = strings.ToLower(strings.TrimSpace(req.Type))
+		parentType, err := location.ParentType(req.Type)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		req.Code = strings.ToUpper(strings.TrimSpace(req.Code))
+		if req.Code == "" || len(req.Code) > 50 {
+			return fiber.NewError(fiber.StatusBadRequest, "code is required and must be <= 50 characters")
+		}
+		if len(req.Name) > 255 {
+			return fiber.NewError(fiber.StatusBadRequest, "name must be <= 255 characters")
+		}
+
+		ctx := c.UserContext()
+		var exists bool
+		if err := pool.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM warehouses WHERE id=$1 AND tenant_id=$2 AND deleted_date IS NULL)`,
+			warehouseID, tenantID,
+		).Scan(&exists); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to check warehouse")
+		}
+		if !exists {
+			return fiber.NewError(fiber.StatusNotFound, "warehouse not found")
+		}
+
+		switch {
+		case parentType == "" && req.ParentID != nil:
+			return fiber.NewError(fiber.StatusBadRequest, "a zone cannot have a parent")
+		case parentType != "" && req.ParentID == nil:
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("a %s must have a %s as parent", req.Type, parentType))
+		case parentType != "":
+			var typ string
+			err := pool.QueryRow(ctx,
+				`SELECT type FROM warehouse_locations WHERE id=$1 AND tenant_id=$2 AND warehouse_id=$3 AND deleted_date IS NULL`,
+				*req.ParentID, tenantID, warehouseID,
+			).Scan(&typ)
+			if err == pgx.ErrNoRows {
+				return fiber.NewError(fiber.StatusBadRequest, "parent location not found in this warehouse")
+			}
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to check parent location")
+			}
+			if typ != parentType {
+				return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("a %s must have a %s as parent", req.Type, parentType))
+			}
+		}
+
+		var l Location
+		err = scanLocation(pool.QueryRow(ctx,
+			`INSERT INTO warehouse_locations (tenant_id, warehouse_id, parent_id, type, code, name, pick_sequence)
+			 VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING `+locationColumns,
+			tenantID, warehouseID, req.ParentID, req.Type, req.Code, nullIfEmpty(req.Name), req.PickSequence), &l)
+		if isUniqueViolation(err) {
+			return fiber.NewError(fiber.StatusConflict, "location code already exists in this warehouse")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to create location")
+		}
+		return c.Status(fiber.StatusCreated).JSON(l)
+	}
+}
+
+// ListLocations เรียงตามลำดับ pick กรองด้วย ?type= และ ?parent_id=
+func ListLocations(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		warehouseID, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid warehouse id")
+		}
+		page, limit, offset := pageParams(c)
+
+		args := []interface{}{tenantID, warehouseID}
+		where := []string{"tenant_id=$1", "warehouse_id=$2"}
+		if c.Query("include_deleted") != "true" {
+			where = append(where, "deleted_date IS NULL")
+		}
+		if v := c.Query("type"); v != "" {
+			args = append(args, strings.ToLower(v))
+			where = append(where, fmt.Sprintf("type=$%d", len(args)))
+		}
+		if v := c.Query("parent_id"); v != "" {
+			parentID, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid parent_id")
+			}
+			args = append(args, parentID)
+			where = append(where, fmt.Sprintf("parent_id=$%d", len(args)))
+		}
+		whereSQL := strings.Join(where, " AND ")
+
+		var total int64
+		if err := pool.QueryRow(c.UserContext(), `SELECT COUNT(*) FROM warehouse_locations WHERE `+whereSQL, args...).Scan(&total); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to count locations")
+		}
+
+		args = append(args, limit, offset)
+		rows, err := pool.Query(c.UserContext(), fmt.Sprintf(
+			`SELECT %s FROM warehouse_locations WHERE %s ORDER BY pick_sequence, code, id LIMIT $%d OFFSET $%d`,
+			locationColumns, whereSQL, len(args)-1, len(args),
+		), args...)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list locations")
+		}
+		defer rows.Close()
+
+		locations := []Location{}
+		for rows.Next() {
+			var l Location
+			if err := scanLocation(rows, &l); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to read location")
+			}
+			locations = append(locations, l)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list locations")
+		}
+
+		return c.JSON(fiber.Map{
+			"data":  locations,
+			"page":  page,
+			"limit": limit,
+			"total": total,
+		})
+	}
+}
+
+func GetLocation(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid location id")
+		}
+
+		var l Location
+		err = scanLocation(pool.QueryRow(c.UserContext(),
+			`SELECT `+locationColumns+` FROM warehouse_locations WHERE id=$1 AND tenant_id=$2`, id, tenantID), &l)
+		if err == pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "location not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch location")
+		}
+		return c.JSON(l)
+	}
+}
+
+type UpdateLocationRequest struct {
+	Code         *string `json:"code"`
+	Name         *string `json:"name"`
+	PickSequence *int    `json:"pick_sequence"`
+	Status       *int16  `json:"status"`
+}
+
+// UpdateLocation bin ที่ inactive รับของเพิ่มไม่ได้ แต่ยังหยิบของออกได้
+func UpdateLocation(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid location id")
+		}
+
+		var req UpdateLocationRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+
+		sets := []string{}
+		args := []interface{}{}
+		if req.Code != nil {
+			code := strings.ToUpper(strings.TrimSpace(*req.Code))
+			if code == "" || len(code) > 50 {
+				return fiber.NewError(fiber.StatusBadRequest, "code is required and must be <= 50 characters")
+			}
+			args = append(args, code)
+			sets = append(sets, fmt.Sprintf("code=$%d", len(args)))
+		}
+		if req.Name != nil {
+			if len(*req.Name) > 255 {
+				return fiber.NewError(fiber.StatusBadRequest, "name must be <= 255 characters")
+			}
+			args = append(args, nullIfEmpty(*req.Name))
+			sets = append(sets, fmt.Sprintf("name=$%d", len(args)))
+		}
+		if req.PickSequence != nil {
+			args = append(args, *req.PickSequence)
+			sets = append(sets, fmt.Sprintf("pick_sequence=$%d", len(args)))
+		}
+		if req.Status != nil {
+			if *req.Status != 0 && *req.Status != 1 {
+				return fiber.NewError(fiber.StatusBadRequest, "status must be 0 or 1")
+			}
+			args = append(args, *req.Status)
+			sets = append(sets, fmt.Sprintf("status=$%d", len(args)))
+		}
+		if len(sets) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "nothing to update")
+		}
+
+		args = append(args, id)
+		return updateLocation(c, pool, strings.Join(sets, ", "), args...)
+	}
+}
+
+// DeleteLocation soft-deletes an empty location without children.
+func DeleteLocation(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid location id")
+		}
+
+		var hasChildren, hasStock bool
+		if err := pool.QueryRow(c.UserContext(),
+			`SELECT
+			   EXISTS (SELECT 1 FROM warehouse_locations WHERE parent_id=$1 AND tenant_id=$2 AND deleted_date IS NULL),
+			   EXISTS (SELECT 1 FROM lot_locations WHERE location_id=$1 AND tenant_id=$2 AND balance > 0)`,
+			id, tenantID,
+		).Scan(&hasChildren, &hasStock); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to check location")
+		}
+		if hasChildren {
+			return fiber.NewError(fiber.StatusConflict, "location has child locations")
+		}
+		if hasStock {
+			return fiber.NewError(fiber.StatusConflict, "location still holds stock")
+		}
+
+		return updateLocation(c, pool, "deleted_date=COALESCE(deleted_date, CURRENT_TIMESTAMP)", id)
+	}
+}
+
+// updateLocation รัน UPDATE กับ location ที่ยังไม่ถูกลบของ tenant ปัจจุบัน
+// id ต้องเป็น arg ตัวสุดท้าย
+func updateLocation(c *fiber.Ctx, pool *database.LoggingPool, sets string, args ...interface{}) error {
+	tenantID, err := currentTenant(c)
+	if err != nil {
+		return err
+	}
+
+	args = append(args, tenantID)
+	var l Location
+	err = scanLocation(pool.QueryRow(c.UserContext(), fmt.Sprintf(
+		`UPDATE warehouse_locations SET %s, updated_date=CURRENT_TIMESTAMP, row_updated_date=CURRENT_TIMESTAMP
+		 WHERE id=$%d AND tenant_id=$%d AND deleted_date IS NULL
+		 RETURNING %s`,
+		sets, len(args)-1, len(args), locationColumns,
+	), args...), &l)
+	if err == pgx.ErrNoRows {
+		return fiber.NewError(fiber.StatusNotFound, "location not found")
+	}
+	if isUniqueViolation(err) {
+		return fiber.NewError(fiber.StatusConflict, "location code already exists in this warehouse")
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to update location")
+	}
+	return c.JSON(l)
+}
+
+type LocationStock struct {
+	ProductID int64   `json:"product_id"`
+	LotID     int64   `json:"lot_id"`
+	Balance   float64 `json:"balance"`
+}
+
+// GetLocationStock คืนยอดคงเหลือแยกตาม product และ lot ใน location
+func GetLocationStock(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid location id")
+		}
+
+		rows, err := pool.Query(c.UserContext(),
+			`SELECT s.product_id, ll.lot_id, ll.balance
+			 FROM lot_locations ll
+			 JOIN stock s ON s.id = ll.stock_id
+			 WHERE ll.location_id=$1 AND ll.tenant_id=$2 AND ll.balance > 0
+			 ORDER BY s.product_id, ll.lot_id`,
+			id, tenantID,
+		)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch location stock")
+		}
+		defer rows.Close()
+
+		stock := []LocationStock{}
+		for rows.Next() {
+			var s LocationStock
+			if err := rows.Scan(&s.ProductID, &s.LotID, &s.Balance); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to read location stock")
+			}
+			stock = append(stock, s)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch location stock")
+		}
+		return c.JSON(fiber.Map{"location_id": id, "data": stock})
+	}
+}
+
+type LocationMoveRequest struct {
+	AppID          int64   `json:"app_id"`
+	StoreID        int64   `json:"store_id"`
+	WarehouseID    int64   `json:"warehouse_id"`
+	ProductID      int64   `json:"product_id"`
+	SKU            string  `json:"sku,omitempty"`
+	LotID          *int64  `json:"lot_id"`
+	FromLocationID *int64  `json:"from_location_id"` // null = ของที่ยังไม่ได้เก็บเข้า bin
+	ToLocationID   int64   `json:"to_location_id"`
+	Quantity       float64 `json:"quantity"`
+	Unit           string  `json:"unit,omitempty"`
+}
+
+// MoveLocationStock ย้ายของระหว่าง bin ใน warehouse เดียวกัน ยอด stock ไม่เปลี่ยน
+func MoveLocationStock(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+
+		var req LocationMoveRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		if req.AppID == 0 || req.StoreID == 0 || req.ToLocationID == 0 || req.Quantity <= 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "app_id, store_id, to_location_id and quantity are required")
+		}
+
+		ctx := c.UserContext()
+		tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to begin transaction")
+		}
+		defer func() { _ = tx.Rollback(ctx) }()
+
+		settings, err := tenant.LoadSettings(ctx, tx, tenantID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to load tenant settings")
+		}
+		req.WarehouseID = settings.WarehouseOrDefault(req.WarehouseID)
+		if req.WarehouseID == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "warehouse_id is required")
+		}
+		if err := inventory.EnsureWarehouseActive(ctx, tx, tenantID, req.WarehouseID); err != nil {
+			return inventoryError(err)
+		}
+		if err := resolveItemProduct(ctx, tx, tenantID, &req.ProductID, req.SKU); err != nil {
+			return err
+		}
+		qty, err := inventory.ToBase(ctx, tx, tenantID, req.ProductID, req.Unit, req.Quantity)
+		if err != nil {
+			return inventoryError(err)
+		}
+
+		err = location.Move(ctx, tx, tenantID, settings, location.MoveRequest{
+			WarehouseID:    req.WarehouseID,
+			ProductID:      req.ProductID,
+			LotID:          req.LotID,
+			FromLocationID: req.FromLocationID,
+			ToLocationID:   req.ToLocationID,
+			Quantity:       qty,
+			AppID:          req.AppID,
+			StoreID:        req.StoreID,
+		})
+		if err != nil {
+			if location.IsInvalid(err) {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to move stock")
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to commit transaction")
+		}
+
+		return c.JSON(fiber.Map{
+			"message":          "Stock moved",
+			"warehouse_id":     req.WarehouseID,
+			"product_id":       req.ProductID,
+			"from_location_id": req.FromLocationID,
+			"to_location_id":   req.ToLocationID,
+			"quantity":         qty,
+		})
+	}
+}
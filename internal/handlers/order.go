@@ -1,127 +1,163 @@
 package handlers
 
 import (
-	"context"
 	"fmt"
+	"math"
 	"time"
 
+	"atlasq/internal/database"
+	"atlasq/internal/inventory"
+	"atlasq/internal/stockissue"
+	"atlasq/internal/tenant"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/jackc/pgx/v4"
-	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 type OrderItem struct {
-	ProductID int64 `json:"product_id"`
-	Quantity  int64 `json:"quantity"`
+	ProductID int64  `json:"product_id"`
+	SKU       string `json:"sku,omitempty"` // ใช้แทน product_id ได้
+	Quantity  int64  `json:"quantity"`
+	Unit      string `json:"unit,omitempty"` // ว่าง = หน่วยฐาน
 }
 
 type OrderRequest struct {
 	WarehouseID int64       `json:"warehouse_id"`
+	AppID       int64       `json:"app_id,omitempty"` // บันทึกลง stock_movement ของ line ที่ตัด
+	StoreID     int64       `json:"store_id,omitempty"`
 	Items       []OrderItem `json:"items"`
 }
 
-func CreateOrderOld(pool *pgxpool.Pool) fiber.Handler {
+// model ของ stock_movement ที่ /orders-old ตัด
+const legacyOrderMovementModel = "order_old"
+
+func CreateOrderOld(pool *database.LoggingPool) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		conn, err := pool.Acquire(c.Context())
+		tenantID, err := currentTenant(c)
 		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, "failed to acquire database connection")
-		}
-		defer conn.Release()
-
-		tenantID := c.Query("tenant")
-		if tenantID == "" {
-			return fiber.NewError(fiber.StatusBadRequest, "tenant query string is required")
-		}
-
-		// ตรวจสอบ tenant
-		var exists bool
-		if err := conn.QueryRow(c.Context(), `SELECT EXISTS(SELECT 1 FROM tenant WHERE id=$1)`, tenantID).Scan(&exists); err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, "failed to validate tenant")
-		}
-		if !exists {
-			return fiber.NewError(fiber.StatusBadRequest, "tenant not found")
+			return err
 		}
+		ctx := c.UserContext()
 
 		var req OrderRequest
 		if err := c.BodyParser(&req); err != nil {
 			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
 		}
-		if req.WarehouseID == 0 || len(req.Items) == 0 {
-			return fiber.NewError(fiber.StatusBadRequest, "warehouse_id and items are required")
+		if len(req.Items) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "items are required")
 		}
 
-		tx, err := conn.BeginTx(c.Context(), pgx.TxOptions{
+		tx, err := pool.BeginTx(ctx, pgx.TxOptions{
 			IsoLevel: pgx.Serializable,
 		})
 		if err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, "failed to start transaction")
 		}
-		defer tx.Rollback(c.Context())
+		defer tx.Rollback(ctx)
 
+		settings, err := tenant.LoadSettings(ctx, tx, tenantID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to load tenant settings")
+		}
+		req.WarehouseID = settings.WarehouseOrDefault(req.WarehouseID)
+		if req.WarehouseID == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "warehouse_id is required")
+		}
+		if err := inventory.EnsureWarehouseActive(ctx, tx, tenantID, req.WarehouseID); err != nil {
+			return inventoryError(err)
+		}
+
+		for i := range req.Items {
+			item := &req.Items[i]
+			if err := resolveItemProduct(ctx, tx, tenantID, &item.ProductID, item.SKU); err != nil {
+				return err
+			}
+			if err := toBaseQuantity(ctx, tx, tenantID, item.ProductID, &item.Unit, &item.Quantity); err != nil {
+				return err
+			}
+		}
+
+		// bundle ถูกแตกเป็น component ก่อนตัด stock
+		lines := []inventory.Line{}
 		for _, item := range req.Items {
+			if err := inventory.EnsureProductActive(ctx, tx, tenantID, item.ProductID); err != nil {
+				return inventoryError(err)
+			}
+			exploded, err := inventory.Explode(ctx, tx, tenantID, item.ProductID, float64(item.Quantity))
+			if err != nil {
+				return inventoryError(err)
+			}
+			lines = append(lines, exploded...)
+		}
+
+		for _, line := range lines {
 			var stockQty, reserveQty, onHandQty float64
 			var stockID int64
 
 			err := tx.QueryRow(
-				c.Context(),
-				`SELECT id, quantity, reserve, on_hand FROM stock WHERE product_id=$1 AND warehouse_id=$2 AND tenant_id=$3`,
-				item.ProductID, req.WarehouseID, tenantID,
+				ctx,
+				`SELECT id, quantity, reserve, on_hand FROM stock WHERE product_id=$1 AND warehouse_id=$2 AND tenant_id=$3 FOR UPDATE`,
+				line.ProductID, req.WarehouseID, tenantID,
 			).Scan(&stockID, &stockQty, &reserveQty, &onHandQty)
 
-			if err != nil { // ไม่เจอ stock -> insert
+			if err == pgx.ErrNoRows { // ไม่เจอ stock -> insert ที่ 0 ตัดได้เฉพาะ tenant ที่เปิด allow_backorders
+				if !settings.AutoCreateStock {
+					return fiber.NewError(fiber.StatusBadRequest,
+						fmt.Sprintf("stock not found for product %d in warehouse %d", line.ProductID, req.WarehouseID),
+					)
+				}
 				err = tx.QueryRow(
-					c.Context(),
+					ctx,
 					`INSERT INTO stock (
 						tenant_id, warehouse_id, product_id,
-						minimum, quantity, reserve, on_hand, status,
+						minimum, quantity, balance, reserve, on_hand, status,
 						create_date, update_date, row_create_date, row_update_date
-					) VALUES ($1,$2,$3,0,$4,0,$4,true,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP)
+					) VALUES ($1,$2,$3,0,0,0,0,0,true,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP)
 					RETURNING id, quantity, reserve, on_hand`,
-					tenantID, req.WarehouseID, item.ProductID, item.Quantity,
+					tenantID, req.WarehouseID, line.ProductID,
 				).Scan(&stockID, &stockQty, &reserveQty, &onHandQty)
 				if err != nil {
 					return fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("failed to create stock: %v", err))
 				}
+			} else if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch stock")
 			}
 
-			if stockQty < float64(item.Quantity) {
-				return fiber.NewError(fiber.StatusBadRequest,
-					fmt.Sprintf("not enough stock for product %d, current: %.0f, required: %d", item.ProductID, stockQty, item.Quantity),
-				)
-			}
-
-			newQty := stockQty - float64(item.Quantity)
-			_, err = tx.Exec(
-				c.Context(),
-				`UPDATE stock SET quantity=$1, on_hand=$1, update_date=CURRENT_TIMESTAMP, row_update_date=CURRENT_TIMESTAMP WHERE id=$2`,
-				newQty, stockID,
-			)
+			// ตัดแบบเดียวกับ /stock-issue: available = balance - reserve, lot, bin และ stock_balance
+			err = stockissue.Line(ctx, tx, tenantID, settings, stockissue.Request{
+				AppID:       req.AppID,
+				StoreID:     req.StoreID,
+				WarehouseID: req.WarehouseID,
+				Model:       legacyOrderMovementModel,
+			}, line)
 			if err != nil {
-				return fiber.NewError(fiber.StatusInternalServerError, "failed to update stock")
+				return inventoryError(err)
 			}
+			newQty := stockQty - line.Quantity
+			newOnHand := onHandQty - line.Quantity
 
 			// สร้าง transaction log
 			_, err = tx.Exec(
-				c.Context(),
+				ctx,
 				`INSERT INTO transaction (
 					model, event, teanant_id, product_id, warehouse_id, stock_id,
 					quantity_old, quantity_change, quantity_new,
 					reserve_old, reserve_change, reserve_new,
 					on_hand_old, on_hand_change, on_hand_new,
-					status, create_date, update_date, row_create_date, row_update_date
-				) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP)`,
-				"ORDER", "ISSUE", tenantID, item.ProductID, req.WarehouseID, stockID,
-				stockQty, -float64(item.Quantity), newQty,
+					status, bundle_product_id, create_date, update_date, row_create_date, row_update_date
+				) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP)`,
+				"ORDER", "ISSUE", tenantID, line.ProductID, req.WarehouseID, stockID,
+				stockQty, -line.Quantity, newQty,
 				reserveQty, 0, reserveQty,
-				onHandQty, -float64(item.Quantity), newQty,
-				true,
+				onHandQty, -line.Quantity, newOnHand,
+				true, line.BundleProductID,
 			)
 			if err != nil {
 				return fiber.NewError(fiber.StatusInternalServerError, "failed to create transaction log")
 			}
 		}
 
-		if err := tx.Commit(c.Context()); err != nil {
+		if err := tx.Commit(ctx); err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, "failed to commit transaction")
 		}
 
@@ -159,20 +195,24 @@ type Order struct {
 	RowUpdatedDate time.Time  `json:"row_updated_date"`
 }
 
-func GetOrderByID(db *pgxpool.Pool) fiber.Handler {
+func GetOrderByID(db *database.LoggingPool) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		id := c.Params("id")
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
 
 		var o Order
-		err := db.QueryRow(context.Background(), `
+		err = db.QueryRow(c.UserContext(), `
 			SELECT 
 				id, app_id, store_id, channel_id, warehouse_id, order_number, stock_method, order_id,
 				store_user_id, reserved_date, issued_date, canceled_date, returned_date,
 				reserved, issued, canceled, returned, status, activate, user_id,
 				deleted_date, created_date, updated_date, row_created_date, row_updated_date
 			FROM "order"
-			WHERE id = $1
-		`, id).Scan(
+			WHERE id = $1 AND tenant_id = $2
+		`, id, tenantID).Scan(
 			&o.ID, &o.AppID, &o.StoreID, &o.ChannelID, &o.WarehouseID, &o.OrderNumber, &o.StockMethod, &o.OrderID,
 			&o.StoreUserID, &o.ReservedDate, &o.IssuedDate, &o.CanceledDate, &o.ReturnedDate,
 			&o.Reserved, &o.Issued, &o.Canceled, &o.Returned, &o.Status, &o.Activate, &o.UserID,
@@ -193,11 +233,13 @@ func GetOrderByID(db *pgxpool.Pool) fiber.Handler {
 type CreateOrderItemRequest struct {
 	ProductMainID *int64  `json:"product_main_id,omitempty"`
 	ProductID     int64   `json:"product_id"`
+	SKU           string  `json:"sku,omitempty"` // ใช้แทน product_id ได้
 	SetID         *int64  `json:"set_id,omitempty"`
 	ParentID      *int64  `json:"parent_id,omitempty"`
 	ReserveID     *int64  `json:"reserve_id,omitempty"`
-	MainQuantity  float64 `json:"main_quantity"`
+	MainQuantity  float64 `json:"main_quantity"` // หน่วยฐาน คำนวณจาก quantity + unit ถ้าส่งมาต้องตรงกัน
 	Quantity      float64 `json:"quantity"`
+	Unit          string  `json:"unit,omitempty"`
 	StoreUserID   *int64  `json:"store_user_id,omitempty"`
 	UserID        *int64  `json:"user_id,omitempty"`
 }
@@ -213,34 +255,56 @@ type CreateOrderRequest struct {
 	Items       []CreateOrderItemRequest `json:"items"`
 }
 
-func CreateOrder(db *pgxpool.Pool) fiber.Handler {
+func CreateOrder(db *database.LoggingPool) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		ctx := c.UserContext()
+
 		var req CreateOrderRequest
 		if err := c.BodyParser(&req); err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
 		}
+		if len(req.Items) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "items are required"})
+		}
 
-		tx, err := db.BeginTx(context.Background(), pgx.TxOptions{
+		tx, err := db.BeginTx(ctx, pgx.TxOptions{
 			IsoLevel:   pgx.RepeatableRead,
 			AccessMode: pgx.ReadWrite,
 		})
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to start transaction"})
 		}
-		defer tx.Rollback(context.Background())
+		defer tx.Rollback(ctx)
+
+		// ไม่ระบุ warehouse_id -> ใช้ default warehouse ของ tenant
+		settings, err := tenant.LoadSettings(ctx, tx, tenantID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to load tenant settings"})
+		}
+		req.WarehouseID = settings.WarehouseOrDefault(req.WarehouseID)
+		if req.WarehouseID == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "warehouse_id is required"})
+		}
+		if err := inventory.EnsureWarehouseActive(ctx, tx, tenantID, req.WarehouseID); err != nil {
+			return inventoryError(err)
+		}
 
 		// Insert order
 		var o Order
-		err = tx.QueryRow(context.Background(), `
+		err = tx.QueryRow(ctx, `
 			INSERT INTO "order" (
-				app_id, store_id, channel_id, warehouse_id, stock_method, store_user_id, user_id
-			) VALUES ($1,$2,$3,$4,$5,$6,$7)
+				tenant_id, app_id, store_id, channel_id, warehouse_id, stock_method, store_user_id, user_id
+			) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
 			RETURNING 
 				id, app_id, store_id, channel_id, warehouse_id, order_number, stock_method, order_id,
 				store_user_id, reserved_date, issued_date, canceled_date, returned_date,
 				reserved, issued, canceled, returned, status, activate, user_id,
 				deleted_date, created_date, updated_date, row_created_date, row_updated_date
-		`, req.AppID, req.StoreID, req.ChannelID, req.WarehouseID, req.StockMethod, req.StoreUserID, req.UserID).Scan(
+		`, tenantID, req.AppID, req.StoreID, req.ChannelID, req.WarehouseID, req.StockMethod, req.StoreUserID, req.UserID).Scan(
 			&o.ID, &o.AppID, &o.StoreID, &o.ChannelID, &o.WarehouseID, &o.OrderNumber, &o.StockMethod, &o.OrderID,
 			&o.StoreUserID, &o.ReservedDate, &o.IssuedDate, &o.CanceledDate, &o.ReturnedDate,
 			&o.Reserved, &o.Issued, &o.Canceled, &o.Returned, &o.Status, &o.Activate, &o.UserID,
@@ -252,7 +316,25 @@ func CreateOrder(db *pgxpool.Pool) fiber.Handler {
 
 		// Insert order items and stock
 		for _, item := range req.Items {
-			_, err := tx.Exec(context.Background(), `
+			if err := resolveItemProduct(ctx, tx, tenantID, &item.ProductID, item.SKU); err != nil {
+				return err
+			}
+			if err := inventory.EnsureProductActive(ctx, tx, tenantID, item.ProductID); err != nil {
+				return inventoryError(err)
+			}
+			// main_quantity คิดจาก quantity + unit เสมอ ถ้าส่งมาด้วยต้องตรงกัน
+			mainQuantity, err := inventory.ToBase(ctx, tx, tenantID, item.ProductID, item.Unit, item.Quantity)
+			if err != nil {
+				return inventoryError(err)
+			}
+			if item.MainQuantity != 0 && math.Abs(item.MainQuantity-mainQuantity) > 1e-9 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": fmt.Sprintf("product_id=%d: main_quantity %v does not match quantity %v %s (%v)",
+						item.ProductID, item.MainQuantity, item.Quantity, item.Unit, mainQuantity),
+				})
+			}
+			item.MainQuantity = mainQuantity
+			_, err = tx.Exec(ctx, `
 				INSERT INTO order_item (
 					order_id, product_main_id, product_id, set_id, parent_id, reserve_id,
 					main_quantity, quantity, store_user_id, user_id
@@ -269,7 +351,7 @@ func CreateOrder(db *pgxpool.Pool) fiber.Handler {
 		// Set order_number and order_id
 		orderNumber := fmt.Sprintf("SO-%05d", o.ID)
 		orderRefID := fmt.Sprintf("ORD-REF-%03d", o.ID)
-		_, err = tx.Exec(context.Background(), `
+		_, err = tx.Exec(ctx, `
 			UPDATE "order"
 			SET order_number=$1, order_id=$2
 			WHERE id=$3
@@ -278,7 +360,7 @@ func CreateOrder(db *pgxpool.Pool) fiber.Handler {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to set order_number/order_id"})
 		}
 
-		if err := tx.Commit(context.Background()); err != nil {
+		if err := tx.Commit(ctx); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to commit transaction"})
 		}
 
@@ -2,25 +2,25 @@ package handlers
 
 import (
 	"encoding/json"
-	"strconv"
+	"time"
 
+	"atlasq/internal/allocation"
+	"atlasq/internal/database"
+	"atlasq/internal/inventory"
 	tasks "atlasq/internal/tasks"
+	"atlasq/internal/tenant"
+	"atlasq/internal/usage"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/hibiken/asynq"
 )
 
 // EnqueueOrderHandler คืนค่า fiber.Handler
-func CreateOrderQueue(client *asynq.Client) fiber.Handler {
+func CreateOrderQueue(pool *database.LoggingPool, client *asynq.Client, meter *usage.Meter) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		tenantIDStr := c.Query("tenant")
-		if tenantIDStr == "" {
-			return fiber.NewError(fiber.StatusBadRequest, "tenant query string is required")
-		}
-
-		tenantID, err := strconv.ParseInt(tenantIDStr, 10, 64)
+		tenantID, err := currentTenant(c)
 		if err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, "invalid tenant ID")
+			return err
 		}
 
 		var req tasks.OrderRequest
@@ -28,15 +28,55 @@ func CreateOrderQueue(client *asynq.Client) fiber.Handler {
 			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
 		}
 
-		if req.WarehouseID == 0 || len(req.Items) == 0 {
-			return fiber.NewError(fiber.StatusBadRequest, "warehouse_id and items are required")
+		if len(req.Items) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "items are required")
+		}
+
+		if req.Allocation != "" {
+			// allocation mode: worker แบ่ง line ไปหลาย warehouse เอง
+			if req.WarehouseID != 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "warehouse_id cannot be used with allocation")
+			}
+			if _, err := allocation.Lookup(req.Allocation); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+		} else {
+			// ไม่ระบุ warehouse_id -> ใช้ default warehouse ของ tenant
+			settings, err := tenant.LoadSettings(c.UserContext(), pool, tenantID)
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to load tenant settings")
+			}
+			req.WarehouseID = settings.WarehouseOrDefault(req.WarehouseID)
+			if req.WarehouseID == 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "warehouse_id is required")
+			}
+			if err := inventory.EnsureWarehouseActive(c.UserContext(), pool, tenantID, req.WarehouseID); err != nil {
+				return inventoryError(err)
+			}
+		}
+
+		// ตรวจก่อนเข้าคิว worker ตรวจซ้ำอีกรอบตอนตัด stock
+		for i := range req.Items {
+			item := &req.Items[i]
+			if err := resolveItemProduct(c.UserContext(), pool, tenantID, &item.ProductID, item.SKU); err != nil {
+				return err
+			}
+			if err := toBaseQuantity(c.UserContext(), pool, tenantID, item.ProductID, &item.Unit, &item.Quantity); err != nil {
+				return err
+			}
+			if err := inventory.EnsureProductActive(c.UserContext(), pool, tenantID, item.ProductID); err != nil {
+				return inventoryError(err)
+			}
 		}
 
 		payload := tasks.DeductStockPayload{
 			TenantID:    tenantID,
 			OrderNumber: req.OrderNumber,
 			WarehouseID: req.WarehouseID,
+			Allocation:  req.Allocation,
 			OrderID:     req.OrderID,
+			AppID:       req.AppID,
+			StoreID:     req.StoreID,
 			Items:       req.Items,
 		}
 
@@ -45,14 +85,61 @@ func CreateOrderQueue(client *asynq.Client) fiber.Handler {
 			return fiber.NewError(fiber.StatusInternalServerError, "failed to create task payload")
 		}
 
-		task := asynq.NewTask("order:deduct_stock", data, asynq.MaxRetry(10))
+		task := asynq.NewTask(tasks.TypeDeductStock, data, asynq.MaxRetry(10))
 
 		if _, err := client.Enqueue(task); err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, "failed to enqueue task")
 		}
+		meter.Add(c.UserContext(), tenantID, usage.MetricOrdersEnqueued, 1)
 
 		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 			"message": "Order enqueued for processing",
 		})
 	}
 }
+
+type OrderAllocation struct {
+	ProductID       int64     `json:"product_id"`
+	BundleProductID *int64    `json:"bundle_product_id,omitempty"`
+	WarehouseID     int64     `json:"warehouse_id"`
+	Quantity        float64   `json:"quantity"`
+	Strategy        *string   `json:"strategy,omitempty"`
+	CreatedDate     time.Time `json:"created_date"`
+}
+
+// GetOrderAllocations คืนว่า worker ตัดแต่ละ line ของ order (order_id ที่ส่งเข้าคิว) จาก warehouse ไหน
+func GetOrderAllocations(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		orderID, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid order id")
+		}
+
+		rows, err := pool.Query(c.UserContext(),
+			`SELECT product_id, bundle_product_id, warehouse_id, quantity, strategy, created_date
+			 FROM order_allocations WHERE tenant_id=$1 AND order_id=$2 ORDER BY id`,
+			tenantID, orderID,
+		)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch allocations")
+		}
+		defer rows.Close()
+
+		allocations := []OrderAllocation{}
+		for rows.Next() {
+			var a OrderAllocation
+			if err := rows.Scan(&a.ProductID, &a.BundleProductID, &a.WarehouseID, &a.Quantity, &a.Strategy, &a.CreatedDate); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to read allocation")
+			}
+			allocations = append(allocations, a)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch allocations")
+		}
+		return c.JSON(fiber.Map{"order_id": orderID, "data": allocations})
+	}
+}
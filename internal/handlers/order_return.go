@@ -0,0 +1,418 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"atlasq/internal/database"
+	"atlasq/internal/inventory"
+	"atlasq/internal/location"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+)
+
+// issuedMovement คือ movement 'issue' ของ order พร้อมจำนวนที่ยังไม่ถูกคืน
+type issuedMovement struct {
+	ID              int64
+	StockID         int64
+	ProductID       int64
+	LotID           *int64
+	LocationID      *int64
+	BundleProductID *int64
+	CostFIFO        float64
+	CostAverage     float64
+	Issued          float64 // จำนวนที่ตัดตอน issue
+	Remaining       float64
+}
+
+// Restock is one compensating movement written by cancel or return.
+type Restock struct {
+	MovementID          int64   `json:"movement_id"`
+	ReferenceMovementID int64   `json:"reference_movement_id"`
+	ProductID           int64   `json:"product_id"`
+	BundleProductID     *int64  `json:"bundle_product_id,omitempty"`
+	LotID               *int64  `json:"lot_id,omitempty"`
+	LocationID          *int64  `json:"location_id,omitempty"`
+	Quantity            float64 `json:"quantity"`
+}
+
+// CancelOrder ยกเลิก order: ถ้ายัง reserve อยู่จะ release ถ้า issue ไปแล้วจะคืน stock
+// ที่ยังไม่ถูก return กลับเข้า lot และ bin เดิม ทุกบรรทัดอ้าง movement ต้นทาง
+func CancelOrder(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		orderID, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid order id")
+		}
+
+		ctx := c.UserContext()
+		tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to begin transaction")
+		}
+		defer func() { _ = tx.Rollback(ctx) }()
+
+		o, err := lockOrder(ctx, tx, tenantID, orderID)
+		if err != nil {
+			return err
+		}
+		if o.Canceled {
+			return fiber.NewError(fiber.StatusConflict, "order is already canceled")
+		}
+
+		movements, err := issuedMovements(ctx, tx, tenantID, o.ID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+		if err := ensureReversible(ctx, tx, tenantID, o, movements); err != nil {
+			return err
+		}
+
+		reservations := []Reservation{}
+		restocks := []Restock{}
+		if o.Issued {
+			for _, m := range movements {
+				if m.Remaining <= 0 {
+					continue
+				}
+				// ของยังไม่ออกจากคลังจริง คืนเข้า lot และ bin ที่ pick มา
+				r, err := restock(ctx, tx, tenantID, o, m, m.Remaining, "cancel", m.LotID, m.LocationID)
+				if err != nil {
+					return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+				}
+				restocks = append(restocks, r)
+			}
+		} else if o.Reserved {
+			reservations, err = releaseOrder(ctx, tx, tenantID, o)
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+			}
+		}
+
+		if _, err := tx.Exec(ctx,
+			`UPDATE "order" SET canceled=true, canceled_date=NOW(), reserved=false, updated_date=NOW() WHERE id=$1`, o.ID,
+		); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to update order")
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to commit transaction")
+		}
+		return c.JSON(fiber.Map{"order_id": o.ID, "released": reservations, "restocked": restocks})
+	}
+}
+
+type OrderReturnItem struct {
+	ProductID int64   `json:"product_id"`
+	SKU       string  `json:"sku,omitempty"`
+	Quantity  float64 `json:"quantity"`
+	Unit      string  `json:"unit,omitempty"` // ว่าง = หน่วยฐาน
+}
+
+// OrderReturnRequest: return_lot = true เก็บของคืนเป็น lot ใหม่ (ต้นทุนเดิม) แยกจาก lot ที่ขายไป
+// location_id คือ bin ที่รับของคืน ไม่ส่ง = ยังไม่ได้เก็บเข้า bin
+type OrderReturnRequest struct {
+	ReturnLot  bool              `json:"return_lot"`
+	LocationID *int64            `json:"location_id,omitempty"`
+	Items      []OrderReturnItem `json:"items"`
+}
+
+// ReturnOrder รับของที่ลูกค้าส่งคืนเข้า stock ของ warehouse ที่ตัดไป ที่ต้นทุนตอนขาย
+// คืนได้ไม่เกินจำนวนที่ issue และยังไม่ถูกคืน ไล่จาก movement 'issue' ตามลำดับ
+func ReturnOrder(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		orderID, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid order id")
+		}
+
+		var req OrderReturnRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		if len(req.Items) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "items are required")
+		}
+
+		ctx := c.UserContext()
+		tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to begin transaction")
+		}
+		defer func() { _ = tx.Rollback(ctx) }()
+
+		o, err := lockOrder(ctx, tx, tenantID, orderID)
+		if err != nil {
+			return err
+		}
+		if o.Canceled {
+			return fiber.NewError(fiber.StatusConflict, "order is canceled")
+		}
+		movements, err := issuedMovements(ctx, tx, tenantID, o.ID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+		if err := ensureReversible(ctx, tx, tenantID, o, movements); err != nil {
+			return err
+		}
+		if !o.Issued {
+			return fiber.NewError(fiber.StatusConflict, "order is not issued")
+		}
+		// ของคืนเข้า warehouse ที่ตัดไป เช็ค active เหมือนตอน issue / receive
+		if err := inventory.EnsureWarehouseActive(ctx, tx, tenantID, o.WarehouseID); err != nil {
+			return inventoryError(err)
+		}
+		if req.LocationID != nil {
+			if err := location.EnsureBin(ctx, tx, tenantID, o.WarehouseID, *req.LocationID); err != nil {
+				if location.IsInvalid(err) {
+					return fiber.NewError(fiber.StatusBadRequest, err.Error())
+				}
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to check location")
+			}
+		}
+
+		restocks := []Restock{}
+		for _, item := range req.Items {
+			if item.Quantity <= 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "quantity must be > 0")
+			}
+			if err := resolveItemProduct(ctx, tx, tenantID, &item.ProductID, item.SKU); err != nil {
+				return err
+			}
+			qty, err := inventory.ToBase(ctx, tx, tenantID, item.ProductID, item.Unit, item.Quantity)
+			if err != nil {
+				return inventoryError(err)
+			}
+			// bundle ถูกตัดเป็น component ตอน issue ก็คืนเป็น component ตามที่ตัดไปจริง
+			lines, err := returnLines(ctx, tx, o.ID, item.ProductID, qty, movements)
+			if err != nil {
+				return err
+			}
+			for _, line := range lines {
+				remaining := line.Quantity
+				for i := range movements {
+					m := &movements[i]
+					if remaining <= 0 {
+						break
+					}
+					if m.Remaining <= 0 || m.ProductID != line.ProductID || !sameBundle(m.BundleProductID, line.BundleProductID) {
+						continue
+					}
+					take := remaining
+					if m.Remaining < take {
+						take = m.Remaining
+					}
+					lotID := m.LotID
+					if req.ReturnLot {
+						lotID = nil
+					}
+					r, err := restock(ctx, tx, tenantID, o, *m, take, "return", lotID, req.LocationID)
+					if err != nil {
+						return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+					}
+					restocks = append(restocks, r)
+					m.Remaining -= take
+					remaining -= take
+				}
+				if remaining > 0 {
+					return fiber.NewError(fiber.StatusBadRequest,
+						fmt.Sprintf("product_id=%d: return quantity exceeds issued quantity", line.ProductID))
+				}
+			}
+		}
+
+		if _, err := tx.Exec(ctx,
+			`UPDATE "order" SET returned=true, returned_date=NOW(), updated_date=NOW() WHERE id=$1`, o.ID,
+		); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to update order")
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to commit transaction")
+		}
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"order_id": o.ID, "data": restocks})
+	}
+}
+
+// returnLines แปลงของคืนเป็น line ที่ต้องคืน stock โดย bundle ใช้สัดส่วน component จาก
+// movement 'issue' ของ order (ไม่ใช่ definition ปัจจุบันที่อาจถูกแก้หลัง issue)
+// product ที่ไม่ได้ถูกตัดเป็น bundle คืนเป็น line เดียวของตัวเอง
+func returnLines(ctx context.Context, tx pgx.Tx, orderID, productID int64, quantity float64, movements []issuedMovement) ([]inventory.Line, error) {
+	issued := map[int64]float64{}
+	components := []int64{}
+	for _, m := range movements {
+		if m.BundleProductID == nil || *m.BundleProductID != productID {
+			continue
+		}
+		if _, ok := issued[m.ProductID]; !ok {
+			components = append(components, m.ProductID)
+		}
+		issued[m.ProductID] += m.Issued
+	}
+	if len(components) == 0 {
+		return []inventory.Line{{ProductID: productID, Quantity: quantity}}, nil
+	}
+
+	var bundles float64
+	if err := tx.QueryRow(ctx,
+		`SELECT COALESCE(SUM(main_quantity), 0) FROM order_item WHERE order_id=$1 AND product_id=$2`,
+		orderID, productID,
+	).Scan(&bundles); err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "failed to fetch order items")
+	}
+	if bundles <= 0 {
+		return nil, fiber.NewError(fiber.StatusConflict,
+			fmt.Sprintf("product_id=%d: bundle is not an item of this order", productID))
+	}
+
+	bundleID := productID
+	lines := make([]inventory.Line, 0, len(components))
+	for _, id := range components {
+		lines = append(lines, inventory.Line{
+			ProductID:       id,
+			Quantity:        issued[id] * quantity / bundles,
+			BundleProductID: &bundleID,
+		})
+	}
+	return lines, nil
+}
+
+func sameBundle(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// ensureReversible ปฏิเสธ order ที่ตัด stock ผ่าน /orders-queue (worker) หรือ /orders-old
+// สองทางนี้ตัดผ่าน stockissue เหมือนกันแต่ movement ไม่มี order_id (order_id ของคิวเป็นค่าจาก client)
+// จึงไม่มี movement ของ order ให้ cancel / return อ้าง
+func ensureReversible(ctx context.Context, tx pgx.Tx, tenantID int64, o orderState, movements []issuedMovement) error {
+	if len(movements) > 0 {
+		return nil
+	}
+	if o.Issued {
+		return fiber.NewError(fiber.StatusConflict,
+			"order has no issue movements: stock deducted via /orders-queue or /orders-old is not linked to an order "+
+				"and cannot be canceled or returned here, correct it with a stock adjustment")
+	}
+	var queued bool
+	if err := tx.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM order_allocations WHERE tenant_id=$1 AND order_id=$2)`, tenantID, o.ID,
+	).Scan(&queued); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to check order allocations")
+	}
+	if queued {
+		return fiber.NewError(fiber.StatusConflict,
+			"order stock was deducted via /orders-queue: the deduction is not linked to this order "+
+				"and cannot be canceled or returned here, correct it with a stock adjustment")
+	}
+	return nil
+}
+
+// issuedMovements คืน movement 'issue' ของ order พร้อม lock โดย Remaining หักส่วนที่
+// cancel / return ไปแล้ว (movement ที่อ้างถึงมัน)
+func issuedMovements(ctx context.Context, tx pgx.Tx, tenantID, orderID int64) ([]issuedMovement, error) {
+	rows, err := tx.Query(ctx,
+		`SELECT m.id, m.stock_id, s.product_id, m.lot_id, m.location_id, m.bundle_product_id,
+		        m.cost_fifo, m.cost_average, -m.balance_change,
+		        -m.balance_change - COALESCE((SELECT SUM(r.balance_change) FROM stock_movement r
+		                                      WHERE r.reference_movement_id = m.id), 0)
+		 FROM stock_movement m
+		 JOIN stock s ON s.id = m.stock_id
+		 WHERE s.tenant_id=$1 AND m.order_id=$2 AND m.action='issue'
+		 ORDER BY m.id
+		 FOR UPDATE OF m`,
+		tenantID, orderID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch issued movements: %w", err)
+	}
+	movements := []issuedMovement{}
+	for rows.Next() {
+		var m issuedMovement
+		if err := rows.Scan(&m.ID, &m.StockID, &m.ProductID, &m.LotID, &m.LocationID, &m.BundleProductID,
+			&m.CostFIFO, &m.CostAverage, &m.Issued, &m.Remaining); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read issued movement: %w", err)
+		}
+		movements = append(movements, m)
+	}
+	rows.Close() // ต้องปิดก่อนใช้ tx ต่อ
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch issued movements: %w", err)
+	}
+	return movements, nil
+}
+
+// restock คืน quantity ของ movement m เข้า stock ที่ต้นทุนเดิมของ m
+// lotID nil ขณะที่ m มี lot = สร้าง return lot ใหม่; m ที่ไม่มี lot (backorder) คืนแค่ balance
+// locationID คือ bin ที่เก็บของคืน
+func restock(ctx context.Context, tx pgx.Tx, tenantID int64, o orderState, m issuedMovement, quantity float64, action string, lotID, locationID *int64) (Restock, error) {
+	var balance, reserve float64
+	if err := tx.QueryRow(ctx,
+		`SELECT balance, reserve FROM stock WHERE id=$1 FOR UPDATE`, m.StockID,
+	).Scan(&balance, &reserve); err != nil {
+		return Restock{}, fmt.Errorf("failed to fetch stock: %w", err)
+	}
+
+	switch {
+	case lotID != nil:
+		if _, err := tx.Exec(ctx, `UPDATE lot SET balance = balance + $1 WHERE id=$2`, quantity, *lotID); err != nil {
+			return Restock{}, fmt.Errorf("failed to update lot: %w", err)
+		}
+	case m.LotID != nil:
+		var id int64
+		if err := tx.QueryRow(ctx,
+			`INSERT INTO lot (stock_id, balance, cost_fifo, cost_average, created_date)
+			 VALUES ($1,$2,$3,$4,NOW()) RETURNING id`,
+			m.StockID, quantity, m.CostFIFO, m.CostAverage,
+		).Scan(&id); err != nil {
+			return Restock{}, fmt.Errorf("failed to insert return lot: %w", err)
+		}
+		lotID = &id
+	}
+	if lotID == nil {
+		locationID = nil // ไม่มี lot ให้เก็บเข้า bin
+	}
+	if locationID != nil {
+		if err := location.Put(ctx, tx, tenantID, m.StockID, *lotID, *locationID, quantity); err != nil {
+			return Restock{}, err
+		}
+	}
+
+	if err := inventory.AddBalance(ctx, tx, m.StockID, quantity, 0); err != nil {
+		return Restock{}, err
+	}
+
+	r := Restock{
+		ReferenceMovementID: m.ID,
+		ProductID:           m.ProductID,
+		BundleProductID:     m.BundleProductID,
+		LotID:               lotID,
+		LocationID:          locationID,
+		Quantity:            quantity,
+	}
+	err := tx.QueryRow(ctx, `
+		INSERT INTO stock_movement (
+			app_id, store_id, stock_id, lot_id, balance_before, balance_after, balance_change,
+			reserve_before, reserve_after, reserve_change, cost_fifo, cost_average,
+			action, model, bundle_product_id, location_id, order_id, reference_movement_id,
+			created_date, updated_date
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$8,0,$9,$10,$11,'order',$12,$13,$14,$15,NOW(),NOW())
+		RETURNING id`,
+		o.AppID, o.StoreID, m.StockID, lotID, balance, balance+quantity, quantity,
+		reserve, m.CostFIFO, m.CostAverage, action, m.BundleProductID, locationID, o.ID, m.ID,
+	).Scan(&r.MovementID)
+	if err != nil {
+		return Restock{}, fmt.Errorf("failed to insert stock_movement: %w", err)
+	}
+	return r, nil
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// pageParams อ่าน ?page= และ ?limit= (page เริ่มที่ 1)
+func pageParams(c *fiber.Ctx) (page, limit, offset int) {
+	page = c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+	limit = c.QueryInt("limit", defaultPageLimit)
+	if limit < 1 || limit > maxPageLimit {
+		limit = defaultPageLimit
+	}
+	return page, limit, (page - 1) * limit
+}
+
+// cursorParams อ่าน ?cursor= (id สุดท้ายของหน้าก่อน) และ ?limit=
+func cursorParams(c *fiber.Ctx) (cursor int64, limit int, err error) {
+	if v := c.Query("cursor"); v != "" {
+		cursor, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || cursor < 0 {
+			return 0, 0, fiber.NewError(fiber.StatusBadRequest, "invalid cursor")
+		}
+	}
+	limit = c.QueryInt("limit", defaultPageLimit)
+	if limit < 1 || limit > maxPageLimit {
+		limit = defaultPageLimit
+	}
+	return cursor, limit, nil
+}
@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"atlasq/internal/database"
+	"atlasq/internal/pricing"
+	"atlasq/internal/tenant"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+)
+
+type PriceList struct {
+	ID          int64     `json:"id"`
+	Code        string    `json:"code"`
+	Name        string    `json:"name"`
+	Currency    string    `json:"currency"`
+	ChannelID   *int64    `json:"channel_id,omitempty"`
+	Status      int16     `json:"status"`
+	CreatedDate time.Time `json:"created_date"`
+	UpdatedDate time.Time `json:"updated_date"`
+}
+
+const priceListColumns = `id, code, name, currency, channel_id, status, created_date, updated_date`
+
+func scanPriceList(row pgx.Row, p *PriceList) error {
+	return row.Scan(&p.ID, &p.Code, &p.Name, &p.Currency, &p.ChannelID, &p.Status, &p.CreatedDate, &p.UpdatedDate)
+}
+
+type PriceListRequest struct {
+	Code      string `json:"code"`
+	Name      string `json:"name"`
+	Currency  string `json:"currency"`
+	ChannelID *int64 `json:"channel_id"`
+}
+
+func CreatePriceList(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+
+		var req PriceListRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		req.Code = strings.ToUpper(strings.TrimSpace(req.Code))
+		if req.Code == "" || len(req.Code) > 50 {
+			return fiber.NewError(fiber.StatusBadRequest, "code is required and must be <= 50 characters")
+		}
+		if len(req.Name) == 0 || len(req.Name) > 255 {
+			return fiber.NewError(fiber.StatusBadRequest, "name is required and must be <= 255 characters")
+		}
+		req.Currency = strings.ToUpper(req.Currency)
+		if len(req.Currency) != 3 {
+			return fiber.NewError(fiber.StatusBadRequest, "currency must be a 3-letter ISO code")
+		}
+
+		var p PriceList
+		err = scanPriceList(pool.QueryRow(c.UserContext(),
+			`INSERT INTO price_lists (tenant_id, code, name, currency, channel_id) VALUES ($1,$2,$3,$4,$5)
+			 RETURNING `+priceListColumns,
+			tenantID, req.Code, req.Name, req.Currency, req.ChannelID), &p)
+		if isUniqueViolation(err) {
+			return fiber.NewError(fiber.StatusConflict, "price list code already exists")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to create price list")
+		}
+		return c.Status(fiber.StatusCreated).JSON(p)
+	}
+}
+
+func ListPriceLists(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+
+		rows, err := pool.Query(c.UserContext(),
+			`SELECT `+priceListColumns+` FROM price_lists WHERE tenant_id=$1 ORDER BY id`, tenantID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list price lists")
+		}
+		defer rows.Close()
+
+		lists := []PriceList{}
+		for rows.Next() {
+			var p PriceList
+			if err := scanPriceList(rows, &p); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to read price list")
+			}
+			lists = append(lists, p)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list price lists")
+		}
+		return c.JSON(fiber.Map{"data": lists})
+	}
+}
+
+type UpdatePriceListRequest struct {
+	Name   *string `json:"name"`
+	Status *int16  `json:"status"`
+}
+
+// UpdatePriceList แก้ได้แค่ name/status currency และ channel เปลี่ยนไม่ได้เพราะราคาเดิมผูกอยู่
+func UpdatePriceList(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid price list id")
+		}
+
+		var req UpdatePriceListRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+
+		sets := []string{}
+		args := []interface{}{}
+		if req.Name != nil {
+			if len(*req.Name) == 0 || len(*req.Name) > 255 {
+				return fiber.NewError(fiber.StatusBadRequest, "name is required and must be <= 255 characters")
+			}
+			args = append(args, *req.Name)
+			sets = append(sets, fmt.Sprintf("name=$%d", len(args)))
+		}
+		if req.Status != nil {
+			if *req.Status != 0 && *req.Status != 1 {
+				return fiber.NewError(fiber.StatusBadRequest, "status must be 0 or 1")
+			}
+			args = append(args, *req.Status)
+			sets = append(sets, fmt.Sprintf("status=$%d", len(args)))
+		}
+		if len(sets) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "nothing to update")
+		}
+
+		args = append(args, id, tenantID)
+		var p PriceList
+		err = scanPriceList(pool.QueryRow(c.UserContext(), fmt.Sprintf(
+			`UPDATE price_lists SET %s, updated_date=CURRENT_TIMESTAMP, row_updated_date=CURRENT_TIMESTAMP
+			 WHERE id=$%d AND tenant_id=$%d RETURNING %s`,
+			strings.Join(sets, ", "), len(args)-1, len(args), priceListColumns,
+		), args...), &p)
+		if err == pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "price list not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to update price list")
+		}
+		return c.JSON(p)
+	}
+}
+
+type ProductPrice struct {
+	ID            int64     `json:"id"`
+	PriceListID   int64     `json:"price_list_id"`
+	ProductID     int64     `json:"product_id"`
+	Price         float64   `json:"price"`
+	EffectiveFrom time.Time `json:"effective_from"`
+	CreatedDate   time.Time `json:"created_date"`
+}
+
+const productPriceColumns = `id, price_list_id, product_id, price, effective_from, created_date`
+
+func scanProductPrice(row pgx.Row, p *ProductPrice) error {
+	return row.Scan(&p.ID, &p.PriceListID, &p.ProductID, &p.Price, &p.EffectiveFrom, &p.CreatedDate)
+}
+
+type ProductPriceRequest struct {
+	ProductID     int64      `json:"product_id"`
+	SKU           string     `json:"sku"`
+	Price         float64    `json:"price"`
+	EffectiveFrom *time.Time `json:"effective_from"` // ไม่ส่ง = ตอนนี้
+}
+
+// AddProductPrice adds a price record to the list. Prices are never
+// overwritten; a new record takes over from its effective_from.
+func AddProductPrice(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		listID, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid price list id")
+		}
+
+		var req ProductPriceRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		if req.Price < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "price must be >= 0")
+		}
+		if err := resolveItemProduct(c.UserContext(), pool, tenantID, &req.ProductID, req.SKU); err != nil {
+			return err
+		}
+		from := time.Now().UTC()
+		if req.EffectiveFrom != nil {
+			from = req.EffectiveFrom.UTC()
+		}
+
+		var p ProductPrice
+		err = scanProductPrice(pool.QueryRow(c.UserContext(),
+			`INSERT INTO product_prices (tenant_id, price_list_id, product_id, price, effective_from)
+			 SELECT $1, pl.id, pr.id, $4, $5
+			 FROM price_lists pl, product pr
+			 WHERE pl.id=$2 AND pl.tenant_id=$1 AND pr.id=$3 AND pr.tenant_id=$1
+			 RETURNING `+productPriceColumns,
+			tenantID, listID, req.ProductID, req.Price, from), &p)
+		if err == pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "price list or product not found")
+		}
+		if isUniqueViolation(err) {
+			return fiber.NewError(fiber.StatusConflict, "a price with this effective_from already exists")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to add price")
+		}
+		return c.Status(fiber.StatusCreated).JSON(p)
+	}
+}
+
+// ListProductPrices returns the price history of a list, newest first,
+// optionally for one ?product_id=.
+func ListProductPrices(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		listID, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid price list id")
+		}
+		page, limit, offset := pageParams(c)
+
+		args := []interface{}{tenantID, listID}
+		where := "tenant_id=$1 AND price_list_id=$2"
+		if v := c.Query("product_id"); v != "" {
+			productID, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid product_id")
+			}
+			args = append(args, productID)
+			where += fmt.Sprintf(" AND product_id=$%d", len(args))
+		}
+
+		args = append(args, limit, offset)
+		rows, err := pool.Query(c.UserContext(), fmt.Sprintf(
+			`SELECT %s FROM product_prices WHERE %s ORDER BY product_id, effective_from DESC LIMIT $%d OFFSET $%d`,
+			productPriceColumns, where, len(args)-1, len(args),
+		), args...)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list prices")
+		}
+		defer rows.Close()
+
+		prices := []ProductPrice{}
+		for rows.Next() {
+			var p ProductPrice
+			if err := scanProductPrice(rows, &p); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to read price")
+			}
+			prices = append(prices, p)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list prices")
+		}
+
+		return c.JSON(fiber.Map{
+			"data":  prices,
+			"page":  page,
+			"limit": limit,
+		})
+	}
+}
+
+// GetProductPrice resolves the price of a product with ?at= (RFC 3339,
+// default now), ?currency= (default the tenant currency) and ?channel_id=.
+func GetProductPrice(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid product id")
+		}
+
+		at := time.Now()
+		if v := c.Query("at"); v != "" {
+			if at, err = time.Parse(time.RFC3339, v); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "at must be an RFC 3339 timestamp")
+			}
+		}
+		var channelID *int64
+		if v := c.Query("channel_id"); v != "" {
+			ch, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid channel_id")
+			}
+			channelID = &ch
+		}
+		currency := strings.ToUpper(c.Query("currency"))
+		if currency == "" {
+			settings, err := tenant.LoadSettings(c.UserContext(), pool, tenantID)
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to load tenant settings")
+			}
+			currency = settings.Currency
+		}
+
+		price, err := pricing.Resolve(c.UserContext(), pool, tenantID, int64(id), at, currency, channelID)
+		if err == pricing.ErrNoPrice {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to resolve price")
+		}
+		return c.JSON(price)
+	}
+}
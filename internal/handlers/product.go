@@ -1,8 +1,18 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"atlasq/internal/database"
+	"atlasq/internal/inventory"
+	"atlasq/internal/tenant"
+
 	"github.com/gofiber/fiber/v2"
-	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jackc/pgx/v4"
 )
 
 type ProductRequest struct {
@@ -12,47 +22,291 @@ type ProductRequest struct {
 	SKU         string  `json:"sku"`
 }
 
-func CreateProduct(pool *pgxpool.Pool) fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		conn, err := pool.Acquire(c.Context())
-		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, "failed to acquire database connection")
-		}
-		defer conn.Release()
+type Product struct {
+	ID           int64      `json:"id"`
+	Name         string     `json:"name"`
+	Description  *string    `json:"description,omitempty"`
+	Price        float64    `json:"price"`
+	SKU          *string    `json:"sku,omitempty"`
+	ArchivedDate *time.Time `json:"archived_date,omitempty"`
+}
 
-		tenantID := c.Query("tenant")
-		if tenantID == "" {
-			return fiber.NewError(fiber.StatusBadRequest, "tenant query string is required")
-		}
+const productColumns = `id, name, description, price, sku, archived_date`
 
-		var exists bool
-		if err := conn.QueryRow(c.Context(), `SELECT EXISTS(SELECT 1 FROM tenant WHERE id=$1)`, tenantID).Scan(&exists); err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, "failed to validate tenant")
-		}
-		if !exists {
-			return fiber.NewError(fiber.StatusBadRequest, "tenant not found")
+func scanProduct(row pgx.Row, p *Product) error {
+	return row.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.SKU, &p.ArchivedDate)
+}
+
+func CreateProduct(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
 		}
 
 		var req ProductRequest
 		if err := c.BodyParser(&req); err != nil {
 			return fiber.NewError(fiber.StatusBadRequest, err.Error())
 		}
-		if len(req.Name) == 0 || len(req.Name) > 255 {
-			return fiber.NewError(fiber.StatusBadRequest, "name is required and must be <= 255 characters")
-		}
-		if req.Price <= 0 {
-			return fiber.NewError(fiber.StatusBadRequest, "price must be > 0")
+		if err := inventory.ValidateProduct(req.Name, req.SKU, req.Price); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
 		}
 
-		_, err = conn.Exec(c.Context(), `INSERT INTO product (tenant_id, name, description, price, sku) VALUES ($1,$2,$3,$4,$5)`,
-			tenantID, req.Name, req.Description, req.Price, req.SKU)
+		var p Product
+		err = scanProduct(pool.QueryRow(c.UserContext(),
+			`INSERT INTO product (tenant_id, name, description, price, sku) VALUES ($1,$2,$3,$4,$5)
+			 RETURNING `+productColumns,
+			tenantID, req.Name, req.Description, req.Price, nullIfEmpty(req.SKU)), &p)
+		if isUniqueViolation(err) {
+			return fiber.NewError(fiber.StatusConflict, "sku already exists")
+		}
 		if err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
 		}
 
 		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 			"message": "Product created",
-			"name":    req.Name,
+			"id":      p.ID,
+			"name":    p.Name,
+			"product": p,
+		})
+	}
+}
+
+func GetProduct(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid product id")
+		}
+
+		var p Product
+		err = scanProduct(pool.QueryRow(c.UserContext(),
+			`SELECT `+productColumns+` FROM product WHERE id=$1 AND tenant_id=$2`, id, tenantID), &p)
+		if err == pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "product not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch product")
+		}
+		return c.JSON(p)
+	}
+}
+
+// ListProducts pages by id with ?cursor= (next_cursor of the previous page).
+// ?q= matches a name or SKU prefix, ?min_price= / ?max_price= filter by price.
+func ListProducts(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		cursor, limit, err := cursorParams(c)
+		if err != nil {
+			return err
+		}
+
+		args := []interface{}{tenantID, cursor}
+		where := []string{"tenant_id=$1", "id > $2"}
+		if c.Query("include_archived") != "true" {
+			where = append(where, "archived_date IS NULL")
+		}
+		if q := strings.TrimSpace(c.Query("q")); q != "" {
+			args = append(args, likePrefix(strings.ToLower(q)))
+			where = append(where, fmt.Sprintf("(lower(name) LIKE $%d OR lower(sku) LIKE $%d)", len(args), len(args)))
+		}
+		for _, f := range []struct{ param, op string }{{"min_price", ">="}, {"max_price", "<="}} {
+			v := c.Query(f.param)
+			if v == "" {
+				continue
+			}
+			price, err := strconv.ParseFloat(v, 64)
+			if err != nil || price < 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid "+f.param)
+			}
+			args = append(args, price)
+			where = append(where, fmt.Sprintf("price %s $%d", f.op, len(args)))
+		}
+
+		// ดึงเกินมา 1 แถวเพื่อรู้ว่ามีหน้าถัดไปไหม
+		args = append(args, limit+1)
+		rows, err := pool.Query(c.UserContext(), fmt.Sprintf(
+			`SELECT %s FROM product WHERE %s ORDER BY id LIMIT $%d`,
+			productColumns, strings.Join(where, " AND "), len(args),
+		), args...)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list products")
+		}
+		defer rows.Close()
+
+		products := []Product{}
+		for rows.Next() {
+			var p Product
+			if err := scanProduct(rows, &p); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to read product")
+			}
+			products = append(products, p)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list products")
+		}
+
+		var nextCursor *string
+		if len(products) > limit {
+			products = products[:limit]
+			next := strconv.FormatInt(products[limit-1].ID, 10)
+			nextCursor = &next
+		}
+
+		return c.JSON(fiber.Map{
+			"data":        products,
+			"limit":       limit,
+			"next_cursor": nextCursor,
 		})
 	}
 }
+
+type UpdateProductRequest struct {
+	Name        *string  `json:"name"`
+	Description *string  `json:"description"`
+	Price       *float64 `json:"price"`
+	SKU         *string  `json:"sku"`
+}
+
+func UpdateProduct(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid product id")
+		}
+
+		var req UpdateProductRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+
+		sets := []string{}
+		args := []interface{}{}
+		if req.Name != nil {
+			if len(*req.Name) == 0 || len(*req.Name) > 255 {
+				return fiber.NewError(fiber.StatusBadRequest, "name is required and must be <= 255 characters")
+			}
+			args = append(args, *req.Name)
+			sets = append(sets, fmt.Sprintf("name=$%d", len(args)))
+		}
+		if req.Description != nil {
+			args = append(args, *req.Description)
+			sets = append(sets, fmt.Sprintf("description=$%d", len(args)))
+		}
+		if req.Price != nil {
+			if *req.Price <= 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "price must be > 0")
+			}
+			args = append(args, *req.Price)
+			sets = append(sets, fmt.Sprintf("price=$%d", len(args)))
+		}
+		if req.SKU != nil {
+			if len(*req.SKU) > 100 {
+				return fiber.NewError(fiber.StatusBadRequest, "sku must be <= 100 characters")
+			}
+			args = append(args, nullIfEmpty(*req.SKU))
+			sets = append(sets, fmt.Sprintf("sku=$%d", len(args)))
+		}
+		if len(sets) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "nothing to update")
+		}
+
+		args = append(args, id)
+		return updateProduct(c, pool, strings.Join(sets, ", "), args...)
+	}
+}
+
+// ArchiveProduct hides a product from listings and refuses new orders and
+// stock issues for it. Existing stock and history are kept.
+func ArchiveProduct(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid product id")
+		}
+		return updateProduct(c, pool, "archived_date=COALESCE(archived_date, CURRENT_TIMESTAMP)", id)
+	}
+}
+
+func UnarchiveProduct(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid product id")
+		}
+		return updateProduct(c, pool, "archived_date=NULL", id)
+	}
+}
+
+// updateProduct รัน UPDATE กับ product ของ tenant ปัจจุบัน แล้วตอบกลับด้วย row ล่าสุด
+// id ต้องเป็น arg ตัวสุดท้าย
+func updateProduct(c *fiber.Ctx, pool *database.LoggingPool, sets string, args ...interface{}) error {
+	tenantID, err := currentTenant(c)
+	if err != nil {
+		return err
+	}
+
+	args = append(args, tenantID)
+	var p Product
+	err = scanProduct(pool.QueryRow(c.UserContext(), fmt.Sprintf(
+		`UPDATE product SET %s WHERE id=$%d AND tenant_id=$%d RETURNING %s`,
+		sets, len(args)-1, len(args), productColumns,
+	), args...), &p)
+	if err == pgx.ErrNoRows {
+		return fiber.NewError(fiber.StatusNotFound, "product not found")
+	}
+	if isUniqueViolation(err) {
+		return fiber.NewError(fiber.StatusConflict, "sku already exists")
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to update product")
+	}
+	return c.JSON(p)
+}
+
+// inventoryError แปลง error จาก package inventory เป็น response
+func inventoryError(err error) error {
+	if inventory.IsInvalid(err) {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return fiber.NewError(fiber.StatusInternalServerError, "failed to check inventory")
+}
+
+// resolveItemProduct เติม product_id จาก sku ถ้า item ไม่ได้ส่ง product_id มา
+func resolveItemProduct(ctx context.Context, q tenant.Querier, tenantID int64, productID *int64, sku string) error {
+	if *productID != 0 {
+		return nil
+	}
+	if sku == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "product_id or sku is required")
+	}
+	id, err := inventory.ResolveSKU(ctx, q, tenantID, sku)
+	if err != nil {
+		return inventoryError(err)
+	}
+	*productID = id
+	return nil
+}
+
+// nullIfEmpty ให้ sku ว่างเก็บเป็น NULL (unique index ไม่นับ)
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// likePrefix escape wildcard ของ LIKE แล้วต่อ % ท้าย
+func likePrefix(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s) + "%"
+}
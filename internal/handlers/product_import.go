@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"atlasq/internal/database"
+	"atlasq/internal/productimport"
+	tasks "atlasq/internal/tasks"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v4"
+)
+
+type ProductImport struct {
+	ID            int64      `json:"id"`
+	Format        string     `json:"format"`
+	Status        string     `json:"status"`
+	TotalRows     int        `json:"total_rows"`
+	ProcessedRows int        `json:"processed_rows"`
+	CreatedRows   int        `json:"created_rows"`
+	UpdatedRows   int        `json:"updated_rows"`
+	FailedRows    int        `json:"failed_rows"`
+	Error         *string    `json:"error,omitempty"`
+	StartedDate   *time.Time `json:"started_date,omitempty"`
+	FinishedDate  *time.Time `json:"finished_date,omitempty"`
+	CreatedDate   time.Time  `json:"created_date"`
+}
+
+const productImportColumns = `id, format, status, total_rows, processed_rows, created_rows, updated_rows,
+	failed_rows, error, started_date, finished_date, created_date`
+
+func scanProductImport(row pgx.Row, p *ProductImport) error {
+	return row.Scan(&p.ID, &p.Format, &p.Status, &p.TotalRows, &p.ProcessedRows, &p.CreatedRows, &p.UpdatedRows,
+		&p.FailedRows, &p.Error, &p.StartedDate, &p.FinishedDate, &p.CreatedDate)
+}
+
+// ImportProducts accepts a CSV or JSONL file, either as multipart field
+// "file" or as the raw body, and queues it for import. The format comes
+// from ?format=, the file extension or the Content-Type.
+func ImportProducts(pool *database.LoggingPool, client *asynq.Client) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+
+		format := strings.ToLower(c.Query("format"))
+		var data []byte
+		if fh, err := c.FormFile("file"); err == nil {
+			if fh.Size > productimport.MaxBytes {
+				return fiber.NewError(fiber.StatusRequestEntityTooLarge, "file is too large")
+			}
+			f, err := fh.Open()
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "failed to read file")
+			}
+			defer f.Close()
+			if data, err = io.ReadAll(f); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "failed to read file")
+			}
+			if format == "" {
+				format = strings.TrimPrefix(strings.ToLower(filepath.Ext(fh.Filename)), ".")
+			}
+		} else {
+			data = c.Body()
+			if format == "" {
+				format = importFormatFromContentType(string(c.Request().Header.ContentType()))
+			}
+		}
+		if format == "ndjson" {
+			format = productimport.FormatJSONL
+		}
+		if !productimport.ValidFormat(format) {
+			return fiber.NewError(fiber.StatusBadRequest, "format must be csv or jsonl")
+		}
+		if len(data) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "file is empty")
+		}
+		if len(data) > productimport.MaxBytes {
+			return fiber.NewError(fiber.StatusRequestEntityTooLarge, "file is too large")
+		}
+
+		var imp ProductImport
+		err = scanProductImport(pool.QueryRow(c.UserContext(),
+			`INSERT INTO product_imports (tenant_id, format) VALUES ($1,$2) RETURNING `+productImportColumns,
+			tenantID, format), &imp)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to create import")
+		}
+
+		payload, err := json.Marshal(tasks.ProductImportPayload{
+			TenantID: tenantID,
+			ImportID: imp.ID,
+			Format:   format,
+			Data:     data,
+		})
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to create task payload")
+		}
+		if _, err := client.Enqueue(asynq.NewTask(tasks.TypeProductImport, payload,
+			asynq.MaxRetry(3),
+			asynq.Timeout(30*time.Minute),
+		)); err != nil {
+			_ = productimport.Fail(c.UserContext(), pool, tenantID, imp.ID, err)
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to enqueue task")
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"message": "Import enqueued for processing",
+			"import":  imp,
+		})
+	}
+}
+
+func importFormatFromContentType(ct string) string {
+	switch {
+	case strings.HasPrefix(ct, "text/csv"):
+		return productimport.FormatCSV
+	case strings.HasPrefix(ct, "application/x-ndjson"), strings.HasPrefix(ct, "application/jsonl"):
+		return productimport.FormatJSONL
+	}
+	return ""
+}
+
+// GetProductImport returns the status and progress of an import.
+func GetProductImport(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid import id")
+		}
+
+		var imp ProductImport
+		err = scanProductImport(pool.QueryRow(c.UserContext(),
+			`SELECT `+productImportColumns+` FROM product_imports WHERE id=$1 AND tenant_id=$2`, id, tenantID), &imp)
+		if err == pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "import not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch import")
+		}
+		return c.JSON(imp)
+	}
+}
+
+// GetProductImportErrors downloads the per-row error report as CSV.
+func GetProductImportErrors(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid import id")
+		}
+
+		var exists bool
+		if err := pool.QueryRow(c.UserContext(),
+			`SELECT EXISTS (SELECT 1 FROM product_imports WHERE id=$1 AND tenant_id=$2)`, id, tenantID,
+		).Scan(&exists); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch import")
+		}
+		if !exists {
+			return fiber.NewError(fiber.StatusNotFound, "import not found")
+		}
+
+		rows, err := pool.Query(c.UserContext(),
+			`SELECT row_number, COALESCE(sku, ''), error FROM product_import_errors
+			 WHERE import_id=$1 AND tenant_id=$2 ORDER BY row_number`, id, tenantID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to load import errors")
+		}
+		defer rows.Close()
+
+		var b strings.Builder
+		w := csv.NewWriter(&b)
+		_ = w.Write([]string{"row", "sku", "error"})
+		for rows.Next() {
+			var rowNumber int
+			var sku, msg string
+			if err := rows.Scan(&rowNumber, &sku, &msg); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to read import error")
+			}
+			_ = w.Write([]string{strconv.Itoa(rowNumber), sku, msg})
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to load import errors")
+		}
+		w.Flush()
+
+		c.Set(fiber.HeaderContentType, "text/csv")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="product-import-%d-errors.csv"`, id))
+		return c.SendString(b.String())
+	}
+}
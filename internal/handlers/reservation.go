@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"atlasq/internal/database"
+	"atlasq/internal/inventory"
+	"atlasq/internal/tenant"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+)
+
+// Reservation is stock held for an order. Bundles are reserved as their
+// components, one reservation per component.
+type Reservation struct {
+	ID              int64      `json:"id"`
+	OrderID         int64      `json:"order_id"`
+	StockID         int64      `json:"stock_id"`
+	ProductID       int64      `json:"product_id"`
+	BundleProductID *int64     `json:"bundle_product_id,omitempty"`
+	Quantity        float64    `json:"quantity"`
+	Status          string     `json:"status"`
+	ReservedDate    time.Time  `json:"reserved_date"`
+	ReleasedDate    *time.Time `json:"released_date,omitempty"`
+	IssuedDate      *time.Time `json:"issued_date,omitempty"`
+	MovementID      *int64     `json:"movement_id,omitempty"` // movement 'reserve'
+}
+
+const reservationColumns = `id, order_id, stock_id, product_id, bundle_product_id, quantity, status,
+	reserved_date, released_date, issued_date, movement_id`
+
+func scanReservation(row pgx.Row, r *Reservation) error {
+	return row.Scan(&r.ID, &r.OrderID, &r.StockID, &r.ProductID, &r.BundleProductID, &r.Quantity, &r.Status,
+		&r.ReservedDate, &r.ReleasedDate, &r.IssuedDate, &r.MovementID)
+}
+
+// orderState คือส่วนของ order ที่ reserve / release / issue ใช้
+type orderState struct {
+	ID          int64
+	AppID       int64
+	StoreID     int64
+	WarehouseID int64
+	Reserved    bool
+	Issued      bool
+	Canceled    bool
+}
+
+// lockOrder อ่าน order พร้อม lock ไว้จนจบ tx กันสอง request เปลี่ยนสถานะพร้อมกัน
+func lockOrder(ctx context.Context, tx pgx.Tx, tenantID int64, orderID int) (orderState, error) {
+	var o orderState
+	err := tx.QueryRow(ctx,
+		`SELECT id, app_id, store_id, warehouse_id, reserved, issued, canceled
+		 FROM "order" WHERE id=$1 AND tenant_id=$2 AND deleted_date IS NULL
+		 FOR UPDATE`,
+		orderID, tenantID,
+	).Scan(&o.ID, &o.AppID, &o.StoreID, &o.WarehouseID, &o.Reserved, &o.Issued, &o.Canceled)
+	if err == pgx.ErrNoRows {
+		return o, fiber.NewError(fiber.StatusNotFound, "order not found")
+	}
+	if err != nil {
+		return o, fiber.NewError(fiber.StatusInternalServerError, "failed to fetch order")
+	}
+	return o, nil
+}
+
+// orderWarehouse คืน warehouse ของ order (ไม่ระบุ = default ของ tenant) ที่ยัง active
+func orderWarehouse(ctx context.Context, tx pgx.Tx, tenantID int64, settings tenant.Settings, o orderState) (int64, error) {
+	warehouseID := settings.WarehouseOrDefault(o.WarehouseID)
+	if warehouseID == 0 {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "warehouse_id is required")
+	}
+	if err := inventory.EnsureWarehouseActive(ctx, tx, tenantID, warehouseID); err != nil {
+		return 0, inventoryError(err)
+	}
+	return warehouseID, nil
+}
+
+// orderLines คืน order_item เป็นหน่วยฐาน โดย bundle ถูกแตกเป็น component แล้ว
+func orderLines(ctx context.Context, tx pgx.Tx, tenantID, orderID int64) ([]inventory.Line, error) {
+	type item struct {
+		ProductID int64
+		Quantity  float64
+	}
+	items := []item{}
+	rows, err := tx.Query(ctx,
+		`SELECT product_id, main_quantity FROM order_item WHERE order_id=$1 ORDER BY id`, orderID)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "failed to fetch order items")
+	}
+	for rows.Next() {
+		var it item
+		if err := rows.Scan(&it.ProductID, &it.Quantity); err != nil {
+			rows.Close()
+			return nil, fiber.NewError(fiber.StatusInternalServerError, "failed to read order item")
+		}
+		items = append(items, it)
+	}
+	rows.Close() // ต้องปิดก่อนใช้ tx ต่อ
+	if err := rows.Err(); err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "failed to fetch order items")
+	}
+	if len(items) == 0 {
+		return nil, fiber.NewError(fiber.StatusConflict, "order has no items")
+	}
+
+	lines := []inventory.Line{}
+	for _, it := range items {
+		if err := inventory.EnsureProductActive(ctx, tx, tenantID, it.ProductID); err != nil {
+			return nil, inventoryError(err)
+		}
+		exploded, err := inventory.Explode(ctx, tx, tenantID, it.ProductID, it.Quantity)
+		if err != nil {
+			return nil, inventoryError(err)
+		}
+		lines = append(lines, exploded...)
+	}
+	return lines, nil
+}
+
+// ReserveOrder จอง stock ของทุก item ใน order ไว้ (reserve += quantity)
+// ต้องมี available = balance - reserve พอ เว้นแต่ tenant เปิด allow_backorders
+func ReserveOrder(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		orderID, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid order id")
+		}
+
+		ctx := c.UserContext()
+		tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to begin transaction")
+		}
+		defer func() { _ = tx.Rollback(ctx) }()
+
+		o, err := lockOrder(ctx, tx, tenantID, orderID)
+		if err != nil {
+			return err
+		}
+		switch {
+		case o.Canceled:
+			return fiber.NewError(fiber.StatusConflict, "order is canceled")
+		case o.Issued:
+			return fiber.NewError(fiber.StatusConflict, "order is already issued")
+		case o.Reserved:
+			return fiber.NewError(fiber.StatusConflict, "order is already reserved")
+		}
+
+		settings, err := tenant.LoadSettings(ctx, tx, tenantID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to load tenant settings")
+		}
+		warehouseID, err := orderWarehouse(ctx, tx, tenantID, settings, o)
+		if err != nil {
+			return err
+		}
+		lines, err := orderLines(ctx, tx, tenantID, o.ID)
+		if err != nil {
+			return err
+		}
+
+		reservations := []Reservation{}
+		for _, line := range lines {
+			r, err := reserveLine(ctx, tx, tenantID, settings, o, warehouseID, line)
+			if err != nil {
+				return inventoryError(err)
+			}
+			reservations = append(reservations, r)
+		}
+
+		if _, err := tx.Exec(ctx,
+			`UPDATE "order" SET warehouse_id=$1, reserved=true, reserved_date=NOW(), updated_date=NOW() WHERE id=$2`,
+			warehouseID, o.ID,
+		); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to update order")
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to commit transaction")
+		}
+		return c.JSON(fiber.Map{"order_id": o.ID, "warehouse_id": warehouseID, "data": reservations})
+	}
+}
+
+// reserveLine เพิ่ม reserve ของ stock หนึ่งตัว บันทึก stock_reservations และ movement 'reserve'
+func reserveLine(ctx context.Context, tx pgx.Tx, tenantID int64, settings tenant.Settings, o orderState, warehouseID int64, line inventory.Line) (Reservation, error) {
+	var stockID int64
+	var balance, reserve float64
+	err := tx.QueryRow(ctx,
+		`SELECT id, balance, reserve FROM stock WHERE product_id=$1 AND warehouse_id=$2 AND tenant_id=$3 FOR UPDATE`,
+		line.ProductID, warehouseID, tenantID,
+	).Scan(&stockID, &balance, &reserve)
+	if err == pgx.ErrNoRows {
+		return Reservation{}, fmt.Errorf("product_id=%d warehouse_id=%d: %w", line.ProductID, warehouseID, inventory.ErrStockNotFound)
+	}
+	if err != nil {
+		return Reservation{}, fmt.Errorf("failed to fetch stock: %w", err)
+	}
+	if balance-reserve < line.Quantity && !settings.AllowBackorders {
+		return Reservation{}, fmt.Errorf("product_id=%d required=%v available=%v: %w",
+			line.ProductID, line.Quantity, balance-reserve, inventory.ErrInsufficientStock)
+	}
+
+	if err := inventory.AddBalance(ctx, tx, stockID, 0, line.Quantity); err != nil {
+		return Reservation{}, err
+	}
+
+	movementID, err := insertReserveMovement(ctx, tx, o, stockID, balance, reserve, line.Quantity, "reserve", line.BundleProductID, nil)
+	if err != nil {
+		return Reservation{}, err
+	}
+
+	var r Reservation
+	err = scanReservation(tx.QueryRow(ctx,
+		`INSERT INTO stock_reservations (tenant_id, order_id, stock_id, product_id, bundle_product_id, quantity, movement_id)
+		 VALUES ($1,$2,$3,$4,$5,$6,$7)
+		 RETURNING `+reservationColumns,
+		tenantID, o.ID, stockID, line.ProductID, line.BundleProductID, line.Quantity, movementID,
+	), &r)
+	if err != nil {
+		return Reservation{}, fmt.Errorf("failed to insert reservation: %w", err)
+	}
+	return r, nil
+}
+
+// ReleaseOrder คืนยอดที่ order จองไว้ (reserve -= quantity) order กลับไปเป็นยังไม่ได้ reserve
+func ReleaseOrder(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		orderID, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid order id")
+		}
+
+		ctx := c.UserContext()
+		tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to begin transaction")
+		}
+		defer func() { _ = tx.Rollback(ctx) }()
+
+		o, err := lockOrder(ctx, tx, tenantID, orderID)
+		if err != nil {
+			return err
+		}
+		if o.Issued {
+			return fiber.NewError(fiber.StatusConflict, "order is already issued")
+		}
+		if !o.Reserved {
+			return fiber.NewError(fiber.StatusConflict, "order is not reserved")
+		}
+
+		reservations, err := releaseOrder(ctx, tx, tenantID, o)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE "order" SET reserved=false, updated_date=NOW() WHERE id=$1`, o.ID,
+		); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to update order")
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to commit transaction")
+		}
+		return c.JSON(fiber.Map{"order_id": o.ID, "data": reservations})
+	}
+}
+
+// releaseOrder ปล่อยทุก reservation ที่ยัง 'reserved' ของ order
+func releaseOrder(ctx context.Context, tx pgx.Tx, tenantID int64, o orderState) ([]Reservation, error) {
+	reservations, err := activeReservations(ctx, tx, tenantID, o.ID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range reservations {
+		r := &reservations[i]
+		var balance, reserve float64
+		if err := tx.QueryRow(ctx,
+			`SELECT balance, reserve FROM stock WHERE id=$1 FOR UPDATE`, r.StockID,
+		).Scan(&balance, &reserve); err != nil {
+			return nil, fmt.Errorf("failed to fetch stock: %w", err)
+		}
+		if err := inventory.AddBalance(ctx, tx, r.StockID, 0, -r.Quantity); err != nil {
+			return nil, err
+		}
+		if _, err := insertReserveMovement(ctx, tx, o, r.StockID, balance, reserve, -r.Quantity, "release", r.BundleProductID, r.MovementID); err != nil {
+			return nil, err
+		}
+		if err := scanReservation(tx.QueryRow(ctx,
+			`UPDATE stock_reservations SET status='released', released_date=NOW(), updated_date=NOW()
+			 WHERE id=$1 RETURNING `+reservationColumns, r.ID,
+		), r); err != nil {
+			return nil, fmt.Errorf("failed to update reservation: %w", err)
+		}
+	}
+	return reservations, nil
+}
+
+// IssueOrder ตัด stock ของ order ออกจากคลัง ถ้า order reserve ไว้จะตัดจากยอดที่จอง
+// (balance และ reserve ลดพร้อมกัน) ไม่งั้นตัดตรงจาก available
+func IssueOrder(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		orderID, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid order id")
+		}
+
+		ctx := c.UserContext()
+		tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to begin transaction")
+		}
+		defer func() { _ = tx.Rollback(ctx) }()
+
+		o, err := lockOrder(ctx, tx, tenantID, orderID)
+		if err != nil {
+			return err
+		}
+		switch {
+		case o.Canceled:
+			return fiber.NewError(fiber.StatusConflict, "order is canceled")
+		case o.Issued:
+			return fiber.NewError(fiber.StatusConflict, "order is already issued")
+		}
+
+		settings, err := tenant.LoadSettings(ctx, tx, tenantID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to load tenant settings")
+		}
+		warehouseID, err := orderWarehouse(ctx, tx, tenantID, settings, o)
+		if err != nil {
+			return err
+		}
+
+		req := &StockIssueRequest{
+			AppID:           o.AppID,
+			StoreID:         o.StoreID,
+			WarehouseID:     warehouseID,
+			Model:           "order",
+			OrderID:         &o.ID,
+			FromReservation: o.Reserved,
+		}
+		if o.Reserved {
+			reservations, err := activeReservations(ctx, tx, tenantID, o.ID)
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+			}
+			for _, r := range reservations {
+				line := inventory.Line{ProductID: r.ProductID, Quantity: r.Quantity, BundleProductID: r.BundleProductID}
+				if err := issueLine(ctx, tx, tenantID, settings, req, line); err != nil {
+					return inventoryError(err)
+				}
+			}
+			if _, err := tx.Exec(ctx,
+				`UPDATE stock_reservations SET status='issued', issued_date=NOW(), updated_date=NOW()
+				 WHERE order_id=$1 AND status='reserved'`, o.ID,
+			); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to update reservations")
+			}
+		} else {
+			lines, err := orderLines(ctx, tx, tenantID, o.ID)
+			if err != nil {
+				return err
+			}
+			for _, line := range lines {
+				if err := issueLine(ctx, tx, tenantID, settings, req, line); err != nil {
+					return inventoryError(err)
+				}
+			}
+		}
+
+		if _, err := tx.Exec(ctx,
+			`UPDATE "order" SET warehouse_id=$1, issued=true, issued_date=NOW(), updated_date=NOW() WHERE id=$2`,
+			warehouseID, o.ID,
+		); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to update order")
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to commit transaction")
+		}
+		return c.JSON(fiber.Map{"order_id": o.ID, "warehouse_id": warehouseID, "from_reservation": o.Reserved})
+	}
+}
+
+// ListOrderReservations คืน reservation ทั้งหมดของ order รวมที่ release / issue ไปแล้ว
+func ListOrderReservations(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		orderID, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid order id")
+		}
+
+		rows, err := pool.Query(c.UserContext(),
+			`SELECT `+reservationColumns+` FROM stock_reservations WHERE tenant_id=$1 AND order_id=$2 ORDER BY id`,
+			tenantID, orderID,
+		)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch reservations")
+		}
+		defer rows.Close()
+
+		reservations := []Reservation{}
+		for rows.Next() {
+			var r Reservation
+			if err := scanReservation(rows, &r); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to read reservation")
+			}
+			reservations = append(reservations, r)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch reservations")
+		}
+		return c.JSON(fiber.Map{"order_id": orderID, "data": reservations})
+	}
+}
+
+// activeReservations คืน reservation ที่ยัง 'reserved' ของ order พร้อม lock
+func activeReservations(ctx context.Context, tx pgx.Tx, tenantID, orderID int64) ([]Reservation, error) {
+	rows, err := tx.Query(ctx,
+		`SELECT `+reservationColumns+` FROM stock_reservations
+		 WHERE tenant_id=$1 AND order_id=$2 AND status='reserved'
+		 ORDER BY id FOR UPDATE`,
+		tenantID, orderID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch reservations: %w", err)
+	}
+	reservations := []Reservation{}
+	for rows.Next() {
+		var r Reservation
+		if err := scanReservation(rows, &r); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read reservation: %w", err)
+		}
+		reservations = append(reservations, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch reservations: %w", err)
+	}
+	return reservations, nil
+}
+
+// insertReserveMovement บันทึก movement ที่เปลี่ยนแค่ reserve (ไม่ผูกกับ lot) และคืน id
+// release อ้าง movement 'reserve' ที่มันคืนผ่าน referenceID
+func insertReserveMovement(ctx context.Context, tx pgx.Tx, o orderState, stockID int64, balance, reserve, change float64, action string, bundleProductID, referenceID *int64) (int64, error) {
+	var id int64
+	err := tx.QueryRow(ctx, `
+		INSERT INTO stock_movement (
+			app_id, store_id, stock_id, lot_id, balance_before, balance_after, balance_change,
+			reserve_before, reserve_after, reserve_change, cost_fifo, cost_average,
+			action, model, bundle_product_id, order_id, reference_movement_id, created_date, updated_date
+		) VALUES ($1,$2,$3,NULL,$4,$4,0,$5,$6,$7,0,0,$8,'order',$9,$10,$11,NOW(),NOW())
+		RETURNING id`,
+		o.AppID, o.StoreID, stockID, balance, reserve, reserve+change, change, action, bundleProductID, o.ID, referenceID,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert stock_movement: %w", err)
+	}
+	return id, nil
+}
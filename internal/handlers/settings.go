@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"atlasq/internal/database"
+	"atlasq/internal/inventory"
+	"atlasq/internal/tenant"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+)
+
+func GetSettings(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+
+		s, err := tenant.LoadSettings(c.UserContext(), pool, tenantID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to load settings")
+		}
+		return c.JSON(s)
+	}
+}
+
+type UpdateSettingsRequest struct {
+	DefaultWarehouseID *int64  `json:"default_warehouse_id"`
+	Currency           *string `json:"currency"`
+	Timezone           *string `json:"timezone"`
+	AutoCreateStock    *bool   `json:"auto_create_stock"`
+	AllowBackorders    *bool   `json:"allow_backorders"`
+	CostingMethod      *string `json:"costing_method"`
+
+	AdjustmentApprovalThreshold *float64 `json:"adjustment_approval_threshold"`
+}
+
+func UpdateSettings(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		ctx := c.UserContext()
+
+		var req UpdateSettingsRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+
+		tx, err := pool.BeginTx(ctx, pgx.TxOptions{})
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to start transaction")
+		}
+		defer tx.Rollback(ctx)
+
+		s, err := tenant.LoadSettings(ctx, tx, tenantID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to load settings")
+		}
+
+		if req.DefaultWarehouseID != nil {
+			if err := inventory.EnsureWarehouseActive(ctx, tx, tenantID, *req.DefaultWarehouseID); err != nil {
+				return inventoryError(err)
+			}
+			s.DefaultWarehouseID = req.DefaultWarehouseID
+		}
+		if req.Currency != nil {
+			if len(*req.Currency) != 3 {
+				return fiber.NewError(fiber.StatusBadRequest, "currency must be a 3-letter ISO code")
+			}
+			s.Currency = strings.ToUpper(*req.Currency)
+		}
+		if req.Timezone != nil {
+			if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" {
+				return fiber.NewError(fiber.StatusBadRequest, "unknown timezone")
+			}
+			s.Timezone = *req.Timezone
+		}
+		if req.AutoCreateStock != nil {
+			s.AutoCreateStock = *req.AutoCreateStock
+		}
+		if req.AllowBackorders != nil {
+			s.AllowBackorders = *req.AllowBackorders
+		}
+		if req.CostingMethod != nil {
+			method := strings.ToUpper(*req.CostingMethod)
+			if method != tenant.CostingFIFO && method != tenant.CostingLIFO {
+				return fiber.NewError(fiber.StatusBadRequest, "costing_method must be FIFO or LIFO")
+			}
+			s.CostingMethod = method
+		}
+		if req.AdjustmentApprovalThreshold != nil {
+			if *req.AdjustmentApprovalThreshold < 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "adjustment_approval_threshold must be >= 0")
+			}
+			s.AdjustmentApprovalThreshold = *req.AdjustmentApprovalThreshold
+		}
+
+		err = tenant.ScanSettings(tx.QueryRow(ctx, `
+			INSERT INTO tenant_settings (
+				tenant_id, default_warehouse_id, currency, timezone,
+				auto_create_stock, allow_backorders, costing_method, adjustment_approval_threshold
+			) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+			ON CONFLICT (tenant_id) DO UPDATE SET
+				default_warehouse_id = EXCLUDED.default_warehouse_id,
+				currency = EXCLUDED.currency,
+				timezone = EXCLUDED.timezone,
+				auto_create_stock = EXCLUDED.auto_create_stock,
+				allow_backorders = EXCLUDED.allow_backorders,
+				costing_method = EXCLUDED.costing_method,
+				adjustment_approval_threshold = EXCLUDED.adjustment_approval_threshold,
+				updated_date = CURRENT_TIMESTAMP,
+				row_updated_date = CURRENT_TIMESTAMP
+			RETURNING `+tenant.SettingsColumns,
+			tenantID, s.DefaultWarehouseID, s.Currency, s.Timezone,
+			s.AutoCreateStock, s.AllowBackorders, s.CostingMethod, s.AdjustmentApprovalThreshold,
+		), &s)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to save settings")
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to commit transaction")
+		}
+		return c.JSON(s)
+	}
+}
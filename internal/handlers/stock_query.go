@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"atlasq/internal/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+)
+
+// StockLevel คือ stock ของ product หนึ่งตัวใน warehouse หนึ่ง available = balance - reserve
+type StockLevel struct {
+	ProductID   int64   `json:"product_id"`
+	SKU         *string `json:"sku,omitempty"`
+	WarehouseID int64   `json:"warehouse_id"`
+	Balance     float64 `json:"balance"`
+	Reserve     float64 `json:"reserve"`
+	Available   float64 `json:"available"`
+	OnHand      float64 `json:"on_hand"`
+}
+
+// StockSummary รวม stock ของ product ทุก warehouse ที่ active
+type StockSummary struct {
+	ProductID  int64   `json:"product_id"`
+	SKU        *string `json:"sku,omitempty"`
+	Balance    float64 `json:"balance"`
+	Reserve    float64 `json:"reserve"`
+	Available  float64 `json:"available"`
+	OnHand     float64 `json:"on_hand"`
+	Warehouses int     `json:"warehouses"`
+}
+
+// stockFilter อ่าน ?product_id= และ ?warehouse_id= (คั่นด้วย comma ได้หลายค่า)
+// คืน WHERE ของ stock s / warehouses w กับ args โดย tenant เป็น $1
+func stockFilter(c *fiber.Ctx, tenantID int64) (string, []interface{}, error) {
+	args := []interface{}{tenantID}
+	where := []string{"s.tenant_id=$1", "w.deleted_date IS NULL"}
+	for _, col := range []string{"product_id", "warehouse_id"} {
+		ids, err := idListParam(c, col)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(ids) > 0 {
+			args = append(args, ids)
+			where = append(where, fmt.Sprintf("s.%s = ANY($%d)", col, len(args)))
+		}
+	}
+	return strings.Join(where, " AND "), args, nil
+}
+
+func idListParam(c *fiber.Ctx, name string) ([]int64, error) {
+	v := c.Query(name)
+	if v == "" {
+		return nil, nil
+	}
+	ids := []int64{}
+	for _, part := range strings.Split(v, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "invalid "+name)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) > maxPageLimit {
+		return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("at most %d %s values", maxPageLimit, name))
+	}
+	return ids, nil
+}
+
+// stockSnapshot เปิด tx read-only แบบ repeatable read ให้ validator, count และหน้า data เห็น snapshot เดียวกัน
+func stockSnapshot(ctx context.Context, pool *database.LoggingPool) (pgx.Tx, error) {
+	return pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+}
+
+// stockETag คำนวณ ETag จาก aggregate ถูกๆ ของ row ที่ตรง filter แทนการ hash body ทั้งหน้า
+// stock/warehouse มี update timestamp ส่วน product ไม่มีจึงใส่ hash ของ sku แทน
+// totalExpr คือ count ที่ handler ใช้เป็น total ของ response
+// ตั้ง header ETag แล้วคืน fresh=true ถ้า If-None-Match ตรง (ตอบ 304 ได้เลยไม่ต้อง query หน้า data)
+func stockETag(c *fiber.Ctx, tx pgx.Tx, totalExpr, from string, args []interface{}) (total int64, fresh bool, err error) {
+	var rows int64
+	var stockUpdated, warehouseUpdated *time.Time
+	var skuHash int64
+	err = tx.QueryRow(c.UserContext(),
+		`SELECT `+totalExpr+`, COUNT(*), MAX(s.row_update_date), MAX(w.updated_date),
+		        COALESCE(SUM(hashtext(COALESCE(p.sku, ''))), 0)`+from, args...,
+	).Scan(&total, &rows, &stockUpdated, &warehouseUpdated, &skuHash)
+	if err != nil {
+		return 0, false, fiber.NewError(fiber.StatusInternalServerError, "failed to count stock")
+	}
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%s|%d|%d|%d|%s|%s", c.Path(), c.Request().URI().QueryString(), total, rows, skuHash,
+		formatVersion(stockUpdated), formatVersion(warehouseUpdated))
+	c.Set(fiber.HeaderETag, fmt.Sprintf(`"%x"`, h.Sum64()))
+	// ไม่มี Last-Modified ให้เทียบ ต้องมี If-None-Match เท่านั้นถึงนับว่า fresh
+	return total, c.Get(fiber.HeaderIfNoneMatch) != "" && c.Fresh(), nil
+}
+
+func formatVersion(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// ListStock คืน stock ราย product + warehouse เรียงตาม product_id, warehouse_id
+// response มี ETag (stockETag) client ส่ง If-None-Match มาจะได้ 304 ก่อน query หน้า data
+func ListStock(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		page, limit, offset := pageParams(c)
+		whereSQL, args, err := stockFilter(c, tenantID)
+		if err != nil {
+			return err
+		}
+
+		from := ` FROM stock s
+			JOIN warehouses w ON w.id = s.warehouse_id
+			JOIN product p ON p.id = s.product_id
+			WHERE ` + whereSQL
+
+		tx, err := stockSnapshot(c.UserContext(), pool)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to start transaction")
+		}
+		defer tx.Rollback(c.UserContext())
+
+		total, fresh, err := stockETag(c, tx, "COUNT(*)", from, args)
+		if err != nil {
+			return err
+		}
+		if fresh {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+
+		args = append(args, limit, offset)
+		rows, err := tx.Query(c.UserContext(), fmt.Sprintf(
+			`SELECT s.product_id, p.sku, s.warehouse_id, s.balance, s.reserve, s.balance - s.reserve, s.on_hand
+			 %s ORDER BY s.product_id, s.warehouse_id LIMIT $%d OFFSET $%d`,
+			from, len(args)-1, len(args),
+		), args...)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list stock")
+		}
+		defer rows.Close()
+
+		levels := []StockLevel{}
+		for rows.Next() {
+			var l StockLevel
+			if err := rows.Scan(&l.ProductID, &l.SKU, &l.WarehouseID, &l.Balance, &l.Reserve, &l.Available, &l.OnHand); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to read stock")
+			}
+			levels = append(levels, l)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list stock")
+		}
+
+		return c.JSON(fiber.Map{
+			"data":  levels,
+			"page":  page,
+			"limit": limit,
+			"total": total,
+		})
+	}
+}
+
+// StockSummaryHandler รวม stock ของแต่ละ product ข้าม warehouse (เฉพาะ warehouse ที่ active)
+// กรอง warehouse_id ได้เพื่อรวมเฉพาะบาง warehouse
+func StockSummaryHandler(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		page, limit, offset := pageParams(c)
+		whereSQL, args, err := stockFilter(c, tenantID)
+		if err != nil {
+			return err
+		}
+		whereSQL += " AND w.status=1"
+
+		from := ` FROM stock s
+			JOIN warehouses w ON w.id = s.warehouse_id
+			JOIN product p ON p.id = s.product_id
+			WHERE ` + whereSQL
+
+		tx, err := stockSnapshot(c.UserContext(), pool)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to start transaction")
+		}
+		defer tx.Rollback(c.UserContext())
+
+		total, fresh, err := stockETag(c, tx, "COUNT(DISTINCT s.product_id)", from, args)
+		if err != nil {
+			return err
+		}
+		if fresh {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+
+		args = append(args, limit, offset)
+		rows, err := tx.Query(c.UserContext(), fmt.Sprintf(
+			`SELECT s.product_id, p.sku, SUM(s.balance), SUM(s.reserve), SUM(s.balance - s.reserve), SUM(s.on_hand), COUNT(*)
+			 %s GROUP BY s.product_id, p.sku ORDER BY s.product_id LIMIT $%d OFFSET $%d`,
+			from, len(args)-1, len(args),
+		), args...)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to summarize stock")
+		}
+		defer rows.Close()
+
+		summaries := []StockSummary{}
+		for rows.Next() {
+			var s StockSummary
+			if err := rows.Scan(&s.ProductID, &s.SKU, &s.Balance, &s.Reserve, &s.Available, &s.OnHand, &s.Warehouses); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to read stock")
+			}
+			summaries = append(summaries, s)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to summarize stock")
+		}
+
+		return c.JSON(fiber.Map{
+			"data":  summaries,
+			"page":  page,
+			"limit": limit,
+			"total": total,
+		})
+	}
+}
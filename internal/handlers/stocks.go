@@ -4,11 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"time"
+
+	"atlasq/internal/database"
+	"atlasq/internal/inventory"
+	"atlasq/internal/location"
+	"atlasq/internal/stockissue"
+	"atlasq/internal/tenant"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/jackc/pgx/v4"
-	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 // StockIssueRequest สำหรับรับ input
@@ -18,23 +22,31 @@ type StockIssueRequest struct {
 	ProductID   int64   `json:"product_id"`
 	WarehouseID int64   `json:"warehouse_id"`
 	Quantity    float64 `json:"quantity"`
-	Model       string  `json:"model"` // <-- เพิ่มตรงนี้
+	Unit        string  `json:"unit,omitempty"` // ว่าง = หน่วยฐาน
+	Model       string  `json:"model"`          // <-- เพิ่มตรงนี้
+
+	// ตั้งโดย order flow เท่านั้น: FromReservation ตัดจากยอดที่ reserve ไว้แล้ว
+	OrderID         *int64 `json:"-"`
+	FromReservation bool   `json:"-"`
 }
 
 // Fiber handler สำหรับ /stock-issue
-func StockIssueHandler(pool *pgxpool.Pool) fiber.Handler {
+func StockIssueHandler(pool *database.LoggingPool) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var req StockIssueRequest
 		if err := c.BodyParser(&req); err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 		}
 
-		fmt.Println("request %v", req)
-		if req.AppID == 0 || req.StoreID == 0 || req.ProductID == 0 || req.WarehouseID == 0 || req.Quantity <= 0 {
+		// warehouse_id ไม่บังคับ ถ้าไม่ส่งมาจะใช้ default warehouse ของ tenant
+		if req.AppID == 0 || req.StoreID == 0 || req.ProductID == 0 || req.Quantity <= 0 {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
 		}
 
-		if err := StockIssue(c.Context(), pool, req); err != nil {
+		if err := StockIssue(c.UserContext(), pool, &req); err != nil {
+			if inventory.IsInvalid(err) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+			}
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 		}
 
@@ -50,123 +62,258 @@ func StockIssueHandler(pool *pgxpool.Pool) fiber.Handler {
 }
 
 // StockIssue logic transaction + Serializable isolation
-// StockIssue logic transaction + Serializable isolation
-func StockIssue(ctx context.Context, pool *pgxpool.Pool, req StockIssueRequest) error {
+// lot ถูกตัดตาม costing_method ของ tenant และ req.WarehouseID ถูกเติมด้วย default warehouse ถ้าเป็น 0
+// req.Quantity ถูกแปลงเป็นหน่วยฐานถ้าส่ง unit มา
+func StockIssue(ctx context.Context, pool *database.LoggingPool, req *StockIssueRequest) error {
+	tenantID, ok := tenant.IDFromContext(ctx)
+	if !ok {
+		return errors.New("tenant is required")
+	}
+
 	tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer func() { _ = tx.Rollback(ctx) }()
 
-	// Lock stock row
-	var stockID int64
-	var balance, reserve, onHand float64
-	err = tx.QueryRow(ctx, `
-		SELECT id, balance, reserve, on_hand 
-		FROM stock 
-		WHERE product_id=$1 AND warehouse_id=$2 
-		FOR UPDATE
-	`, req.ProductID, req.WarehouseID).Scan(&stockID, &balance, &reserve, &onHand)
+	settings, err := tenant.LoadSettings(ctx, tx, tenantID)
 	if err != nil {
-		return fmt.Errorf("failed to fetch stock: %w", err)
+		return fmt.Errorf("failed to load tenant settings: %w", err)
 	}
-
-	if balance < req.Quantity {
-		return errors.New("insufficient stock balance")
+	req.WarehouseID = settings.WarehouseOrDefault(req.WarehouseID)
+	if req.WarehouseID == 0 {
+		return inventory.ErrWarehouseRequired
+	}
+	if err := inventory.EnsureWarehouseActive(ctx, tx, tenantID, req.WarehouseID); err != nil {
+		return err
 	}
 
-	// Update stock table
-	_, err = tx.Exec(ctx, `
-		UPDATE stock 
-		SET balance = balance - $1, reserve = reserve - $1 
-		WHERE id = $2
-	`, req.Quantity, stockID)
+	if err := inventory.EnsureProductActive(ctx, tx, tenantID, req.ProductID); err != nil {
+		return err
+	}
+	req.Quantity, err = inventory.ToBase(ctx, tx, tenantID, req.ProductID, req.Unit, req.Quantity)
 	if err != nil {
-		return fmt.Errorf("failed to update stock: %w", err)
+		return err
 	}
+	req.Unit = ""
 
-	// Update stock_balance
-	currentYearMonth := time.Date(time.Now().Year(), time.Now().Month(), 1, 0, 0, 0, 0, time.UTC)
-	_, err = tx.Exec(ctx, `
-		UPDATE stock_balance
-		SET balance = balance - $1, reserve = reserve - $1
-		WHERE stock_id=$2 AND year_month >= $3
-	`, req.Quantity, stockID, currentYearMonth)
-	if err != nil {
-		return fmt.Errorf("failed to update stock_balance: %w", err)
-	}
-
-	// ดึง lot ทั้งหมดก่อน
-	type Lot struct {
-		ID          int64
-		Balance     float64
-		CostFIFO    float64
-		CostAverage float64
-	}
-	lots := []Lot{}
-	rows, err := tx.Query(ctx, `
-		SELECT id, balance, cost_fifo, cost_average
-		FROM lot
-		WHERE stock_id=$1 AND balance > 0
-		ORDER BY created_date ASC
-	`, stockID)
+	// bundle ตัด stock ที่ component แทน ทุก line อยู่ใน tx เดียวกัน
+	lines, err := inventory.Explode(ctx, tx, tenantID, req.ProductID, req.Quantity)
 	if err != nil {
-		return fmt.Errorf("failed to fetch lots: %w", err)
+		return err
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var l Lot
-		if err := rows.Scan(&l.ID, &l.Balance, &l.CostFIFO, &l.CostAverage); err != nil {
+	for _, line := range lines {
+		if err := issueLine(ctx, tx, tenantID, settings, req, line); err != nil {
 			return err
 		}
-		lots = append(lots, l)
 	}
 
-	// Deduct lots FIFO
-	remaining := req.Quantity
-	for _, lot := range lots {
-		toDeduct := remaining
-		if lot.Balance < toDeduct {
-			toDeduct = lot.Balance
-		}
+	// Commit transaction
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
 
-		// Update lot
-		if _, err := tx.Exec(ctx, `UPDATE lot SET balance = balance - $1 WHERE id=$2`, toDeduct, lot.ID); err != nil {
-			return fmt.Errorf("failed to update lot: %w", err)
+	return nil
+}
+
+// issueLine ตัด line ตาม req ผ่าน stockissue ทางเดียวกับ worker และ /orders-old
+func issueLine(ctx context.Context, tx pgx.Tx, tenantID int64, settings tenant.Settings, req *StockIssueRequest, line inventory.Line) error {
+	return stockissue.Line(ctx, tx, tenantID, settings, stockissue.Request{
+		AppID:           req.AppID,
+		StoreID:         req.StoreID,
+		WarehouseID:     req.WarehouseID,
+		Model:           req.Model,
+		OrderID:         req.OrderID,
+		FromReservation: req.FromReservation,
+	}, line)
+}
+
+// StockReceiveRequest รับของเข้า (goods receipt) UnitCost คือต้นทุนต่อ unit ที่ส่งมา
+type StockReceiveRequest struct {
+	AppID       int64   `json:"app_id"`
+	StoreID     int64   `json:"store_id"`
+	ProductID   int64   `json:"product_id"`
+	WarehouseID int64   `json:"warehouse_id"`
+	LocationID  *int64  `json:"location_id,omitempty"` // bin ที่เก็บ ว่าง = ยังไม่เก็บเข้า bin
+	Quantity    float64 `json:"quantity"`
+	Unit        string  `json:"unit,omitempty"` // ว่าง = หน่วยฐาน
+	UnitCost    float64 `json:"unit_cost"`
+	Model       string  `json:"model"`
+}
+
+// StockReceiveResult is the lot created by a receipt.
+type StockReceiveResult struct {
+	LotID       int64   `json:"lot_id"`
+	Quantity    float64 `json:"quantity"` // หน่วยฐาน
+	CostFIFO    float64 `json:"cost_fifo"`
+	CostAverage float64 `json:"cost_average"`
+}
+
+// Fiber handler สำหรับ /stock-receive
+func StockReceiveHandler(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req StockReceiveRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 		}
 
-		// Insert stock_movement
-		_, err = tx.Exec(ctx, `
-			INSERT INTO stock_movement (
-				app_id, store_id, stock_id, lot_id, balance_before, balance_after, balance_change,
-				reserve_before, reserve_after, reserve_change, cost_fifo, cost_average,
-				action, model, created_date, updated_date
-			) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,'issue',$13,NOW(),NOW())`,
-			req.AppID, req.StoreID, stockID, lot.ID, balance, balance-toDeduct, -toDeduct,
-			reserve, reserve-toDeduct, -toDeduct, lot.CostFIFO, lot.CostAverage, req.Model)
+		// warehouse_id ไม่บังคับ ถ้าไม่ส่งมาจะใช้ default warehouse ของ tenant
+		if req.AppID == 0 || req.StoreID == 0 || req.ProductID == 0 || req.Quantity <= 0 || req.UnitCost < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
+		}
 
+		res, err := StockReceive(c.UserContext(), pool, &req)
 		if err != nil {
-			return fmt.Errorf("failed to insert stock_movement: %w", err)
+			if location.IsInvalid(err) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message":      "Stock received successfully",
+			"app_id":       req.AppID,
+			"store_id":     req.StoreID,
+			"product":      req.ProductID,
+			"warehouse":    req.WarehouseID,
+			"location":     req.LocationID,
+			"quantity":     res.Quantity,
+			"lot_id":       res.LotID,
+			"cost_fifo":    res.CostFIFO,
+			"cost_average": res.CostAverage,
+		})
+	}
+}
+
+// StockReceive ด้านกลับของ StockIssue: เพิ่ม stock, stock_balance และสร้าง lot ใหม่
+// cost_average คิดใหม่แบบถัวเฉลี่ยถ่วงน้ำหนักกับ lot ที่ยังเหลือ แล้วอัปเดตให้ทุก lot ที่เหลือ
+// transaction + Serializable isolation เหมือน StockIssue
+func StockReceive(ctx context.Context, pool *database.LoggingPool, req *StockReceiveRequest) (StockReceiveResult, error) {
+	tenantID, ok := tenant.IDFromContext(ctx)
+	if !ok {
+		return StockReceiveResult{}, errors.New("tenant is required")
+	}
+
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+	if err != nil {
+		return StockReceiveResult{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	settings, err := tenant.LoadSettings(ctx, tx, tenantID)
+	if err != nil {
+		return StockReceiveResult{}, fmt.Errorf("failed to load tenant settings: %w", err)
+	}
+	req.WarehouseID = settings.WarehouseOrDefault(req.WarehouseID)
+	if req.WarehouseID == 0 {
+		return StockReceiveResult{}, inventory.ErrWarehouseRequired
+	}
+	if err := inventory.EnsureWarehouseActive(ctx, tx, tenantID, req.WarehouseID); err != nil {
+		return StockReceiveResult{}, err
+	}
+	if req.LocationID != nil {
+		if err := location.EnsureBin(ctx, tx, tenantID, req.WarehouseID, *req.LocationID); err != nil {
+			return StockReceiveResult{}, err
 		}
+	}
+	if err := inventory.EnsureProductActive(ctx, tx, tenantID, req.ProductID); err != nil {
+		return StockReceiveResult{}, err
+	}
+	components, err := inventory.Components(ctx, tx, tenantID, req.ProductID)
+	if err != nil {
+		return StockReceiveResult{}, fmt.Errorf("failed to load bundle components: %w", err)
+	}
+	if len(components) > 0 {
+		return StockReceiveResult{}, fmt.Errorf("product_id=%d: %w", req.ProductID, inventory.ErrBundleNotStocked)
+	}
 
-		remaining -= toDeduct
-		balance -= toDeduct
-		reserve -= toDeduct
+	// unit_cost เป็นต้นทุนต่อ unit ที่ส่งมา แปลงเป็นต้นทุนต่อหน่วยฐาน
+	qty, err := inventory.ToBase(ctx, tx, tenantID, req.ProductID, req.Unit, req.Quantity)
+	if err != nil {
+		return StockReceiveResult{}, err
+	}
+	costFIFO := req.UnitCost * req.Quantity / qty
+	req.Quantity, req.Unit = qty, ""
 
-		if remaining <= 0 {
-			break
+	// Lock stock row
+	var stockID int64
+	var balance, reserve float64
+	err = tx.QueryRow(ctx, `
+		SELECT id, balance, reserve
+		FROM stock
+		WHERE product_id=$1 AND warehouse_id=$2 AND tenant_id=$3
+		FOR UPDATE
+	`, req.ProductID, req.WarehouseID, tenantID).Scan(&stockID, &balance, &reserve)
+	if err == pgx.ErrNoRows {
+		if !settings.AutoCreateStock {
+			return StockReceiveResult{}, fmt.Errorf("product_id=%d warehouse_id=%d: %w", req.ProductID, req.WarehouseID, inventory.ErrStockNotFound)
+		}
+		err = tx.QueryRow(ctx, `
+			INSERT INTO stock (
+				tenant_id, warehouse_id, product_id,
+				minimum, quantity, balance, reserve, on_hand, status,
+				create_date, update_date, row_create_date, row_update_date
+			) VALUES ($1,$2,$3,0,0,0,0,0,true,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP)
+			RETURNING id, balance, reserve
+		`, tenantID, req.WarehouseID, req.ProductID).Scan(&stockID, &balance, &reserve)
+	}
+	if err != nil {
+		return StockReceiveResult{}, fmt.Errorf("failed to fetch stock: %w", err)
+	}
+
+	// ถัวเฉลี่ยกับ lot ที่ยังเหลือ (lock ไว้กัน issue พร้อมกัน)
+	var lotQty, lotValue float64
+	err = tx.QueryRow(ctx, `
+		SELECT COALESCE(SUM(balance), 0), COALESCE(SUM(balance * cost_average), 0)
+		FROM (SELECT balance, cost_average FROM lot WHERE stock_id=$1 AND balance > 0 FOR UPDATE) l
+	`, stockID).Scan(&lotQty, &lotValue)
+	if err != nil {
+		return StockReceiveResult{}, fmt.Errorf("failed to fetch lots: %w", err)
+	}
+	costAverage := (lotValue + qty*costFIFO) / (lotQty + qty)
+
+	// stock + stock_balance (reserve ไม่เปลี่ยน ของเข้าใหม่เป็น available ทั้งหมด)
+	if err := inventory.AddBalance(ctx, tx, stockID, qty, 0); err != nil {
+		return StockReceiveResult{}, err
+	}
+
+	// lot เดิมที่ยังเหลือใช้ต้นทุนเฉลี่ยใหม่
+	if _, err := tx.Exec(ctx, `UPDATE lot SET cost_average=$1 WHERE stock_id=$2 AND balance > 0`, costAverage, stockID); err != nil {
+		return StockReceiveResult{}, fmt.Errorf("failed to update lot cost: %w", err)
+	}
+
+	var lotID int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO lot (stock_id, balance, cost_fifo, cost_average, created_date)
+		VALUES ($1,$2,$3,$4,NOW())
+		RETURNING id
+	`, stockID, qty, costFIFO, costAverage).Scan(&lotID)
+	if err != nil {
+		return StockReceiveResult{}, fmt.Errorf("failed to insert lot: %w", err)
+	}
+	if req.LocationID != nil {
+		if err := location.Put(ctx, tx, tenantID, stockID, lotID, *req.LocationID, qty); err != nil {
+			return StockReceiveResult{}, err
 		}
 	}
 
-	if remaining > 0 {
-		return errors.New("not enough lot quantity to fulfill the request")
+	// Insert stock_movement
+	_, err = tx.Exec(ctx, `
+		INSERT INTO stock_movement (
+			app_id, store_id, stock_id, lot_id, balance_before, balance_after, balance_change,
+			reserve_before, reserve_after, reserve_change, cost_fifo, cost_average,
+			action, model, location_id, created_date, updated_date
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,'receive',$13,$14,NOW(),NOW())`,
+		req.AppID, req.StoreID, stockID, lotID, balance, balance+qty, qty,
+		reserve, reserve, 0, costFIFO, costAverage, req.Model, req.LocationID)
+	if err != nil {
+		return StockReceiveResult{}, fmt.Errorf("failed to insert stock_movement: %w", err)
 	}
 
 	// Commit transaction
 	if err := tx.Commit(ctx); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		return StockReceiveResult{}, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	return nil
+	return StockReceiveResult{LotID: lotID, Quantity: qty, CostFIFO: costFIFO, CostAverage: costAverage}, nil
 }
@@ -1,38 +1,390 @@
 package handlers
 
 import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"atlasq/internal/auth"
+	"atlasq/internal/database"
+	"atlasq/internal/opensearchclient"
+	"atlasq/internal/tenant"
+
 	"github.com/gofiber/fiber/v2"
-	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jackc/pgx/v4"
 )
 
 type TenantRequest struct {
-	Name string `json:"name" validate:"required,max=255"`
+	Name          string  `json:"name" validate:"required,max=100"`
+	Type          string  `json:"type"`
+	Description   *string `json:"description"`
+	CallbackURL   *string `json:"callback_url"`
+	WarehouseCode string  `json:"warehouse_code"`
+	WarehouseName string  `json:"warehouse_name"`
+	Currency      string  `json:"currency"`
+	Timezone      string  `json:"timezone"`
 }
 
-func CreateTenant(pool *pgxpool.Pool) fiber.Handler {
+// CreateTenant provisions a tenant in one transaction: the tenants row with
+// a generated key/secret, a default warehouse and default settings.
+// secret ถูกส่งกลับใน response นี้ครั้งเดียวเท่านั้น
+func CreateTenant(pool *database.LoggingPool) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		conn, err := pool.Acquire(c.Context())
-		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, "failed to acquire database connection")
-		}
-		defer conn.Release()
-
 		var req TenantRequest
 		if err := c.BodyParser(&req); err != nil {
 			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
 		}
-		if len(req.Name) == 0 || len(req.Name) > 255 {
-			return fiber.NewError(fiber.StatusBadRequest, "name is required and must be <= 255 characters")
+		if len(req.Name) == 0 || len(req.Name) > 100 {
+			return fiber.NewError(fiber.StatusBadRequest, "name is required and must be <= 100 characters")
+		}
+		req.Type = strings.ToUpper(req.Type)
+		if req.Type == "" {
+			req.Type = tenant.TypeNormal
+		}
+		if !tenant.ValidType(req.Type) {
+			return fiber.NewError(fiber.StatusBadRequest, "unknown tenant type")
+		}
+		if req.CallbackURL != nil && *req.CallbackURL != "" && !validCallbackURL(*req.CallbackURL) {
+			return fiber.NewError(fiber.StatusBadRequest, "callback_url must be an absolute http(s) url")
+		}
+		if req.WarehouseCode == "" {
+			req.WarehouseCode = "MAIN"
+		}
+		if req.WarehouseName == "" {
+			req.WarehouseName = "Main warehouse"
+		}
+		if req.Currency == "" {
+			req.Currency = "THB"
+		}
+		req.Currency = strings.ToUpper(req.Currency)
+		if len(req.Currency) != 3 {
+			return fiber.NewError(fiber.StatusBadRequest, "currency must be a 3-letter ISO code")
+		}
+		if req.Timezone == "" {
+			req.Timezone = "Asia/Bangkok"
+		}
+		if _, err := time.LoadLocation(req.Timezone); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "unknown timezone")
 		}
 
-		_, err = conn.Exec(c.Context(), `INSERT INTO tenant (name) VALUES ($1)`, req.Name)
+		key, err := auth.NewKey()
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to generate credentials")
+		}
+		secret, err := auth.NewSecret()
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to generate credentials")
+		}
+
+		ctx := c.UserContext()
+		tx, err := pool.BeginTx(ctx, pgx.TxOptions{})
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to start transaction")
+		}
+		defer tx.Rollback(ctx)
+
+		var t Tenant
+		err = scanTenant(tx.QueryRow(ctx, `
+			INSERT INTO tenants (name, type, description, key, secret, callback_url)
+			VALUES ($1,$2,$3,$4,$5,$6)
+			RETURNING `+tenantColumns,
+			req.Name, req.Type, req.Description, key, secret, req.CallbackURL,
+		), &t)
+		if isUniqueViolation(err) {
+			return fiber.NewError(fiber.StatusConflict, "tenant name already exists")
+		}
 		if err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, "failed to insert tenant")
 		}
 
+		// ตั้ง tenant ให้ tx เพื่อให้ insert ผ่าน RLS ของ warehouses/tenant_settings
+		if err := database.SetTenant(ctx, tx, t.ID); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to scope transaction")
+		}
+
+		var warehouseID int64
+		err = tx.QueryRow(ctx,
+			`INSERT INTO warehouses (tenant_id, code, name) VALUES ($1,$2,$3) RETURNING id`,
+			t.ID, req.WarehouseCode, req.WarehouseName,
+		).Scan(&warehouseID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to create default warehouse")
+		}
+
+		_, err = tx.Exec(ctx,
+			`INSERT INTO tenant_settings (tenant_id, default_warehouse_id, currency, timezone) VALUES ($1,$2,$3,$4)`,
+			t.ID, warehouseID, req.Currency, req.Timezone,
+		)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to create default settings")
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to commit transaction")
+		}
+
+		opensearchclient.LogSecurity(t.ID, "tenant_provisioned", "admin", "credentials issued")
+
 		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-			"message": "Tenant created",
-			"name":    req.Name,
+			"message": "Tenant created. Store the secret now, it will not be shown again",
+			"tenant":  t,
+			"key":     key,
+			"secret":  secret,
+			"warehouse": fiber.Map{
+				"id":   warehouseID,
+				"code": req.WarehouseCode,
+				"name": req.WarehouseName,
+			},
+			"settings": fiber.Map{
+				"default_warehouse_id": warehouseID,
+				"currency":             req.Currency,
+				"timezone":             req.Timezone,
+			},
 		})
 	}
 }
+
+type Tenant struct {
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	Description *string    `json:"description,omitempty"`
+	Key         string     `json:"key"`
+	CallbackURL *string    `json:"callback_url,omitempty"`
+	PushTotal   int64      `json:"push_total"`
+	PushFailed  int64      `json:"push_failed"`
+	UserID      *int64     `json:"user_id,omitempty"`
+	Status      int16      `json:"status"`
+	Activate    int16      `json:"activate"`
+	DeletedDate *time.Time `json:"deleted_date,omitempty"`
+	CreatedDate time.Time  `json:"created_date"`
+	UpdatedDate time.Time  `json:"updated_date"`
+}
+
+// secret ไม่เคยถูก select ออกมาใน response
+const tenantColumns = `id, name, type, description, key, callback_url, push_total, push_failed,
+	user_id, status, activate, deleted_date, created_date, updated_date`
+
+func scanTenant(row pgx.Row, t *Tenant) error {
+	return row.Scan(
+		&t.ID, &t.Name, &t.Type, &t.Description, &t.Key, &t.CallbackURL, &t.PushTotal, &t.PushFailed,
+		&t.UserID, &t.Status, &t.Activate, &t.DeletedDate, &t.CreatedDate, &t.UpdatedDate,
+	)
+}
+
+func ListTenants(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		page, limit, offset := pageParams(c)
+
+		where := []string{}
+		args := []interface{}{}
+		if c.Query("include_deleted") != "true" {
+			where = append(where, "deleted_date IS NULL")
+		}
+		if v := c.Query("status"); v != "" {
+			status, err := strconv.Atoi(v)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid status")
+			}
+			args = append(args, status)
+			where = append(where, fmt.Sprintf("status=$%d", len(args)))
+		}
+		if v := c.Query("type"); v != "" {
+			args = append(args, strings.ToUpper(v))
+			where = append(where, fmt.Sprintf("type=$%d", len(args)))
+		}
+
+		whereSQL := ""
+		if len(where) > 0 {
+			whereSQL = "WHERE " + strings.Join(where, " AND ")
+		}
+
+		var total int64
+		if err := pool.QueryRow(c.Context(), `SELECT COUNT(*) FROM tenants `+whereSQL, args...).Scan(&total); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to count tenants")
+		}
+
+		args = append(args, limit, offset)
+		rows, err := pool.Query(c.Context(), fmt.Sprintf(
+			`SELECT %s FROM tenants %s ORDER BY id LIMIT $%d OFFSET $%d`,
+			tenantColumns, whereSQL, len(args)-1, len(args),
+		), args...)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list tenants")
+		}
+		defer rows.Close()
+
+		tenants := []Tenant{}
+		for rows.Next() {
+			var t Tenant
+			if err := scanTenant(rows, &t); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to read tenant")
+			}
+			tenants = append(tenants, t)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list tenants")
+		}
+
+		return c.JSON(fiber.Map{
+			"data":  tenants,
+			"page":  page,
+			"limit": limit,
+			"total": total,
+		})
+	}
+}
+
+func GetTenant(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid tenant id")
+		}
+
+		var t Tenant
+		err = scanTenant(pool.QueryRow(c.Context(), `SELECT `+tenantColumns+` FROM tenants WHERE id=$1`, id), &t)
+		if err == pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "tenant not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch tenant")
+		}
+		return c.JSON(t)
+	}
+}
+
+type UpdateTenantRequest struct {
+	Name        *string `json:"name"`
+	Type        *string `json:"type"`
+	Description *string `json:"description"`
+	CallbackURL *string `json:"callback_url"`
+	Status      *int16  `json:"status"`
+}
+
+func UpdateTenant(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid tenant id")
+		}
+
+		var req UpdateTenantRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+
+		sets := []string{}
+		args := []interface{}{}
+		if req.Name != nil {
+			if len(*req.Name) == 0 || len(*req.Name) > 100 {
+				return fiber.NewError(fiber.StatusBadRequest, "name is required and must be <= 100 characters")
+			}
+			args = append(args, *req.Name)
+			sets = append(sets, fmt.Sprintf("name=$%d", len(args)))
+		}
+		if req.Type != nil {
+			typ := strings.ToUpper(*req.Type)
+			if !tenant.ValidType(typ) {
+				return fiber.NewError(fiber.StatusBadRequest, "unknown tenant type")
+			}
+			args = append(args, typ)
+			sets = append(sets, fmt.Sprintf("type=$%d", len(args)))
+		}
+		if req.Description != nil {
+			args = append(args, *req.Description)
+			sets = append(sets, fmt.Sprintf("description=$%d", len(args)))
+		}
+		if req.CallbackURL != nil {
+			var callbackURL *string
+			if *req.CallbackURL != "" {
+				if !validCallbackURL(*req.CallbackURL) {
+					return fiber.NewError(fiber.StatusBadRequest, "callback_url must be an absolute http(s) url")
+				}
+				callbackURL = req.CallbackURL
+			}
+			args = append(args, callbackURL)
+			sets = append(sets, fmt.Sprintf("callback_url=$%d", len(args)))
+		}
+		if req.Status != nil {
+			if *req.Status != 0 && *req.Status != 1 {
+				return fiber.NewError(fiber.StatusBadRequest, "status must be 0 or 1")
+			}
+			args = append(args, *req.Status)
+			sets = append(sets, fmt.Sprintf("status=$%d", len(args)))
+		}
+		if len(sets) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "nothing to update")
+		}
+
+		args = append(args, id)
+		return updateTenant(c, pool, strings.Join(sets, ", "), args...)
+	}
+}
+
+// DeleteTenant soft-deletes a tenant; its rows stay until purged.
+func DeleteTenant(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid tenant id")
+		}
+		return updateTenant(c, pool, "deleted_date=COALESCE(deleted_date, CURRENT_TIMESTAMP)", id)
+	}
+}
+
+func ActivateTenant(pool *database.LoggingPool) fiber.Handler {
+	return setTenantActivate(pool, 1)
+}
+
+func DeactivateTenant(pool *database.LoggingPool) fiber.Handler {
+	return setTenantActivate(pool, 0)
+}
+
+func setTenantActivate(pool *database.LoggingPool, activate int16) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid tenant id")
+		}
+		return updateTenant(c, pool, "activate=$1", activate, id)
+	}
+}
+
+// updateTenant รัน UPDATE กับ tenant ที่ยังไม่ถูกลบ แล้วตอบกลับด้วย row ล่าสุด
+// id ต้องเป็น arg ตัวสุดท้าย
+func updateTenant(c *fiber.Ctx, pool *database.LoggingPool, sets string, args ...interface{}) error {
+	var t Tenant
+	err := scanTenant(pool.QueryRow(c.Context(), fmt.Sprintf(
+		`UPDATE tenants SET %s, updated_date=CURRENT_TIMESTAMP, row_updated_date=CURRENT_TIMESTAMP
+		 WHERE id=$%d AND deleted_date IS NULL
+		 RETURNING %s`,
+		sets, len(args), tenantColumns,
+	), args...), &t)
+	if err == pgx.ErrNoRows {
+		return fiber.NewError(fiber.StatusNotFound, "tenant not found")
+	}
+	if isUniqueViolation(err) {
+		return fiber.NewError(fiber.StatusConflict, "tenant name already exists")
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to update tenant")
+	}
+	return c.JSON(t)
+}
+
+func validCallbackURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && len(raw) <= 255
+}
+
+// currentTenant returns the tenant resolved by the auth middleware.
+func currentTenant(c *fiber.Ctx) (int64, error) {
+	id, ok := tenant.IDFromContext(c.UserContext())
+	if !ok {
+		return 0, fiber.NewError(fiber.StatusUnauthorized, "tenant not authenticated")
+	}
+	return id, nil
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"atlasq/internal/database"
+	"atlasq/internal/opensearchclient"
+	tasks "atlasq/internal/tasks"
+	"atlasq/internal/tenantdata"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hibiken/asynq"
+)
+
+// ผลของ purge job เก็บไว้ใน asynq ให้ดูย้อนหลังได้
+const purgeResultRetention = 7 * 24 * time.Hour
+
+// ExportTenant streams everything the tenant owns as an NDJSON download.
+func ExportTenant(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid tenant id")
+		}
+		tenantID := int64(id)
+
+		var exists bool
+		if err := pool.QueryRow(c.Context(), `SELECT EXISTS (SELECT 1 FROM tenants WHERE id=$1)`, tenantID).Scan(&exists); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to load tenant")
+		}
+		if !exists {
+			return fiber.NewError(fiber.StatusNotFound, "tenant not found")
+		}
+
+		opensearchclient.LogSecurity(tenantID, "tenant_exported", "admin", "tenant data exported")
+
+		filename := fmt.Sprintf("tenant-%d-%s.ndjson", tenantID, time.Now().UTC().Format("20060102150405"))
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+
+		// stream writer รันหลัง handler return แล้ว จึงใช้ context ของตัวเอง
+		// header ถูกส่งไปแล้ว ถ้า export พังกลางทางทำได้แค่ log
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			if err := tenantdata.Export(context.Background(), pool, tenantID, w); err != nil {
+				log.Printf("export tenant=%d failed: %v", tenantID, err)
+			}
+		})
+		return nil
+	}
+}
+
+// PurgeTenant enqueues the hard-purge job. ?dry_run=true only counts rows.
+func PurgeTenant(pool *database.LoggingPool, client *asynq.Client) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid tenant id")
+		}
+		payload := tasks.TenantPurgePayload{
+			TenantID: int64(id),
+			DryRun:   c.QueryBool("dry_run", false),
+		}
+
+		// worker เช็คซ้ำอีกรอบใน transaction ที่ลบจริง
+		if !payload.DryRun {
+			err := tenantdata.CheckPurgeable(c.Context(), pool, payload.TenantID)
+			if err == tenantdata.ErrTenantNotFound {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			if err == tenantdata.ErrNotDeleted {
+				return fiber.NewError(fiber.StatusConflict, err.Error())
+			}
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to load tenant")
+			}
+		}
+
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to create task payload")
+		}
+
+		info, err := client.Enqueue(asynq.NewTask(tasks.TypeTenantPurge, data,
+			asynq.MaxRetry(3),
+			asynq.Timeout(30*time.Minute),
+			asynq.Retention(purgeResultRetention),
+		))
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to enqueue task")
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"message": "Purge job enqueued",
+			"job_id":  info.ID,
+			"dry_run": payload.DryRun,
+		})
+	}
+}
+
+// GetPurgeJob returns the state of a purge job and its row counts once done.
+func GetPurgeJob(inspector *asynq.Inspector) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid tenant id")
+		}
+
+		info, err := inspector.GetTaskInfo("default", c.Params("job_id"))
+		if errors.Is(err, asynq.ErrTaskNotFound) || errors.Is(err, asynq.ErrQueueNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "purge job not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to load purge job")
+		}
+
+		var p tasks.TenantPurgePayload
+		if info.Type != tasks.TypeTenantPurge || json.Unmarshal(info.Payload, &p) != nil || p.TenantID != int64(id) {
+			return fiber.NewError(fiber.StatusNotFound, "purge job not found")
+		}
+
+		var counts []tenantdata.TableCount
+		if len(info.Result) > 0 {
+			_ = json.Unmarshal(info.Result, &counts)
+		}
+
+		return c.JSON(fiber.Map{
+			"job_id":     info.ID,
+			"tenant_id":  p.TenantID,
+			"dry_run":    p.DryRun,
+			"state":      info.State.String(),
+			"last_error": info.LastErr,
+			"tables":     counts,
+		})
+	}
+}
@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"atlasq/internal/database"
+	"atlasq/internal/inventory"
+	"atlasq/internal/tenant"
+	"atlasq/internal/transfer"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+)
+
+type Transfer struct {
+	ID                     int64          `json:"id"`
+	SourceWarehouseID      int64          `json:"source_warehouse_id"`
+	DestinationWarehouseID int64          `json:"destination_warehouse_id"`
+	AppID                  int64          `json:"app_id"`
+	StoreID                int64          `json:"store_id"`
+	Status                 string         `json:"status"`
+	Note                   *string        `json:"note,omitempty"`
+	ShippedDate            time.Time      `json:"shipped_date"`
+	ReceivedDate           *time.Time     `json:"received_date,omitempty"`
+	CreatedDate            time.Time      `json:"created_date"`
+	UpdatedDate            time.Time      `json:"updated_date"`
+	Lines                  []TransferLine `json:"lines,omitempty"`
+}
+
+// TransferLine จำนวนเป็นหน่วยฐาน in_transit = quantity - received_quantity
+type TransferLine struct {
+	ID                int64         `json:"id"`
+	ProductID         int64         `json:"product_id"`
+	Quantity          float64       `json:"quantity"`
+	ReceivedQuantity  float64       `json:"received_quantity"`
+	InTransitQuantity float64       `json:"in_transit_quantity"`
+	Lots              []TransferLot `json:"lots"`
+}
+
+type TransferLot struct {
+	SourceLotID      int64   `json:"source_lot_id"`
+	DestinationLotID *int64  `json:"destination_lot_id,omitempty"`
+	Quantity         float64 `json:"quantity"`
+	ReceivedQuantity float64 `json:"received_quantity"`
+	CostFIFO         float64 `json:"cost_fifo"`
+	CostAverage      float64 `json:"cost_average"`
+}
+
+const transferColumns = `id, source_warehouse_id, destination_warehouse_id, app_id, store_id, status, note,
+	shipped_date, received_date, created_date, updated_date`
+
+func scanTransfer(row pgx.Row, t *Transfer) error {
+	return row.Scan(&t.ID, &t.SourceWarehouseID, &t.DestinationWarehouseID, &t.AppID, &t.StoreID, &t.Status, &t.Note,
+		&t.ShippedDate, &t.ReceivedDate, &t.CreatedDate, &t.UpdatedDate)
+}
+
+type TransferLineRequest struct {
+	ProductID int64   `json:"product_id"`
+	SKU       string  `json:"sku,omitempty"`
+	Quantity  float64 `json:"quantity"`
+	Unit      string  `json:"unit,omitempty"` // ว่าง = หน่วยฐาน
+}
+
+type TransferRequest struct {
+	SourceWarehouseID      int64                 `json:"source_warehouse_id"`
+	DestinationWarehouseID int64                 `json:"destination_warehouse_id"`
+	AppID                  int64                 `json:"app_id"`
+	StoreID                int64                 `json:"store_id"`
+	Note                   string                `json:"note"`
+	Lines                  []TransferLineRequest `json:"lines"`
+}
+
+// CreateTransfer ships a transfer: the lines leave the source warehouse
+// right away and stay in transit until they are received.
+func CreateTransfer(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+
+		var req TransferRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		if req.SourceWarehouseID == 0 || req.DestinationWarehouseID == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "source_warehouse_id and destination_warehouse_id are required")
+		}
+		if req.AppID == 0 || req.StoreID == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "app_id and store_id are required")
+		}
+		if len(req.Lines) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "lines is required")
+		}
+
+		ctx := c.UserContext()
+		tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to begin transaction")
+		}
+		defer func() { _ = tx.Rollback(ctx) }()
+
+		settings, err := tenant.LoadSettings(ctx, tx, tenantID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to load tenant settings")
+		}
+
+		tr := transfer.Request{
+			SourceWarehouseID:      req.SourceWarehouseID,
+			DestinationWarehouseID: req.DestinationWarehouseID,
+			AppID:                  req.AppID,
+			StoreID:                req.StoreID,
+			Note:                   req.Note,
+		}
+		for _, item := range req.Lines {
+			if item.Quantity <= 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "quantity must be > 0")
+			}
+			if err := resolveItemProduct(ctx, tx, tenantID, &item.ProductID, item.SKU); err != nil {
+				return err
+			}
+			if err := inventory.EnsureProductActive(ctx, tx, tenantID, item.ProductID); err != nil {
+				return inventoryError(err)
+			}
+			qty, err := inventory.ToBase(ctx, tx, tenantID, item.ProductID, item.Unit, item.Quantity)
+			if err != nil {
+				return inventoryError(err)
+			}
+			// bundle ไม่มี stock ของตัวเอง ย้าย component แทน
+			lines, err := inventory.Explode(ctx, tx, tenantID, item.ProductID, qty)
+			if err != nil {
+				return inventoryError(err)
+			}
+			tr.Lines = append(tr.Lines, lines...)
+		}
+
+		id, err := transfer.Ship(ctx, tx, tenantID, settings, tr)
+		if err != nil {
+			return transferError(err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to commit transaction")
+		}
+
+		t, err := loadTransfer(ctx, pool, tenantID, id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch transfer")
+		}
+		return c.Status(fiber.StatusCreated).JSON(t)
+	}
+}
+
+// ListTransfers กรองด้วย ?status= และ ?warehouse_id= (เป็นต้นทางหรือปลายทาง)
+func ListTransfers(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		page, limit, offset := pageParams(c)
+
+		args := []interface{}{tenantID}
+		where := []string{"tenant_id=$1"}
+		if v := c.Query("status"); v != "" {
+			args = append(args, v)
+			where = append(where, fmt.Sprintf("status=$%d", len(args)))
+		}
+		if v := c.Query("warehouse_id"); v != "" {
+			warehouseID, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid warehouse_id")
+			}
+			args = append(args, warehouseID)
+			where = append(where, fmt.Sprintf("(source_warehouse_id=$%d OR destination_warehouse_id=$%d)", len(args), len(args)))
+		}
+		whereSQL := strings.Join(where, " AND ")
+
+		var total int64
+		if err := pool.QueryRow(c.UserContext(), `SELECT COUNT(*) FROM stock_transfers WHERE `+whereSQL, args...).Scan(&total); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to count transfers")
+		}
+
+		args = append(args, limit, offset)
+		rows, err := pool.Query(c.UserContext(), fmt.Sprintf(
+			`SELECT %s FROM stock_transfers WHERE %s ORDER BY id DESC LIMIT $%d OFFSET $%d`,
+			transferColumns, whereSQL, len(args)-1, len(args),
+		), args...)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list transfers")
+		}
+		defer rows.Close()
+
+		transfers := []Transfer{}
+		for rows.Next() {
+			var t Transfer
+			if err := scanTransfer(rows, &t); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to read transfer")
+			}
+			transfers = append(transfers, t)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list transfers")
+		}
+
+		return c.JSON(fiber.Map{
+			"data":  transfers,
+			"page":  page,
+			"limit": limit,
+			"total": total,
+		})
+	}
+}
+
+func GetTransfer(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid transfer id")
+		}
+
+		t, err := loadTransfer(c.UserContext(), pool, tenantID, int64(id))
+		if errors.Is(err, transfer.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "transfer not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch transfer")
+		}
+		return c.JSON(t)
+	}
+}
+
+type ReceiveTransferRequest struct {
+	Lines []transfer.Receipt `json:"lines"`
+}
+
+// ReceiveTransfer รับของเข้า warehouse ปลายทาง lines ว่าง = รับที่เหลือทั้งหมด
+// quantity เป็นหน่วยฐาน รับบางส่วนได้หลายครั้ง
+func ReceiveTransfer(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid transfer id")
+		}
+
+		var req ReceiveTransferRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+			}
+		}
+
+		ctx := c.UserContext()
+		tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to begin transaction")
+		}
+		defer func() { _ = tx.Rollback(ctx) }()
+
+		settings, err := tenant.LoadSettings(ctx, tx, tenantID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to load tenant settings")
+		}
+		if err := transfer.Receive(ctx, tx, tenantID, settings, int64(id), req.Lines); err != nil {
+			return transferError(err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to commit transaction")
+		}
+
+		t, err := loadTransfer(ctx, pool, tenantID, int64(id))
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch transfer")
+		}
+		return c.JSON(t)
+	}
+}
+
+// transferError แปลง error จาก package transfer เป็น response
+func transferError(err error) error {
+	if errors.Is(err, transfer.ErrNotFound) {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+	if errors.Is(err, transfer.ErrAlreadyReceived) {
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	}
+	if transfer.IsInvalid(err) {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return fiber.NewError(fiber.StatusInternalServerError, "failed to process transfer")
+}
+
+// loadTransfer คืน transfer พร้อม lines และ lot ที่อยู่ระหว่างทาง
+func loadTransfer(ctx context.Context, q inventory.Queryer, tenantID, id int64) (Transfer, error) {
+	var t Transfer
+	err := scanTransfer(q.QueryRow(ctx,
+		`SELECT `+transferColumns+` FROM stock_transfers WHERE id=$1 AND tenant_id=$2`, id, tenantID), &t)
+	if err == pgx.ErrNoRows {
+		return Transfer{}, transfer.ErrNotFound
+	}
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	rows, err := q.Query(ctx,
+		`SELECT id, product_id, quantity, received_quantity FROM stock_transfer_lines
+		 WHERE transfer_id=$1 AND tenant_id=$2 ORDER BY id`,
+		id, tenantID,
+	)
+	if err != nil {
+		return Transfer{}, err
+	}
+	index := map[int64]int{}
+	for rows.Next() {
+		var l TransferLine
+		if err := rows.Scan(&l.ID, &l.ProductID, &l.Quantity, &l.ReceivedQuantity); err != nil {
+			rows.Close()
+			return Transfer{}, err
+		}
+		l.InTransitQuantity = l.Quantity - l.ReceivedQuantity
+		l.Lots = []TransferLot{}
+		index[l.ID] = len(t.Lines)
+		t.Lines = append(t.Lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Transfer{}, err
+	}
+
+	rows, err = q.Query(ctx,
+		`SELECT tl.transfer_line_id, tl.source_lot_id, tl.destination_lot_id, tl.quantity, tl.received_quantity,
+		        tl.cost_fifo, tl.cost_average
+		 FROM stock_transfer_lots tl
+		 JOIN stock_transfer_lines l ON l.id = tl.transfer_line_id
+		 WHERE l.transfer_id=$1 AND tl.tenant_id=$2 ORDER BY tl.id`,
+		id, tenantID,
+	)
+	if err != nil {
+		return Transfer{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var lineID int64
+		var lot TransferLot
+		if err := rows.Scan(&lineID, &lot.SourceLotID, &lot.DestinationLotID, &lot.Quantity, &lot.ReceivedQuantity,
+			&lot.CostFIFO, &lot.CostAverage); err != nil {
+			return Transfer{}, err
+		}
+		if i, ok := index[lineID]; ok {
+			t.Lines[i].Lots = append(t.Lines[i].Lots, lot)
+		}
+	}
+	return t, rows.Err()
+}
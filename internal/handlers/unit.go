@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"atlasq/internal/database"
+	"atlasq/internal/inventory"
+	"atlasq/internal/tenant"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+)
+
+type ProductUnit struct {
+	ID          int64     `json:"id"`
+	ProductID   int64     `json:"product_id"`
+	Code        string    `json:"code"`
+	Name        string    `json:"name"`
+	Factor      float64   `json:"factor"`
+	UpdatedDate time.Time `json:"updated_date"`
+}
+
+type ProductUnitRequest struct {
+	Name   string  `json:"name"`
+	Factor float64 `json:"factor"`
+}
+
+const productUnitColumns = `id, product_id, code, name, factor, updated_date`
+
+func scanProductUnit(row pgx.Row, u *ProductUnit) error {
+	return row.Scan(&u.ID, &u.ProductID, &u.Code, &u.Name, &u.Factor, &u.UpdatedDate)
+}
+
+func ListProductUnits(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid product id")
+		}
+
+		rows, err := pool.Query(c.UserContext(),
+			`SELECT `+productUnitColumns+` FROM product_units WHERE tenant_id=$1 AND product_id=$2 ORDER BY factor, code`,
+			tenantID, id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list units")
+		}
+		defer rows.Close()
+
+		units := []ProductUnit{}
+		for rows.Next() {
+			var u ProductUnit
+			if err := scanProductUnit(rows, &u); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to read unit")
+			}
+			units = append(units, u)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list units")
+		}
+
+		return c.JSON(fiber.Map{"data": units})
+	}
+}
+
+// PutProductUnit creates or updates the unit :code, e.g. PUT .../units/CASE {"factor": 12}.
+func PutProductUnit(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid product id")
+		}
+		code := strings.ToUpper(strings.TrimSpace(c.Params("code")))
+		if code == "" || len(code) > 20 {
+			return fiber.NewError(fiber.StatusBadRequest, "unit code is required and must be <= 20 characters")
+		}
+
+		var req ProductUnitRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		if req.Factor <= 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "factor must be > 0")
+		}
+		if len(req.Name) > 100 {
+			return fiber.NewError(fiber.StatusBadRequest, "name must be <= 100 characters")
+		}
+
+		var u ProductUnit
+		err = scanProductUnit(pool.QueryRow(c.UserContext(),
+			`INSERT INTO product_units (tenant_id, product_id, code, name, factor)
+			 SELECT $1, id, $3, $4, $5 FROM product WHERE id=$2 AND tenant_id=$1
+			 ON CONFLICT (product_id, code) DO UPDATE
+			 SET name=EXCLUDED.name, factor=EXCLUDED.factor,
+			     updated_date=CURRENT_TIMESTAMP, row_updated_date=CURRENT_TIMESTAMP
+			 RETURNING `+productUnitColumns,
+			tenantID, id, code, req.Name, req.Factor), &u)
+		if err == pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "product not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to save unit")
+		}
+		return c.JSON(u)
+	}
+}
+
+func DeleteProductUnit(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid product id")
+		}
+
+		tag, err := pool.Exec(c.UserContext(),
+			`DELETE FROM product_units WHERE tenant_id=$1 AND product_id=$2 AND code=$3`,
+			tenantID, id, strings.ToUpper(c.Params("code")))
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to delete unit")
+		}
+		if tag.RowsAffected() == 0 {
+			return fiber.NewError(fiber.StatusNotFound, "unit not found")
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// toBaseQuantity แปลง quantity ของ order item เป็นหน่วยฐาน แล้วล้าง unit
+func toBaseQuantity(ctx context.Context, q tenant.Querier, tenantID, productID int64, unit *string, quantity *int64) error {
+	base, err := inventory.ToBaseWhole(ctx, q, tenantID, productID, *unit, *quantity)
+	if err != nil {
+		return inventoryError(err)
+	}
+	*quantity = base
+	*unit = ""
+	return nil
+}
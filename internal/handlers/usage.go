@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"atlasq/internal/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type UsageRow struct {
+	TenantID       int64  `json:"tenant_id"`
+	TenantName     string `json:"tenant_name"`
+	YearMonth      string `json:"year_month"`
+	APICalls       int64  `json:"api_calls"`
+	OrdersEnqueued int64  `json:"orders_enqueued"`
+	StockMovements int64  `json:"stock_movements"`
+	WebhookPushes  int64  `json:"webhook_pushes"`
+}
+
+// GetUsageReport คืน usage รายเดือนของแต่ละ tenant เป็น JSON หรือ CSV (?format=csv)
+// ข้อมูลมาจาก rollup ของ worker จึงอาจช้ากว่าเวลาจริงไม่เกินรอบ rollup
+func GetUsageReport(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		where := []string{}
+		args := []interface{}{}
+		if v := c.Query("month"); v != "" {
+			month, err := time.Parse("2006-01", v)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "month must be YYYY-MM")
+			}
+			args = append(args, month)
+			where = append(where, fmt.Sprintf("u.year_month=$%d", len(args)))
+		}
+		if v := c.Query("tenant_id"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid tenant_id")
+			}
+			args = append(args, id)
+			where = append(where, fmt.Sprintf("u.tenant_id=$%d", len(args)))
+		}
+		whereSQL := ""
+		if len(where) > 0 {
+			whereSQL = "WHERE " + strings.Join(where, " AND ")
+		}
+
+		rows, err := pool.Query(c.UserContext(), `
+			SELECT u.tenant_id, t.name, u.year_month, u.api_calls, u.orders_enqueued, u.stock_movements, u.webhook_pushes
+			FROM tenant_usage_monthly u
+			JOIN tenants t ON t.id = u.tenant_id
+			`+whereSQL+`
+			ORDER BY u.year_month DESC, u.tenant_id`, args...)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to load usage")
+		}
+		defer rows.Close()
+
+		report := []UsageRow{}
+		for rows.Next() {
+			var r UsageRow
+			var month time.Time
+			if err := rows.Scan(&r.TenantID, &r.TenantName, &month, &r.APICalls, &r.OrdersEnqueued, &r.StockMovements, &r.WebhookPushes); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to read usage")
+			}
+			r.YearMonth = month.Format("2006-01")
+			report = append(report, r)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to load usage")
+		}
+
+		if c.Query("format") != "csv" {
+			return c.JSON(fiber.Map{"data": report})
+		}
+
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		_ = w.Write([]string{"tenant_id", "tenant_name", "year_month", "api_calls", "orders_enqueued", "stock_movements", "webhook_pushes"})
+		for _, r := range report {
+			_ = w.Write([]string{
+				strconv.FormatInt(r.TenantID, 10), r.TenantName, r.YearMonth,
+				strconv.FormatInt(r.APICalls, 10), strconv.FormatInt(r.OrdersEnqueued, 10),
+				strconv.FormatInt(r.StockMovements, 10), strconv.FormatInt(r.WebhookPushes, 10),
+			})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to write csv")
+		}
+
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="usage.csv"`)
+		return c.Send(buf.Bytes())
+	}
+}
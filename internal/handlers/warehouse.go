@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"atlasq/internal/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+)
+
+type Warehouse struct {
+	ID          int64      `json:"id"`
+	Code        string     `json:"code"`
+	Name        string     `json:"name"`
+	Status      int16      `json:"status"`
+	Priority    int        `json:"priority"`
+	DeletedDate *time.Time `json:"deleted_date,omitempty"`
+	CreatedDate time.Time  `json:"created_date"`
+	UpdatedDate time.Time  `json:"updated_date"`
+}
+
+const warehouseColumns = `id, code, name, status, priority, deleted_date, created_date, updated_date`
+
+func scanWarehouse(row pgx.Row, w *Warehouse) error {
+	return row.Scan(&w.ID, &w.Code, &w.Name, &w.Status, &w.Priority, &w.DeletedDate, &w.CreatedDate, &w.UpdatedDate)
+}
+
+type WarehouseRequest struct {
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	Priority int    `json:"priority"` // น้อย = ถูก allocate ก่อน
+}
+
+func validWarehouseCode(code string) bool {
+	return code != "" && len(code) <= 50
+}
+
+func CreateWarehouse(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+
+		var req WarehouseRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		req.Code = strings.ToUpper(strings.TrimSpace(req.Code))
+		if !validWarehouseCode(req.Code) {
+			return fiber.NewError(fiber.StatusBadRequest, "code is required and must be <= 50 characters")
+		}
+		if len(req.Name) == 0 || len(req.Name) > 255 {
+			return fiber.NewError(fiber.StatusBadRequest, "name is required and must be <= 255 characters")
+		}
+
+		var w Warehouse
+		err = scanWarehouse(pool.QueryRow(c.UserContext(),
+			`INSERT INTO warehouses (tenant_id, code, name, priority) VALUES ($1,$2,$3,$4) RETURNING `+warehouseColumns,
+			tenantID, req.Code, req.Name, req.Priority), &w)
+		if isUniqueViolation(err) {
+			return fiber.NewError(fiber.StatusConflict, "warehouse code already exists")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to create warehouse")
+		}
+		return c.Status(fiber.StatusCreated).JSON(w)
+	}
+}
+
+func ListWarehouses(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		page, limit, offset := pageParams(c)
+
+		args := []interface{}{tenantID}
+		where := []string{"tenant_id=$1"}
+		if c.Query("include_deleted") != "true" {
+			where = append(where, "deleted_date IS NULL")
+		}
+		if v := c.Query("status"); v != "" {
+			status, err := strconv.Atoi(v)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid status")
+			}
+			args = append(args, status)
+			where = append(where, fmt.Sprintf("status=$%d", len(args)))
+		}
+		whereSQL := strings.Join(where, " AND ")
+
+		var total int64
+		if err := pool.QueryRow(c.UserContext(), `SELECT COUNT(*) FROM warehouses WHERE `+whereSQL, args...).Scan(&total); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to count warehouses")
+		}
+
+		args = append(args, limit, offset)
+		rows, err := pool.Query(c.UserContext(), fmt.Sprintf(
+			`SELECT %s FROM warehouses WHERE %s ORDER BY priority, id LIMIT $%d OFFSET $%d`,
+			warehouseColumns, whereSQL, len(args)-1, len(args),
+		), args...)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list warehouses")
+		}
+		defer rows.Close()
+
+		warehouses := []Warehouse{}
+		for rows.Next() {
+			var w Warehouse
+			if err := scanWarehouse(rows, &w); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to read warehouse")
+			}
+			warehouses = append(warehouses, w)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list warehouses")
+		}
+
+		return c.JSON(fiber.Map{
+			"data":  warehouses,
+			"page":  page,
+			"limit": limit,
+			"total": total,
+		})
+	}
+}
+
+func GetWarehouse(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid warehouse id")
+		}
+
+		var w Warehouse
+		err = scanWarehouse(pool.QueryRow(c.UserContext(),
+			`SELECT `+warehouseColumns+` FROM warehouses WHERE id=$1 AND tenant_id=$2`, id, tenantID), &w)
+		if err == pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "warehouse not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to fetch warehouse")
+		}
+		return c.JSON(w)
+	}
+}
+
+type UpdateWarehouseRequest struct {
+	Code     *string `json:"code"`
+	Name     *string `json:"name"`
+	Status   *int16  `json:"status"`
+	Priority *int    `json:"priority"`
+}
+
+// UpdateWarehouse แก้ code/name และเปิดปิดด้วย status (1 active, 0 inactive)
+// warehouse ที่ inactive จะถูกปฏิเสธในทุก path ที่ขยับ stock
+func UpdateWarehouse(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid warehouse id")
+		}
+
+		var req UpdateWarehouseRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+
+		sets := []string{}
+		args := []interface{}{}
+		if req.Code != nil {
+			code := strings.ToUpper(strings.TrimSpace(*req.Code))
+			if !validWarehouseCode(code) {
+				return fiber.NewError(fiber.StatusBadRequest, "code is required and must be <= 50 characters")
+			}
+			args = append(args, code)
+			sets = append(sets, fmt.Sprintf("code=$%d", len(args)))
+		}
+		if req.Name != nil {
+			if len(*req.Name) == 0 || len(*req.Name) > 255 {
+				return fiber.NewError(fiber.StatusBadRequest, "name is required and must be <= 255 characters")
+			}
+			args = append(args, *req.Name)
+			sets = append(sets, fmt.Sprintf("name=$%d", len(args)))
+		}
+		if req.Status != nil {
+			if *req.Status != 0 && *req.Status != 1 {
+				return fiber.NewError(fiber.StatusBadRequest, "status must be 0 or 1")
+			}
+			args = append(args, *req.Status)
+			sets = append(sets, fmt.Sprintf("status=$%d", len(args)))
+		}
+		if req.Priority != nil {
+			args = append(args, *req.Priority)
+			sets = append(sets, fmt.Sprintf("priority=$%d", len(args)))
+		}
+		if len(sets) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "nothing to update")
+		}
+
+		args = append(args, id)
+		return updateWarehouse(c, pool, strings.Join(sets, ", "), args...)
+	}
+}
+
+// DeleteWarehouse soft-deletes a warehouse. The tenant's default warehouse
+// cannot be deleted until another default is set.
+func DeleteWarehouse(pool *database.LoggingPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := currentTenant(c)
+		if err != nil {
+			return err
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid warehouse id")
+		}
+
+		var isDefault bool
+		if err := pool.QueryRow(c.UserContext(),
+			`SELECT EXISTS (SELECT 1 FROM tenant_settings WHERE tenant_id=$1 AND default_warehouse_id=$2)`,
+			tenantID, id,
+		).Scan(&isDefault); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to check warehouse")
+		}
+		if isDefault {
+			return fiber.NewError(fiber.StatusConflict, "warehouse is the tenant default, change default_warehouse_id first")
+		}
+
+		return updateWarehouse(c, pool, "deleted_date=COALESCE(deleted_date, CURRENT_TIMESTAMP)", id)
+	}
+}
+
+// updateWarehouse รัน UPDATE กับ warehouse ที่ยังไม่ถูกลบของ tenant ปัจจุบัน
+// id ต้องเป็น arg ตัวสุดท้าย
+func updateWarehouse(c *fiber.Ctx, pool *database.LoggingPool, sets string, args ...interface{}) error {
+	tenantID, err := currentTenant(c)
+	if err != nil {
+		return err
+	}
+
+	args = append(args, tenantID)
+	var w Warehouse
+	err = scanWarehouse(pool.QueryRow(c.UserContext(), fmt.Sprintf(
+		`UPDATE warehouses SET %s, updated_date=CURRENT_TIMESTAMP, row_updated_date=CURRENT_TIMESTAMP
+		 WHERE id=$%d AND tenant_id=$%d AND deleted_date IS NULL
+		 RETURNING %s`,
+		sets, len(args)-1, len(args), warehouseColumns,
+	), args...), &w)
+	if err == pgx.ErrNoRows {
+		return fiber.NewError(fiber.StatusNotFound, "warehouse not found")
+	}
+	if isUniqueViolation(err) {
+		return fiber.NewError(fiber.StatusConflict, "warehouse code already exists")
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to update warehouse")
+	}
+	return c.JSON(w)
+}
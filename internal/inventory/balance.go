@@ -0,0 +1,57 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// AddBalance changes a stock row and its stock_balance rows together.
+// balanceChange also moves quantity and on_hand, which the stock list and
+// the transaction log still read; every path that changes stock, the order
+// queue and /orders-old included (see stockissue), goes through here.
+// The current month's stock_balance row is created from the new stock
+// values when it does not exist yet; later months are shifted by the same
+// change. Callers hold the stock row lock.
+func AddBalance(ctx context.Context, tx pgx.Tx, stockID int64, balanceChange, reserveChange float64) error {
+	var balance, reserve float64
+	err := tx.QueryRow(ctx,
+		`UPDATE stock
+		 SET balance = balance + $1, reserve = reserve + $2,
+		     quantity = quantity + $1, on_hand = on_hand + $1,
+		     update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP
+		 WHERE id=$3
+		 RETURNING balance, reserve`,
+		balanceChange, reserveChange, stockID,
+	).Scan(&balance, &reserve)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("stock_id=%d: %w", stockID, ErrStockNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update stock: %w", err)
+	}
+
+	now := time.Now()
+	yearMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO stock_balance (stock_id, balance, reserve, year_month) VALUES ($1,$2,$3,$4)
+		 ON CONFLICT (stock_id, year_month)
+		 DO UPDATE SET balance = stock_balance.balance + $5, reserve = stock_balance.reserve + $6`,
+		stockID, balance, reserve, yearMonth, balanceChange, reserveChange,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update stock_balance: %w", err)
+	}
+	if tag.RowsAffected() != 1 {
+		return fmt.Errorf("stock_id=%d: stock_balance for %s not written", stockID, yearMonth.Format("2006-01"))
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE stock_balance SET balance = balance + $1, reserve = reserve + $2 WHERE stock_id=$3 AND year_month > $4`,
+		balanceChange, reserveChange, stockID, yearMonth,
+	); err != nil {
+		return fmt.Errorf("failed to update stock_balance: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"atlasq/internal/tenant"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ErrBundleNotStocked is returned when stock is received for a bundle;
+// only its components carry stock.
+var ErrBundleNotStocked = errors.New("bundle has no stock of its own, use its components")
+
+// Queryer is a tenant.Querier that can also return rows (pgx.Tx, LoggingPool).
+type Queryer interface {
+	tenant.Querier
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// Line is one product to deduct after bundles are exploded. BundleProductID
+// is set when the line is a component of an ordered bundle.
+type Line struct {
+	ProductID       int64
+	Quantity        float64
+	BundleProductID *int64
+}
+
+// Component is one product in a bundle, Quantity per one bundle.
+type Component struct {
+	ProductID int64   `json:"product_id"`
+	Quantity  float64 `json:"quantity"`
+}
+
+// Components returns the bundle's components, or nil when the product is
+// not a bundle.
+func Components(ctx context.Context, q Queryer, tenantID, productID int64) ([]Component, error) {
+	rows, err := q.Query(ctx,
+		`SELECT component_product_id, quantity FROM product_bundle_components
+		 WHERE tenant_id=$1 AND bundle_product_id=$2 ORDER BY component_product_id`,
+		tenantID, productID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var components []Component
+	for rows.Next() {
+		var c Component
+		if err := rows.Scan(&c.ProductID, &c.Quantity); err != nil {
+			return nil, err
+		}
+		components = append(components, c)
+	}
+	return components, rows.Err()
+}
+
+// Explode แตก bundle เป็น component ที่ต้องตัด stock จริง
+// product ที่ไม่ใช่ bundle คืนเป็น line เดียวของตัวเอง
+// component ที่ถูก archive จะทำให้ bundle ตัดไม่ได้
+func Explode(ctx context.Context, q Queryer, tenantID, productID int64, quantity float64) ([]Line, error) {
+	components, err := Components(ctx, q, tenantID, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bundle components: %w", err)
+	}
+	if len(components) == 0 {
+		return []Line{{ProductID: productID, Quantity: quantity}}, nil
+	}
+
+	bundleID := productID
+	lines := make([]Line, 0, len(components))
+	for _, c := range components {
+		if err := EnsureProductActive(ctx, q, tenantID, c.ProductID); err != nil {
+			return nil, err
+		}
+		lines = append(lines, Line{
+			ProductID:       c.ProductID,
+			Quantity:        c.Quantity * quantity,
+			BundleProductID: &bundleID,
+		})
+	}
+	return lines, nil
+}
@@ -0,0 +1,25 @@
+// Package inventory holds the checks shared by the API handlers and the
+// worker before stock is moved: products, bundles, units and warehouses.
+package inventory
+
+import "errors"
+
+var (
+	// ErrInsufficientStock is returned when a warehouse does not hold enough
+	// stock (or lots) for a move that cannot be backordered.
+	ErrInsufficientStock = errors.New("insufficient stock")
+	// ErrStockNotFound is returned when a product has no stock row in the
+	// warehouse and the tenant does not auto-create stock.
+	ErrStockNotFound = errors.New("stock not found")
+)
+
+// IsInvalid reports whether err is one of the validation errors in this
+// package, i.e. a client error that retrying will not fix.
+func IsInvalid(err error) bool {
+	return errors.Is(err, ErrProductNotFound) || errors.Is(err, ErrProductArchived) ||
+		errors.Is(err, ErrUnitNotFound) || errors.Is(err, ErrFractionalQty) ||
+		errors.Is(err, ErrWarehouseNotFound) || errors.Is(err, ErrWarehouseInactive) ||
+		errors.Is(err, ErrWarehouseRequired) ||
+		errors.Is(err, ErrInsufficientStock) || errors.Is(err, ErrStockNotFound) ||
+		errors.Is(err, ErrBundleNotStocked)
+}
@@ -0,0 +1,84 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"atlasq/internal/tenant"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	ErrProductNotFound = errors.New("product not found")
+	ErrProductArchived = errors.New("product is archived")
+)
+
+// EnsureProductActive returns ErrProductNotFound or ErrProductArchived
+// (wrapped with the product id) when the product cannot be ordered or issued.
+func EnsureProductActive(ctx context.Context, q tenant.Querier, tenantID, productID int64) error {
+	var archived bool
+	err := q.QueryRow(ctx,
+		`SELECT archived_date IS NOT NULL FROM product WHERE id=$1 AND tenant_id=$2`,
+		productID, tenantID,
+	).Scan(&archived)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("product_id=%d: %w", productID, ErrProductNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	if archived {
+		return fmt.Errorf("product_id=%d: %w", productID, ErrProductArchived)
+	}
+	return nil
+}
+
+// ResolveCode หา product จาก SKU ก่อน ถ้าไม่เจอค่อยหาจาก barcode
+// matchedBy เป็น "sku" หรือ "barcode"
+func ResolveCode(ctx context.Context, q tenant.Querier, tenantID int64, code string) (productID int64, matchedBy string, err error) {
+	id, err := ResolveSKU(ctx, q, tenantID, code)
+	if err == nil {
+		return id, "sku", nil
+	}
+	if !errors.Is(err, ErrProductNotFound) {
+		return 0, "", err
+	}
+
+	err = q.QueryRow(ctx,
+		`SELECT product_id FROM product_barcodes WHERE tenant_id=$1 AND barcode=$2`,
+		tenantID, code,
+	).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return 0, "", fmt.Errorf("code=%q: %w", code, ErrProductNotFound)
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	return id, "barcode", nil
+}
+
+// ResolveSKU returns the id of the tenant's product with the given SKU.
+func ResolveSKU(ctx context.Context, q tenant.Querier, tenantID int64, sku string) (int64, error) {
+	var id int64
+	err := q.QueryRow(ctx, `SELECT id FROM product WHERE tenant_id=$1 AND sku=$2`, tenantID, sku).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return 0, fmt.Errorf("sku=%q: %w", sku, ErrProductNotFound)
+	}
+	return id, err
+}
+
+// ValidateProduct ใช้กฎเดียวกันทั้ง POST /products และ bulk import
+func ValidateProduct(name, sku string, price float64) error {
+	if len(name) == 0 || len(name) > 255 {
+		return errors.New("name is required and must be <= 255 characters")
+	}
+	if price <= 0 {
+		return errors.New("price must be > 0")
+	}
+	if len(sku) > 100 {
+		return errors.New("sku must be <= 100 characters")
+	}
+	return nil
+}
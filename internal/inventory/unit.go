@@ -0,0 +1,54 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"atlasq/internal/tenant"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	ErrUnitNotFound  = errors.New("unit not defined for product")
+	ErrFractionalQty = errors.New("quantity does not convert to a whole number of base units")
+)
+
+// ToBase แปลง quantity ในหน่วย unit เป็นหน่วยฐานของ product
+// unit ว่างคือหน่วยฐานอยู่แล้ว
+func ToBase(ctx context.Context, q tenant.Querier, tenantID, productID int64, unit string, quantity float64) (float64, error) {
+	unit = strings.ToUpper(strings.TrimSpace(unit))
+	if unit == "" {
+		return quantity, nil
+	}
+
+	var factor float64
+	err := q.QueryRow(ctx,
+		`SELECT factor FROM product_units WHERE tenant_id=$1 AND product_id=$2 AND code=$3`,
+		tenantID, productID, unit,
+	).Scan(&factor)
+	if err == pgx.ErrNoRows {
+		return 0, fmt.Errorf("product_id=%d unit=%s: %w", productID, unit, ErrUnitNotFound)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return quantity * factor, nil
+}
+
+// ToBaseWhole is ToBase for integer order quantities; it refuses
+// conversions that leave a fraction of a base unit.
+func ToBaseWhole(ctx context.Context, q tenant.Querier, tenantID, productID int64, unit string, quantity int64) (int64, error) {
+	base, err := ToBase(ctx, q, tenantID, productID, unit, float64(quantity))
+	if err != nil {
+		return 0, err
+	}
+	whole := math.Round(base)
+	if math.Abs(base-whole) > 1e-9 {
+		return 0, fmt.Errorf("product_id=%d unit=%s: %w", productID, unit, ErrFractionalQty)
+	}
+	return int64(whole), nil
+}
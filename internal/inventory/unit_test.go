@@ -0,0 +1,97 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// unitQuerier ตอบ factor จาก map ตาม code (arg ตัวที่ 3) แทน product_units
+type unitQuerier struct {
+	factors map[string]float64
+	err     error
+}
+
+func (q unitQuerier) QueryRow(_ context.Context, _ string, args ...interface{}) pgx.Row {
+	return unitRow{q: q, code: args[2].(string)}
+}
+
+type unitRow struct {
+	q    unitQuerier
+	code string
+}
+
+func (r unitRow) Scan(dest ...interface{}) error {
+	if r.q.err != nil {
+		return r.q.err
+	}
+	factor, ok := r.q.factors[r.code]
+	if !ok {
+		return pgx.ErrNoRows
+	}
+	*dest[0].(*float64) = factor
+	return nil
+}
+
+var testUnits = unitQuerier{factors: map[string]float64{"BOX": 12, "PACK": 0.5, "DL": 0.1}}
+
+func TestToBase(t *testing.T) {
+	dbErr := errors.New("connection reset")
+	tests := []struct {
+		name     string
+		q        unitQuerier
+		unit     string
+		quantity float64
+		want     float64
+		wantErr  error
+	}{
+		{name: "empty unit is base", q: testUnits, unit: "", quantity: 7, want: 7},
+		{name: "blank unit is base", q: testUnits, unit: "  ", quantity: 7, want: 7},
+		{name: "factor applied", q: testUnits, unit: "BOX", quantity: 3, want: 36},
+		{name: "code normalized", q: testUnits, unit: " box ", quantity: 2, want: 24},
+		{name: "fractional factor", q: testUnits, unit: "PACK", quantity: 3, want: 1.5},
+		{name: "unknown unit", q: testUnits, unit: "PALLET", quantity: 1, wantErr: ErrUnitNotFound},
+		{name: "db error passed through", q: unitQuerier{err: dbErr}, unit: "BOX", quantity: 1, wantErr: dbErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToBase(context.Background(), tt.q, 1, 10, tt.unit, tt.quantity)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("ToBase = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToBaseWhole(t *testing.T) {
+	tests := []struct {
+		name     string
+		unit     string
+		quantity int64
+		want     int64
+		wantErr  error
+	}{
+		{name: "base unit", unit: "", quantity: 5, want: 5},
+		{name: "whole factor", unit: "BOX", quantity: 2, want: 24},
+		{name: "fraction cancels out", unit: "PACK", quantity: 4, want: 2},
+		{name: "float error rounded", unit: "DL", quantity: 30, want: 3},
+		{name: "leftover fraction", unit: "PACK", quantity: 3, wantErr: ErrFractionalQty},
+		{name: "unknown unit", unit: "PALLET", quantity: 1, wantErr: ErrUnitNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToBaseWhole(context.Background(), testUnits, 1, 10, tt.unit, tt.quantity)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("ToBaseWhole = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"atlasq/internal/tenant"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	ErrWarehouseNotFound = errors.New("warehouse not found")
+	ErrWarehouseInactive = errors.New("warehouse is inactive")
+	// ErrWarehouseRequired: ไม่ส่ง warehouse_id และ tenant ไม่มี default warehouse
+	ErrWarehouseRequired = errors.New("warehouse_id is required")
+)
+
+// EnsureWarehouseActive returns ErrWarehouseNotFound (also for deleted
+// warehouses) or ErrWarehouseInactive when stock cannot move in it.
+func EnsureWarehouseActive(ctx context.Context, q tenant.Querier, tenantID, warehouseID int64) error {
+	var status int16
+	err := q.QueryRow(ctx,
+		`SELECT status FROM warehouses WHERE id=$1 AND tenant_id=$2 AND deleted_date IS NULL`,
+		warehouseID, tenantID,
+	).Scan(&status)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("warehouse_id=%d: %w", warehouseID, ErrWarehouseNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	if status != 1 {
+		return fmt.Errorf("warehouse_id=%d: %w", warehouseID, ErrWarehouseInactive)
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+// Package location keeps stock per bin inside a warehouse. A lot's
+// balance is split over lot_locations; what is not in any bin is the
+// unassigned part of the lot.
+package location
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"atlasq/internal/inventory"
+	"atlasq/internal/tenant"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Location types, from the top of the hierarchy down.
+const (
+	TypeZone  = "zone"
+	TypeAisle = "aisle"
+	TypeBin   = "bin"
+)
+
+var (
+	ErrNotFound        = errors.New("location not found")
+	ErrInvalidType     = errors.New("type must be zone, aisle or bin")
+	ErrInvalidParent   = errors.New("invalid parent location")
+	ErrNotBin          = errors.New("stock can only be kept in a bin")
+	ErrInactive        = errors.New("location is inactive")
+	ErrSameLocation    = errors.New("from and to location must differ")
+	ErrInvalidQuantity = errors.New("quantity must be > 0")
+)
+
+// IsInvalid reports whether err is a client error from this package.
+func IsInvalid(err error) bool {
+	return inventory.IsInvalid(err) || errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidType) ||
+		errors.Is(err, ErrInvalidParent) || errors.Is(err, ErrNotBin) || errors.Is(err, ErrInactive) ||
+		errors.Is(err, ErrSameLocation) || errors.Is(err, ErrInvalidQuantity)
+}
+
+// ParentType returns the type a location of type t must be placed under,
+// "" for a zone (top level).
+func ParentType(t string) (string, error) {
+	switch t {
+	case TypeZone:
+		return "", nil
+	case TypeAisle:
+		return TypeZone, nil
+	case TypeBin:
+		return TypeAisle, nil
+	}
+	return "", ErrInvalidType
+}
+
+// Pick is part of a lot taken from one bin; LocationID nil is the
+// unassigned part of the lot.
+type Pick struct {
+	LocationID *int64
+	Quantity   float64
+}
+
+// Take removes quantity of a lot from its bins and returns where it came
+// from. Bins are picked by pick_sequence, code, id and the unassigned part
+// last, so the same stock always gives the same picks. lotBalance is the
+// lot's balance before the caller deducts quantity from it.
+func Take(ctx context.Context, tx pgx.Tx, tenantID, lotID int64, lotBalance, quantity float64) ([]Pick, error) {
+	type bin struct {
+		LocationID int64
+		Balance    float64
+	}
+	bins := []bin{}
+	rows, err := tx.Query(ctx,
+		`SELECT ll.location_id, ll.balance
+		 FROM lot_locations ll
+		 JOIN warehouse_locations wl ON wl.id = ll.location_id
+		 WHERE ll.tenant_id=$1 AND ll.lot_id=$2 AND ll.balance > 0
+		 ORDER BY wl.pick_sequence, wl.code, wl.id
+		 FOR UPDATE OF ll`,
+		tenantID, lotID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch lot locations: %w", err)
+	}
+	located := 0.0
+	for rows.Next() {
+		var b bin
+		if err := rows.Scan(&b.LocationID, &b.Balance); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		located += b.Balance
+		bins = append(bins, b)
+	}
+	rows.Close() // ต้องปิดก่อนใช้ tx ต่อ
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch lot locations: %w", err)
+	}
+
+	picks := []Pick{}
+	remaining := quantity
+	for _, b := range bins {
+		if remaining <= 0 {
+			break
+		}
+		take := remaining
+		if b.Balance < take {
+			take = b.Balance
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE lot_locations SET balance = balance - $1, updated_date = CURRENT_TIMESTAMP
+			 WHERE lot_id=$2 AND location_id=$3`,
+			take, lotID, b.LocationID,
+		); err != nil {
+			return nil, fmt.Errorf("failed to update lot location: %w", err)
+		}
+		id := b.LocationID
+		picks = append(picks, Pick{LocationID: &id, Quantity: take})
+		remaining -= take
+	}
+
+	// ที่เหลือมาจากส่วนที่ยังไม่ได้เก็บเข้า bin
+	if remaining > 0 {
+		if unassigned := lotBalance - located; remaining > unassigned {
+			return nil, fmt.Errorf("lot_id=%d: %w", lotID, inventory.ErrInsufficientStock)
+		}
+		picks = append(picks, Pick{Quantity: remaining})
+	}
+	return picks, nil
+}
+
+// MoveRequest moves stock of a product between bins of one warehouse.
+// FromLocationID nil moves unassigned stock into a bin (putaway); LotID
+// nil takes lots in the tenant's costing order.
+type MoveRequest struct {
+	WarehouseID    int64
+	ProductID      int64
+	LotID          *int64
+	FromLocationID *int64
+	ToLocationID   int64
+	Quantity       float64
+	AppID          int64
+	StoreID        int64
+}
+
+// Move moves req.Quantity from one bin to another, recording each lot in
+// location_moves. The stock balance itself does not change.
+func Move(ctx context.Context, tx pgx.Tx, tenantID int64, settings tenant.Settings, req MoveRequest) error {
+	if req.Quantity <= 0 {
+		return ErrInvalidQuantity
+	}
+	if req.FromLocationID != nil && *req.FromLocationID == req.ToLocationID {
+		return ErrSameLocation
+	}
+	if err := EnsureBin(ctx, tx, tenantID, req.WarehouseID, req.ToLocationID); err != nil {
+		return err
+	}
+	if req.FromLocationID != nil {
+		// bin ที่ inactive ยังย้ายของออกได้
+		if err := ensureLocation(ctx, tx, tenantID, req.WarehouseID, *req.FromLocationID, false); err != nil {
+			return err
+		}
+	}
+
+	var stockID int64
+	err := tx.QueryRow(ctx,
+		`SELECT id FROM stock WHERE product_id=$1 AND warehouse_id=$2 AND tenant_id=$3 FOR UPDATE`,
+		req.ProductID, req.WarehouseID, tenantID,
+	).Scan(&stockID)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("product_id=%d: %w", req.ProductID, inventory.ErrInsufficientStock)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch stock: %w", err)
+	}
+
+	type lot struct {
+		ID      int64
+		Balance float64
+	}
+	lots := []lot{}
+	rows, err := tx.Query(ctx, `
+		SELECT id, balance FROM lot
+		WHERE stock_id=$1 AND balance > 0 AND ($2::BIGINT IS NULL OR id = $2)
+		ORDER BY created_date `+settings.LotOrder()+`
+		FOR UPDATE`, stockID, req.LotID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch lots: %w", err)
+	}
+	for rows.Next() {
+		var l lot
+		if err := rows.Scan(&l.ID, &l.Balance); err != nil {
+			rows.Close()
+			return err
+		}
+		lots = append(lots, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to fetch lots: %w", err)
+	}
+
+	remaining := req.Quantity
+	for _, l := range lots {
+		if remaining <= 0 {
+			break
+		}
+		var available float64
+		if req.FromLocationID != nil {
+			err = tx.QueryRow(ctx,
+				`SELECT COALESCE((SELECT balance FROM lot_locations WHERE lot_id=$1 AND location_id=$2 FOR UPDATE), 0)`,
+				l.ID, *req.FromLocationID,
+			).Scan(&available)
+		} else {
+			err = tx.QueryRow(ctx,
+				`SELECT $2::NUMERIC - COALESCE(SUM(balance), 0) FROM lot_locations WHERE lot_id=$1`,
+				l.ID, l.Balance,
+			).Scan(&available)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch lot location: %w", err)
+		}
+		take := remaining
+		if available < take {
+			take = available
+		}
+		if take <= 0 {
+			continue
+		}
+
+		if req.FromLocationID != nil {
+			if _, err := tx.Exec(ctx,
+				`UPDATE lot_locations SET balance = balance - $1, updated_date = CURRENT_TIMESTAMP
+				 WHERE lot_id=$2 AND location_id=$3`,
+				take, l.ID, *req.FromLocationID,
+			); err != nil {
+				return fmt.Errorf("failed to update lot location: %w", err)
+			}
+		}
+		if err := Put(ctx, tx, tenantID, stockID, l.ID, req.ToLocationID, take); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO location_moves (tenant_id, stock_id, lot_id, from_location_id, to_location_id, quantity, app_id, store_id)
+			 VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`,
+			tenantID, stockID, l.ID, req.FromLocationID, req.ToLocationID, take, req.AppID, req.StoreID,
+		); err != nil {
+			return fmt.Errorf("failed to insert location move: %w", err)
+		}
+		remaining -= take
+	}
+	if remaining > 0 {
+		return fmt.Errorf("product_id=%d: not enough stock in location: %w", req.ProductID, inventory.ErrInsufficientStock)
+	}
+	return nil
+}
+
+// Put adds quantity of a lot to a bin.
+func Put(ctx context.Context, tx pgx.Tx, tenantID, stockID, lotID, locationID int64, quantity float64) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO lot_locations (tenant_id, stock_id, lot_id, location_id, balance) VALUES ($1,$2,$3,$4,$5)
+		 ON CONFLICT (lot_id, location_id)
+		 DO UPDATE SET balance = lot_locations.balance + EXCLUDED.balance, updated_date = CURRENT_TIMESTAMP`,
+		tenantID, stockID, lotID, locationID, quantity,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update lot location: %w", err)
+	}
+	return nil
+}
+
+// EnsureBin returns an error unless locationID is an active bin of the warehouse.
+func EnsureBin(ctx context.Context, q tenant.Querier, tenantID, warehouseID, locationID int64) error {
+	return ensureLocation(ctx, q, tenantID, warehouseID, locationID, true)
+}
+
+func ensureLocation(ctx context.Context, q tenant.Querier, tenantID, warehouseID, locationID int64, mustBeActive bool) error {
+	var typ string
+	var status int16
+	err := q.QueryRow(ctx,
+		`SELECT type, status FROM warehouse_locations
+		 WHERE id=$1 AND tenant_id=$2 AND warehouse_id=$3 AND deleted_date IS NULL`,
+		locationID, tenantID, warehouseID,
+	).Scan(&typ, &status)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("location_id=%d: %w", locationID, ErrNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	if typ != TypeBin {
+		return fmt.Errorf("location_id=%d: %w", locationID, ErrNotBin)
+	}
+	if mustBeActive && status != 1 {
+		return fmt.Errorf("location_id=%d: %w", locationID, ErrInactive)
+	}
+	return nil
+}
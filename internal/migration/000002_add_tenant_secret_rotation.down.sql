@@ -0,0 +1,5 @@
+ALTER TABLE tenants
+  DROP CONSTRAINT IF EXISTS tenants_pending_secret_key,
+  DROP COLUMN IF EXISTS secret_rotated_date,
+  DROP COLUMN IF EXISTS secret_grace_until,
+  DROP COLUMN IF EXISTS pending_secret;
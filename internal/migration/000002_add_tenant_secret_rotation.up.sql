@@ -0,0 +1,5 @@
+ALTER TABLE tenants
+  ADD COLUMN pending_secret VARCHAR(255) NULL DEFAULT NULL,
+  ADD COLUMN secret_grace_until TIMESTAMP NULL DEFAULT NULL,
+  ADD COLUMN secret_rotated_date TIMESTAMP NULL DEFAULT NULL,
+  ADD CONSTRAINT tenants_pending_secret_key UNIQUE (pending_secret);
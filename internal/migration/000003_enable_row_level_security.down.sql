@@ -0,0 +1,36 @@
+DROP POLICY IF EXISTS tenant_isolation ON order_item;
+ALTER TABLE order_item NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE order_item DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON stock_movement;
+ALTER TABLE stock_movement NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE stock_movement DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON lot;
+ALTER TABLE lot NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE lot DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON stock_balance;
+ALTER TABLE stock_balance NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE stock_balance DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON "order";
+ALTER TABLE "order" NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE "order" DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON transaction;
+ALTER TABLE transaction NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE transaction DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON stock;
+ALTER TABLE stock NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE stock DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON product;
+ALTER TABLE product NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE product DISABLE ROW LEVEL SECURITY;
+
+DROP INDEX IF EXISTS order_tenant_id_idx;
+ALTER TABLE "order" DROP COLUMN IF EXISTS tenant_id;
+
+DROP FUNCTION IF EXISTS app_current_tenant();
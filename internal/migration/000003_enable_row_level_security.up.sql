@@ -0,0 +1,89 @@
+-- Tenant isolation. database.LoggingPool sets app.tenant_id per transaction
+-- (set_config(..., true)); when it is not set no tenant rows are visible.
+-- The application role must not be a superuser or have BYPASSRLS.
+
+CREATE OR REPLACE FUNCTION app_current_tenant() RETURNS BIGINT AS $$
+  SELECT NULLIF(current_setting('app.tenant_id', true), '')::BIGINT
+$$ LANGUAGE sql STABLE;
+
+ALTER TABLE "order" ADD COLUMN IF NOT EXISTS tenant_id BIGINT;
+
+-- existing orders take the tenant that owns the stock of their warehouse,
+-- else the tenant of their products; an order that matches no single
+-- tenant stops the migration instead of vanishing behind the policy
+UPDATE "order" o SET tenant_id = w.tenant_id
+FROM (
+  SELECT warehouse_id, MIN(tenant_id) AS tenant_id FROM stock
+  GROUP BY warehouse_id HAVING COUNT(DISTINCT tenant_id) = 1
+) w
+WHERE o.tenant_id IS NULL AND w.warehouse_id = o.warehouse_id;
+
+UPDATE "order" o SET tenant_id = p.tenant_id
+FROM (
+  SELECT oi.order_id, MIN(p.tenant_id) AS tenant_id
+  FROM order_item oi JOIN product p ON p.id = oi.product_id
+  GROUP BY oi.order_id HAVING COUNT(DISTINCT p.tenant_id) = 1
+) p
+WHERE o.tenant_id IS NULL AND p.order_id = o.id;
+
+DO $$
+DECLARE orphans BIGINT;
+BEGIN
+  SELECT COUNT(*) INTO orphans FROM "order" WHERE tenant_id IS NULL;
+  IF orphans > 0 THEN
+    RAISE EXCEPTION '% orders cannot be matched to one tenant, set "order".tenant_id by hand and migrate again', orphans;
+  END IF;
+END $$;
+
+ALTER TABLE "order" ALTER COLUMN tenant_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS order_tenant_id_idx ON "order" (tenant_id);
+
+ALTER TABLE product ENABLE ROW LEVEL SECURITY;
+ALTER TABLE product FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON product
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
+
+ALTER TABLE stock ENABLE ROW LEVEL SECURITY;
+ALTER TABLE stock FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON stock
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
+
+ALTER TABLE transaction ENABLE ROW LEVEL SECURITY;
+ALTER TABLE transaction FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON transaction
+  USING (teanant_id = app_current_tenant())
+  WITH CHECK (teanant_id = app_current_tenant());
+
+ALTER TABLE "order" ENABLE ROW LEVEL SECURITY;
+ALTER TABLE "order" FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON "order"
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
+
+-- tables without tenant_id are scoped through their parent row,
+-- which is itself filtered by the policies above
+ALTER TABLE stock_balance ENABLE ROW LEVEL SECURITY;
+ALTER TABLE stock_balance FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON stock_balance
+  USING (EXISTS (SELECT 1 FROM stock s WHERE s.id = stock_balance.stock_id))
+  WITH CHECK (EXISTS (SELECT 1 FROM stock s WHERE s.id = stock_balance.stock_id));
+
+ALTER TABLE lot ENABLE ROW LEVEL SECURITY;
+ALTER TABLE lot FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON lot
+  USING (EXISTS (SELECT 1 FROM stock s WHERE s.id = lot.stock_id))
+  WITH CHECK (EXISTS (SELECT 1 FROM stock s WHERE s.id = lot.stock_id));
+
+ALTER TABLE stock_movement ENABLE ROW LEVEL SECURITY;
+ALTER TABLE stock_movement FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON stock_movement
+  USING (EXISTS (SELECT 1 FROM stock s WHERE s.id = stock_movement.stock_id))
+  WITH CHECK (EXISTS (SELECT 1 FROM stock s WHERE s.id = stock_movement.stock_id));
+
+ALTER TABLE order_item ENABLE ROW LEVEL SECURITY;
+ALTER TABLE order_item FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON order_item
+  USING (EXISTS (SELECT 1 FROM "order" o WHERE o.id = order_item.order_id))
+  WITH CHECK (EXISTS (SELECT 1 FROM "order" o WHERE o.id = order_item.order_id));
@@ -0,0 +1,36 @@
+CREATE TABLE warehouses (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  code VARCHAR(50) NOT NULL,
+  name VARCHAR(255) NOT NULL,
+  status SMALLINT NOT NULL DEFAULT 1,
+  deleted_date TIMESTAMP NULL DEFAULT NULL,
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (tenant_id, code)
+);
+
+CREATE TABLE tenant_settings (
+  tenant_id BIGINT PRIMARY KEY REFERENCES tenants (id),
+  default_warehouse_id BIGINT NULL REFERENCES warehouses (id),
+  currency CHAR(3) NOT NULL DEFAULT 'THB',
+  timezone VARCHAR(64) NOT NULL DEFAULT 'Asia/Bangkok',
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE warehouses ENABLE ROW LEVEL SECURITY;
+ALTER TABLE warehouses FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON warehouses
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
+
+ALTER TABLE tenant_settings ENABLE ROW LEVEL SECURITY;
+ALTER TABLE tenant_settings FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON tenant_settings
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
@@ -0,0 +1,17 @@
+-- Admin-only, like tenants: no row-level security on purpose. It is read
+-- by the admin usage report across all tenants (GET /usage, admin token)
+-- and written by the worker rollup for every tenant in one pass; no
+-- tenant route reads it, and usage is kept after a tenant is purged.
+CREATE TABLE tenant_usage_monthly (
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  year_month DATE NOT NULL,
+  api_calls BIGINT NOT NULL DEFAULT 0,
+  orders_enqueued BIGINT NOT NULL DEFAULT 0,
+  stock_movements BIGINT NOT NULL DEFAULT 0,
+  webhook_pushes BIGINT NOT NULL DEFAULT 0,
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (tenant_id, year_month)
+);
+
+CREATE INDEX tenant_usage_monthly_year_month_idx ON tenant_usage_monthly (year_month);
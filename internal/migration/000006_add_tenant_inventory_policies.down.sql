@@ -0,0 +1,5 @@
+ALTER TABLE tenant_settings
+  DROP CONSTRAINT IF EXISTS tenant_settings_costing_method_check,
+  DROP COLUMN IF EXISTS costing_method,
+  DROP COLUMN IF EXISTS allow_backorders,
+  DROP COLUMN IF EXISTS auto_create_stock;
@@ -0,0 +1,7 @@
+-- defaults keep the behaviour from before these settings existed;
+-- tenants without a tenant_settings row use the same defaults in code
+ALTER TABLE tenant_settings
+  ADD COLUMN auto_create_stock BOOLEAN NOT NULL DEFAULT true,
+  ADD COLUMN allow_backorders BOOLEAN NOT NULL DEFAULT false,
+  ADD COLUMN costing_method VARCHAR(10) NOT NULL DEFAULT 'FIFO',
+  ADD CONSTRAINT tenant_settings_costing_method_check CHECK (costing_method IN ('FIFO', 'LIFO'));
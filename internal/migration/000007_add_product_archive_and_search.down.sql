@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS product_tenant_sku_idx;
+DROP INDEX IF EXISTS product_tenant_name_idx;
+ALTER TABLE product DROP COLUMN IF EXISTS archived_date;
@@ -0,0 +1,6 @@
+-- archived products stay for history but can no longer be ordered or issued
+ALTER TABLE product ADD COLUMN archived_date TIMESTAMP NULL DEFAULT NULL;
+
+-- prefix search on name/sku (LIKE 'abc%')
+CREATE INDEX product_tenant_name_idx ON product (tenant_id, lower(name) text_pattern_ops);
+CREATE INDEX product_tenant_sku_idx ON product (tenant_id, lower(sku) text_pattern_ops);
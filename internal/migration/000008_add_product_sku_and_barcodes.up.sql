@@ -0,0 +1,21 @@
+-- empty SKUs are allowed to repeat; existing duplicate SKUs must be fixed
+-- before this migration can run
+CREATE UNIQUE INDEX product_tenant_sku_key ON product (tenant_id, sku)
+  WHERE sku IS NOT NULL AND sku <> '';
+
+CREATE TABLE product_barcodes (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  product_id BIGINT NOT NULL REFERENCES product (id),
+  barcode VARCHAR(64) NOT NULL,
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (tenant_id, barcode)
+);
+CREATE INDEX product_barcodes_product_id_idx ON product_barcodes (product_id);
+
+ALTER TABLE product_barcodes ENABLE ROW LEVEL SECURITY;
+ALTER TABLE product_barcodes FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON product_barcodes
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
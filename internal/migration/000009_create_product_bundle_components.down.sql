@@ -0,0 +1,3 @@
+ALTER TABLE transaction DROP COLUMN IF EXISTS bundle_product_id;
+ALTER TABLE stock_movement DROP COLUMN IF EXISTS bundle_product_id;
+DROP TABLE IF EXISTS product_bundle_components;
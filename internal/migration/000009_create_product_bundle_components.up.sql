@@ -0,0 +1,24 @@
+-- a bundle (kit) is a product made of other products; only the components
+-- carry stock, the bundle itself is exploded when an order is deducted
+CREATE TABLE product_bundle_components (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  bundle_product_id BIGINT NOT NULL REFERENCES product (id),
+  component_product_id BIGINT NOT NULL REFERENCES product (id),
+  quantity NUMERIC(18, 4) NOT NULL CHECK (quantity > 0),
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (bundle_product_id, component_product_id),
+  CHECK (bundle_product_id <> component_product_id)
+);
+CREATE INDEX product_bundle_components_component_idx ON product_bundle_components (component_product_id);
+
+ALTER TABLE product_bundle_components ENABLE ROW LEVEL SECURITY;
+ALTER TABLE product_bundle_components FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON product_bundle_components
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
+
+-- movements of a component deducted for a bundle point back to the bundle
+ALTER TABLE stock_movement ADD COLUMN bundle_product_id BIGINT NULL;
+ALTER TABLE transaction ADD COLUMN bundle_product_id BIGINT NULL;
@@ -0,0 +1,21 @@
+-- alternative units a product can be ordered/issued in, e.g. CASE = 12 base units.
+-- quantities are always stored in the base unit; factor converts to it
+CREATE TABLE product_units (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  product_id BIGINT NOT NULL REFERENCES product (id),
+  code VARCHAR(20) NOT NULL,
+  name VARCHAR(100) NOT NULL DEFAULT '',
+  factor NUMERIC(18, 4) NOT NULL CHECK (factor > 0),
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (product_id, code)
+);
+
+ALTER TABLE product_units ENABLE ROW LEVEL SECURITY;
+ALTER TABLE product_units FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON product_units
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
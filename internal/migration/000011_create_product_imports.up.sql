@@ -0,0 +1,39 @@
+CREATE TABLE product_imports (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  format VARCHAR(10) NOT NULL,
+  status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, running, done, failed
+  total_rows INT NOT NULL DEFAULT 0,
+  processed_rows INT NOT NULL DEFAULT 0,
+  created_rows INT NOT NULL DEFAULT 0,
+  updated_rows INT NOT NULL DEFAULT 0,
+  failed_rows INT NOT NULL DEFAULT 0,
+  error TEXT NULL,
+  started_date TIMESTAMP NULL,
+  finished_date TIMESTAMP NULL,
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX product_imports_tenant_id_idx ON product_imports (tenant_id, id);
+
+CREATE TABLE product_import_errors (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  import_id BIGINT NOT NULL REFERENCES product_imports (id),
+  row_number INT NOT NULL,
+  sku VARCHAR(100) NULL,
+  error TEXT NOT NULL
+);
+CREATE INDEX product_import_errors_import_id_idx ON product_import_errors (import_id, row_number);
+
+ALTER TABLE product_imports ENABLE ROW LEVEL SECURITY;
+ALTER TABLE product_imports FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON product_imports
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
+
+ALTER TABLE product_import_errors ENABLE ROW LEVEL SECURITY;
+ALTER TABLE product_import_errors FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON product_import_errors
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
@@ -0,0 +1,43 @@
+-- price lists per currency and optionally per sales channel ("order".channel_id);
+-- channel_id NULL applies to every channel
+CREATE TABLE price_lists (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  code VARCHAR(50) NOT NULL,
+  name VARCHAR(255) NOT NULL,
+  currency CHAR(3) NOT NULL,
+  channel_id BIGINT NULL,
+  status SMALLINT NOT NULL DEFAULT 1,
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (tenant_id, code)
+);
+
+-- a price is effective from effective_from until the next record of the
+-- same product in the same list; rows are never updated, only added
+CREATE TABLE product_prices (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  price_list_id BIGINT NOT NULL REFERENCES price_lists (id),
+  product_id BIGINT NOT NULL REFERENCES product (id),
+  price NUMERIC(18, 4) NOT NULL CHECK (price >= 0),
+  effective_from TIMESTAMP NOT NULL,
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (price_list_id, product_id, effective_from)
+);
+CREATE INDEX product_prices_product_idx ON product_prices (product_id, effective_from DESC);
+
+ALTER TABLE price_lists ENABLE ROW LEVEL SECURITY;
+ALTER TABLE price_lists FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON price_lists
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
+
+ALTER TABLE product_prices ENABLE ROW LEVEL SECURITY;
+ALTER TABLE product_prices FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON product_prices
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
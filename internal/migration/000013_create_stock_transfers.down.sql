@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS stock_movement_transfer_idx;
+ALTER TABLE stock_movement DROP COLUMN IF EXISTS transfer_id;
+DROP TABLE IF EXISTS stock_transfer_lots;
+DROP TABLE IF EXISTS stock_transfer_lines;
+DROP TABLE IF EXISTS stock_transfers;
@@ -0,0 +1,76 @@
+-- a transfer moves stock from one warehouse to another. Shipping issues the
+-- source lots into stock_transfer_lots (the in-transit balance); receiving,
+-- possibly in several parts, moves them into lots of the destination
+CREATE TABLE stock_transfers (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  source_warehouse_id BIGINT NOT NULL REFERENCES warehouses (id),
+  destination_warehouse_id BIGINT NOT NULL REFERENCES warehouses (id),
+  app_id BIGINT NOT NULL,
+  store_id BIGINT NOT NULL,
+  status VARCHAR(20) NOT NULL DEFAULT 'in_transit',
+  note TEXT NULL,
+  shipped_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  received_date TIMESTAMP NULL,
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK (source_warehouse_id <> destination_warehouse_id),
+  CONSTRAINT stock_transfers_status_check CHECK (status IN ('in_transit', 'partially_received', 'received'))
+);
+CREATE INDEX stock_transfers_tenant_status_idx ON stock_transfers (tenant_id, status);
+
+CREATE TABLE stock_transfer_lines (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  transfer_id BIGINT NOT NULL REFERENCES stock_transfers (id),
+  product_id BIGINT NOT NULL REFERENCES product (id),
+  quantity NUMERIC(18, 4) NOT NULL CHECK (quantity > 0),
+  received_quantity NUMERIC(18, 4) NOT NULL DEFAULT 0,
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK (received_quantity >= 0 AND received_quantity <= quantity)
+);
+CREATE INDEX stock_transfer_lines_transfer_idx ON stock_transfer_lines (transfer_id);
+
+-- one row per source lot taken by a line; cost and the lot's created_date
+-- are copied so the destination lot keeps its cost and FIFO/LIFO position
+CREATE TABLE stock_transfer_lots (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  transfer_line_id BIGINT NOT NULL REFERENCES stock_transfer_lines (id),
+  source_lot_id BIGINT NOT NULL REFERENCES lot (id),
+  destination_lot_id BIGINT NULL REFERENCES lot (id),
+  quantity NUMERIC(18, 4) NOT NULL CHECK (quantity > 0),
+  received_quantity NUMERIC(18, 4) NOT NULL DEFAULT 0,
+  cost_fifo NUMERIC(18, 4) NOT NULL DEFAULT 0,
+  cost_average NUMERIC(18, 4) NOT NULL DEFAULT 0,
+  lot_created_date TIMESTAMP NOT NULL,
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK (received_quantity >= 0 AND received_quantity <= quantity)
+);
+CREATE INDEX stock_transfer_lots_line_idx ON stock_transfer_lots (transfer_line_id);
+
+ALTER TABLE stock_transfers ENABLE ROW LEVEL SECURITY;
+ALTER TABLE stock_transfers FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON stock_transfers
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
+
+ALTER TABLE stock_transfer_lines ENABLE ROW LEVEL SECURITY;
+ALTER TABLE stock_transfer_lines FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON stock_transfer_lines
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
+
+ALTER TABLE stock_transfer_lots ENABLE ROW LEVEL SECURITY;
+ALTER TABLE stock_transfer_lots FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON stock_transfer_lots
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
+
+-- both legs of a transfer (transfer_out / transfer_in) point to it
+ALTER TABLE stock_movement ADD COLUMN transfer_id BIGINT NULL;
+CREATE INDEX stock_movement_transfer_idx ON stock_movement (transfer_id) WHERE transfer_id IS NOT NULL;
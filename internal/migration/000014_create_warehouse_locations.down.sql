@@ -0,0 +1,4 @@
+ALTER TABLE stock_movement DROP COLUMN IF EXISTS location_id;
+DROP TABLE IF EXISTS location_moves;
+DROP TABLE IF EXISTS lot_locations;
+DROP TABLE IF EXISTS warehouse_locations;
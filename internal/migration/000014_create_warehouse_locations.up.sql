@@ -0,0 +1,75 @@
+-- locations inside a warehouse: zone > aisle > bin. Stock is only kept in
+-- bins; pick_sequence (then code) is the order bins are picked in
+CREATE TABLE warehouse_locations (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  warehouse_id BIGINT NOT NULL REFERENCES warehouses (id),
+  parent_id BIGINT NULL REFERENCES warehouse_locations (id),
+  type VARCHAR(10) NOT NULL,
+  code VARCHAR(50) NOT NULL,
+  name VARCHAR(255) NULL,
+  pick_sequence INT NOT NULL DEFAULT 0,
+  status SMALLINT NOT NULL DEFAULT 1,
+  deleted_date TIMESTAMP NULL DEFAULT NULL,
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (warehouse_id, code),
+  CONSTRAINT warehouse_locations_type_check CHECK (type IN ('zone', 'aisle', 'bin'))
+);
+CREATE INDEX warehouse_locations_parent_idx ON warehouse_locations (parent_id);
+
+-- how much of a lot sits in each bin; the part of lot.balance not in any
+-- bin is unassigned (e.g. just received) until it is moved into a bin
+CREATE TABLE lot_locations (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  stock_id BIGINT NOT NULL REFERENCES stock (id),
+  lot_id BIGINT NOT NULL REFERENCES lot (id),
+  location_id BIGINT NOT NULL REFERENCES warehouse_locations (id),
+  balance NUMERIC(18, 4) NOT NULL DEFAULT 0 CHECK (balance >= 0),
+  updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (lot_id, location_id)
+);
+CREATE INDEX lot_locations_location_idx ON lot_locations (location_id);
+CREATE INDEX lot_locations_stock_idx ON lot_locations (stock_id);
+
+-- bin to bin moves do not change the stock balance, so they are kept here
+-- instead of stock_movement; from_location_id NULL is the unassigned part
+CREATE TABLE location_moves (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  stock_id BIGINT NOT NULL REFERENCES stock (id),
+  lot_id BIGINT NOT NULL REFERENCES lot (id),
+  from_location_id BIGINT NULL REFERENCES warehouse_locations (id),
+  to_location_id BIGINT NOT NULL REFERENCES warehouse_locations (id),
+  quantity NUMERIC(18, 4) NOT NULL CHECK (quantity > 0),
+  app_id BIGINT NOT NULL,
+  store_id BIGINT NOT NULL,
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX location_moves_stock_idx ON location_moves (stock_id);
+
+ALTER TABLE warehouse_locations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE warehouse_locations FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON warehouse_locations
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
+
+ALTER TABLE lot_locations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE lot_locations FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON lot_locations
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
+
+ALTER TABLE location_moves ENABLE ROW LEVEL SECURITY;
+ALTER TABLE location_moves FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON location_moves
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
+
+-- the bin an issue or transfer was picked from (NULL = unassigned stock)
+ALTER TABLE stock_movement ADD COLUMN location_id BIGINT NULL;
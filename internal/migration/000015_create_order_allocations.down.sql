@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS order_allocations;
+ALTER TABLE warehouses DROP COLUMN IF EXISTS priority;
@@ -0,0 +1,25 @@
+-- lower priority is allocated first by the priority strategy
+ALTER TABLE warehouses ADD COLUMN priority INT NOT NULL DEFAULT 0;
+
+-- which warehouse fulfilled each (exploded) line of a queued order;
+-- order_id / order_number are the values sent to /orders-queue
+CREATE TABLE order_allocations (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  order_id BIGINT NOT NULL,
+  order_number VARCHAR(100) NULL,
+  product_id BIGINT NOT NULL REFERENCES product (id),
+  bundle_product_id BIGINT NULL,
+  warehouse_id BIGINT NOT NULL REFERENCES warehouses (id),
+  quantity NUMERIC(18, 4) NOT NULL CHECK (quantity > 0),
+  strategy VARCHAR(20) NULL,
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX order_allocations_order_idx ON order_allocations (tenant_id, order_id);
+
+ALTER TABLE order_allocations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE order_allocations FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON order_allocations
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS stock_movement_order_idx;
+ALTER TABLE stock_movement DROP COLUMN IF EXISTS order_id;
+DROP TABLE IF EXISTS stock_reservations;
@@ -0,0 +1,33 @@
+-- stock reserved for an order; stock.reserve is the sum of the rows still
+-- 'reserved' and available = balance - reserve. Bundles are reserved as
+-- their components, one row per component
+CREATE TABLE stock_reservations (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  order_id BIGINT NOT NULL REFERENCES "order" (id),
+  stock_id BIGINT NOT NULL REFERENCES stock (id),
+  product_id BIGINT NOT NULL REFERENCES product (id),
+  bundle_product_id BIGINT NULL,
+  quantity NUMERIC(18, 4) NOT NULL CHECK (quantity > 0),
+  status VARCHAR(10) NOT NULL DEFAULT 'reserved',
+  reserved_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  released_date TIMESTAMP NULL,
+  issued_date TIMESTAMP NULL,
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT stock_reservations_status_check CHECK (status IN ('reserved', 'released', 'issued'))
+);
+CREATE INDEX stock_reservations_order_idx ON stock_reservations (order_id);
+CREATE INDEX stock_reservations_stock_idx ON stock_reservations (stock_id) WHERE status = 'reserved';
+
+ALTER TABLE stock_reservations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE stock_reservations FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON stock_reservations
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
+
+-- reserve / release / issue of an order point back to it
+ALTER TABLE stock_movement ADD COLUMN order_id BIGINT NULL;
+CREATE INDEX stock_movement_order_idx ON stock_movement (order_id) WHERE order_id IS NOT NULL;
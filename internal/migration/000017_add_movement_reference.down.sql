@@ -0,0 +1,3 @@
+ALTER TABLE stock_reservations DROP COLUMN IF EXISTS movement_id;
+DROP INDEX IF EXISTS stock_movement_reference_idx;
+ALTER TABLE stock_movement DROP COLUMN IF EXISTS reference_movement_id;
@@ -0,0 +1,8 @@
+-- compensating movements (release, cancel, return) point at the movement
+-- they reverse; SUM(balance_change) of the references is what has already
+-- been put back
+ALTER TABLE stock_movement ADD COLUMN reference_movement_id BIGINT NULL REFERENCES stock_movement (id);
+CREATE INDEX stock_movement_reference_idx ON stock_movement (reference_movement_id) WHERE reference_movement_id IS NOT NULL;
+
+-- the 'reserve' movement of a reservation, referenced when it is released
+ALTER TABLE stock_reservations ADD COLUMN movement_id BIGINT NULL REFERENCES stock_movement (id);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS stock_movement_adjustment_idx;
+ALTER TABLE stock_movement DROP COLUMN IF EXISTS adjustment_id;
+DROP TABLE IF EXISTS stock_adjustments;
+ALTER TABLE tenant_settings
+  DROP CONSTRAINT IF EXISTS tenant_settings_adjustment_threshold_check,
+  DROP COLUMN IF EXISTS adjustment_approval_threshold;
@@ -0,0 +1,47 @@
+-- adjustments whose variance (base units) is above the threshold wait for
+-- approval before they touch stock; 0 turns approval off
+ALTER TABLE tenant_settings
+  ADD COLUMN adjustment_approval_threshold NUMERIC(18, 4) NOT NULL DEFAULT 0,
+  ADD CONSTRAINT tenant_settings_adjustment_threshold_check CHECK (adjustment_approval_threshold >= 0);
+
+-- a correction of stock after damage, loss or a physical count. quantity is
+-- the variance applied to stock; for a count it is counted - expected at
+-- the time of the count
+CREATE TABLE stock_adjustments (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL REFERENCES tenants (id),
+  warehouse_id BIGINT NOT NULL REFERENCES warehouses (id),
+  product_id BIGINT NOT NULL REFERENCES product (id),
+  stock_id BIGINT NOT NULL REFERENCES stock (id),
+  location_id BIGINT NULL REFERENCES warehouse_locations (id),
+  reason_code VARCHAR(20) NOT NULL,
+  note TEXT NULL,
+  expected_quantity NUMERIC(18, 4) NULL,
+  counted_quantity NUMERIC(18, 4) NULL,
+  quantity NUMERIC(18, 4) NOT NULL,
+  unit_cost NUMERIC(18, 4) NULL,
+  status VARCHAR(10) NOT NULL,
+  app_id BIGINT NOT NULL,
+  store_id BIGINT NOT NULL,
+  requested_by BIGINT NULL,
+  reviewed_by BIGINT NULL,
+  reviewed_date TIMESTAMP NULL,
+  applied_date TIMESTAMP NULL,
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_updated_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT stock_adjustments_reason_check
+    CHECK (reason_code IN ('damage', 'loss', 'theft', 'expired', 'count', 'found', 'other')),
+  CONSTRAINT stock_adjustments_status_check CHECK (status IN ('pending', 'applied', 'rejected'))
+);
+CREATE INDEX stock_adjustments_tenant_status_idx ON stock_adjustments (tenant_id, status);
+
+ALTER TABLE stock_adjustments ENABLE ROW LEVEL SECURITY;
+ALTER TABLE stock_adjustments FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON stock_adjustments
+  USING (tenant_id = app_current_tenant())
+  WITH CHECK (tenant_id = app_current_tenant());
+
+ALTER TABLE stock_movement ADD COLUMN adjustment_id BIGINT NULL;
+CREATE INDEX stock_movement_adjustment_idx ON stock_movement (adjustment_id) WHERE adjustment_id IS NOT NULL;
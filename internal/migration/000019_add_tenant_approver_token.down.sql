@@ -0,0 +1 @@
+ALTER TABLE tenants DROP COLUMN IF EXISTS approver_token_hash;
@@ -0,0 +1,3 @@
+-- approving or rejecting a pending stock adjustment needs the approver
+-- token on top of the tenant's HMAC signature; only its sha256 is stored
+ALTER TABLE tenants ADD COLUMN approver_token_hash VARCHAR(64) NULL DEFAULT NULL;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS stock_balance_stock_month_key;
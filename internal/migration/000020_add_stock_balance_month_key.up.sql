@@ -0,0 +1,3 @@
+-- one stock_balance row per stock and month, so the current month's row
+-- can be upserted (inventory.AddBalance)
+CREATE UNIQUE INDEX IF NOT EXISTS stock_balance_stock_month_key ON stock_balance (stock_id, year_month);
@@ -23,6 +23,8 @@ type LogEvent struct {
 	Error       string      `json:"error,omitempty"`
 	Level       string      `json:"level,omitempty"`
 	DevName     string      `json:"dev_name,omitempty"`
+	Action      string      `json:"action,omitempty"`
+	Actor       string      `json:"actor,omitempty"`
 }
 
 // LogDebug: สำหรับ dev ใช้ log debug event
@@ -147,3 +149,20 @@ func LogOrder(p tasks.DeductStockPayload, status, message, errMsg string) {
 		_ = sink.Write(event)
 	}
 }
+
+// LogSecurity: audit event เช่น การ rotate secret ของ tenant
+func LogSecurity(tenantID int64, action, actor, message string) {
+	event := LogEvent{
+		Type:      "security",
+		Level:     "INFO",
+		TenantID:  tenantID,
+		Action:    action,
+		Actor:     actor,
+		Message:   message,
+		Status:    action,
+		Timestamp: time.Now(),
+	}
+	for _, sink := range enabledSinks {
+		_ = sink.Write(event)
+	}
+}
@@ -0,0 +1,77 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"atlasq/internal/tenant"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var ErrNoPrice = errors.New("no price for product")
+
+// Sources of a resolved price.
+const (
+	SourcePriceList = "price_list"
+	SourceProduct   = "product"
+)
+
+type Price struct {
+	ProductID     int64      `json:"product_id"`
+	Price         float64    `json:"price"`
+	Currency      string     `json:"currency"`
+	Source        string     `json:"source"`
+	PriceListID   *int64     `json:"price_list_id,omitempty"`
+	PriceListCode *string    `json:"price_list_code,omitempty"`
+	EffectiveFrom *time.Time `json:"effective_from,omitempty"`
+}
+
+// Resolve returns the product's price at time at for the currency and
+// channel. Among the tenant's active price lists in that currency a list
+// for the channel wins over a list for all channels, and inside a list the
+// record with the latest effective_from <= at applies. When no list has a
+// price, product.price is used if currency is the tenant's own currency.
+func Resolve(ctx context.Context, q tenant.Querier, tenantID, productID int64, at time.Time, currency string, channelID *int64) (Price, error) {
+	p := Price{ProductID: productID, Currency: currency, Source: SourcePriceList}
+	var listID int64
+	var listCode string
+	var from time.Time
+	err := q.QueryRow(ctx,
+		`SELECT pp.price, pl.id, pl.code, pp.effective_from
+		 FROM product_prices pp
+		 JOIN price_lists pl ON pl.id = pp.price_list_id
+		 WHERE pp.tenant_id=$1 AND pp.product_id=$2 AND pp.effective_from <= $3
+		   AND pl.currency=$4 AND pl.status=1
+		   AND (pl.channel_id IS NULL OR pl.channel_id = $5)
+		 ORDER BY (pl.channel_id IS NULL), pp.effective_from DESC, pl.id
+		 LIMIT 1`,
+		tenantID, productID, at.UTC(), currency, channelID,
+	).Scan(&p.Price, &listID, &listCode, &from)
+	if err == nil {
+		p.PriceListID, p.PriceListCode, p.EffectiveFrom = &listID, &listCode, &from
+		return p, nil
+	}
+	if err != pgx.ErrNoRows {
+		return Price{}, err
+	}
+
+	// ไม่มีใน price list -> ใช้ราคาบน product ถ้าเป็นสกุลเงินหลักของ tenant
+	settings, err := tenant.LoadSettings(ctx, q, tenantID)
+	if err != nil {
+		return Price{}, err
+	}
+	if currency != settings.Currency {
+		return Price{}, ErrNoPrice
+	}
+	err = q.QueryRow(ctx, `SELECT price FROM product WHERE id=$1 AND tenant_id=$2`, productID, tenantID).Scan(&p.Price)
+	if err == pgx.ErrNoRows {
+		return Price{}, ErrNoPrice
+	}
+	if err != nil {
+		return Price{}, err
+	}
+	p.Source = SourceProduct
+	return p, nil
+}
@@ -0,0 +1,274 @@
+package productimport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"atlasq/internal/database"
+	"atlasq/internal/inventory"
+	"atlasq/internal/tenant"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// MaxBytes จำกัดขนาดไฟล์ เพราะไฟล์ถูกส่งไปกับ payload ของ task ใน redis
+const MaxBytes = 4 << 20
+
+// อัปเดต progress ทุกกี่แถว
+const progressEvery = 100
+
+// Row is one product in the file, with the same fields as POST /products.
+type Row struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+	SKU         string  `json:"sku"`
+}
+
+// parsedRow is a row of the file; Err is set when the row could not be read.
+type parsedRow struct {
+	Number int
+	Row    Row
+	Err    error
+}
+
+func ValidFormat(format string) bool {
+	return format == FormatCSV || format == FormatJSONL
+}
+
+// parse อ่านไฟล์ทั้งไฟล์ error ระดับไฟล์ (เช่น header ไม่ครบ) ทำให้ import ล้มทั้งหมด
+// ส่วน error ระดับแถวเก็บไว้ใน parsedRow.Err
+func parse(format string, data []byte) ([]parsedRow, error) {
+	switch format {
+	case FormatCSV:
+		return parseCSV(data)
+	case FormatJSONL:
+		return parseJSONL(data)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// CSV ต้องมี header อย่างน้อย name, price, sku (description ไม่บังคับ) เรียงลำดับไหนก็ได้
+func parseCSV(data []byte) ([]parsedRow, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, errors.New("file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid csv header: %w", err)
+	}
+	col := map[string]int{}
+	for i, h := range header {
+		col[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))] = i
+	}
+	for _, required := range []string{"name", "price", "sku"} {
+		if _, ok := col[required]; !ok {
+			return nil, fmt.Errorf("csv header must contain %q", required)
+		}
+	}
+	field := func(rec []string, name string) string {
+		i, ok := col[name]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	rows := []parsedRow{}
+	for n := 2; ; n++ { // แถวที่ 1 คือ header
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		p := parsedRow{Number: n}
+		if err != nil {
+			p.Err = err
+			rows = append(rows, p)
+			continue
+		}
+		p.Row = Row{
+			Name:        field(rec, "name"),
+			Description: field(rec, "description"),
+			SKU:         field(rec, "sku"),
+		}
+		if p.Row.Price, err = strconv.ParseFloat(field(rec, "price"), 64); err != nil {
+			p.Err = errors.New("price must be a number")
+		}
+		rows = append(rows, p)
+	}
+	return rows, nil
+}
+
+func parseJSONL(data []byte) ([]parsedRow, error) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64<<10), MaxBytes)
+
+	rows := []parsedRow{}
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		p := parsedRow{Number: n}
+		if err := json.Unmarshal(line, &p.Row); err != nil {
+			p.Err = errors.New("invalid json")
+		}
+		rows = append(rows, p)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// progress is the running count written to product_imports.
+type progress struct {
+	Total     int
+	Processed int
+	Created   int
+	Updated   int
+	Failed    int
+}
+
+// Run validates every row with the CreateProduct rules and upserts it by
+// SKU. Rows fail individually into product_import_errors; only file-level
+// problems return an error. Running an import again starts it over, so a
+// retried job does not double-count.
+func Run(ctx context.Context, pool *database.LoggingPool, tenantID, importID int64, format string, data []byte) error {
+	ctx = tenant.WithID(ctx, tenantID)
+
+	if _, err := pool.Exec(ctx, `DELETE FROM product_import_errors WHERE import_id=$1`, importID); err != nil {
+		return err
+	}
+	if _, err := pool.Exec(ctx,
+		`UPDATE product_imports SET status='running', started_date=CURRENT_TIMESTAMP,
+		 processed_rows=0, created_rows=0, updated_rows=0, failed_rows=0, error=NULL,
+		 updated_date=CURRENT_TIMESTAMP WHERE id=$1`, importID,
+	); err != nil {
+		return err
+	}
+
+	rows, err := parse(format, data)
+	if err != nil {
+		return finish(ctx, pool, importID, progress{}, err)
+	}
+
+	prog := progress{Total: len(rows)}
+	if err := saveProgress(ctx, pool, importID, prog); err != nil {
+		return err
+	}
+	for i, p := range rows {
+		created, err := importRow(ctx, pool, tenantID, p)
+		switch {
+		case err != nil:
+			prog.Failed++
+			if err := recordError(ctx, pool, tenantID, importID, p, err); err != nil {
+				return err
+			}
+		case created:
+			prog.Created++
+		default:
+			prog.Updated++
+		}
+		prog.Processed++
+
+		if (i+1)%progressEvery == 0 {
+			if err := saveProgress(ctx, pool, importID, prog); err != nil {
+				return err
+			}
+		}
+	}
+	return finish(ctx, pool, importID, prog, nil)
+}
+
+func importRow(ctx context.Context, pool *database.LoggingPool, tenantID int64, p parsedRow) (created bool, err error) {
+	if p.Err != nil {
+		return false, p.Err
+	}
+	r := p.Row
+	if r.SKU == "" {
+		return false, errors.New("sku is required for import")
+	}
+	if err := inventory.ValidateProduct(r.Name, r.SKU, r.Price); err != nil {
+		return false, err
+	}
+
+	// xmax = 0 แปลว่าเป็นแถวที่เพิ่ง insert ไม่ใช่ update
+	err = pool.QueryRow(ctx,
+		`INSERT INTO product (tenant_id, name, description, price, sku) VALUES ($1,$2,$3,$4,$5)
+		 ON CONFLICT (tenant_id, sku) WHERE sku IS NOT NULL AND sku <> ''
+		 DO UPDATE SET name=EXCLUDED.name, description=EXCLUDED.description, price=EXCLUDED.price
+		 RETURNING (xmax = 0)`,
+		tenantID, r.Name, r.Description, r.Price, r.SKU,
+	).Scan(&created)
+	if err != nil {
+		return false, fmt.Errorf("failed to save product: %w", err)
+	}
+	return created, nil
+}
+
+func recordError(ctx context.Context, pool *database.LoggingPool, tenantID, importID int64, p parsedRow, rowErr error) error {
+	var sku *string
+	if p.Row.SKU != "" {
+		s := p.Row.SKU
+		if len(s) > 100 {
+			s = s[:100]
+		}
+		sku = &s
+	}
+	_, err := pool.Exec(ctx,
+		`INSERT INTO product_import_errors (tenant_id, import_id, row_number, sku, error) VALUES ($1,$2,$3,$4,$5)`,
+		tenantID, importID, p.Number, sku, rowErr.Error(),
+	)
+	return err
+}
+
+func saveProgress(ctx context.Context, pool *database.LoggingPool, importID int64, prog progress) error {
+	_, err := pool.Exec(ctx,
+		`UPDATE product_imports SET total_rows=$1, processed_rows=$2, created_rows=$3, updated_rows=$4,
+		 failed_rows=$5, updated_date=CURRENT_TIMESTAMP WHERE id=$6`,
+		prog.Total, prog.Processed, prog.Created, prog.Updated, prog.Failed, importID,
+	)
+	return err
+}
+
+// finish บันทึกผลสุดท้าย fileErr != nil คือทั้งไฟล์ใช้ไม่ได้ (status failed)
+func finish(ctx context.Context, pool *database.LoggingPool, importID int64, prog progress, fileErr error) error {
+	status, msg := "done", (*string)(nil)
+	if fileErr != nil {
+		s := fileErr.Error()
+		status, msg = "failed", &s
+	}
+	_, err := pool.Exec(ctx,
+		`UPDATE product_imports SET status=$1, error=$2, total_rows=$3, processed_rows=$4, created_rows=$5,
+		 updated_rows=$6, failed_rows=$7, finished_date=CURRENT_TIMESTAMP, updated_date=CURRENT_TIMESTAMP
+		 WHERE id=$8`,
+		status, msg, prog.Total, prog.Processed, prog.Created, prog.Updated, prog.Failed, importID,
+	)
+	return err
+}
+
+// Fail marks the import failed when the job gives up, e.g. after the last retry.
+func Fail(ctx context.Context, pool *database.LoggingPool, tenantID, importID int64, jobErr error) error {
+	_, err := pool.Exec(tenant.WithID(ctx, tenantID),
+		`UPDATE product_imports SET status='failed', error=$1, finished_date=CURRENT_TIMESTAMP,
+		 updated_date=CURRENT_TIMESTAMP WHERE id=$2`,
+		jobErr.Error(), importID,
+	)
+	return err
+}
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"strconv"
+
+	"atlasq/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Middleware applies the per-tenant limits. It must run after auth.HMAC.
+// ถ้า redis มีปัญหาจะปล่อย request ผ่าน (fail open) และ log ไว้
+func Middleware(l *Limiter) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, _ := c.Locals(auth.LocalTenantID).(int64)
+		tenantType, _ := c.Locals(auth.LocalTenantType).(string)
+		if tenantID == 0 {
+			return fiber.NewError(fiber.StatusUnauthorized, "tenant not authenticated")
+		}
+
+		res, err := l.Allow(c.UserContext(), tenantID, tenantType)
+		if err != nil {
+			log.Printf("rate limit check failed tenant=%d: %v", tenantID, err)
+			return c.Next()
+		}
+
+		c.Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Set("X-RateLimit-Reset", strconv.Itoa(seconds(res.Reset.Seconds())))
+		if res.QuotaLimit > 0 {
+			c.Set("X-Quota-Limit", strconv.FormatInt(res.QuotaLimit, 10))
+			c.Set("X-Quota-Remaining", strconv.FormatInt(res.QuotaRemaining, 10))
+		}
+
+		if !res.Allowed {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds(res.RetryAfter.Seconds())))
+			if res.QuotaExceeded {
+				return fiber.NewError(fiber.StatusTooManyRequests, "daily quota exceeded")
+			}
+			return fiber.NewError(fiber.StatusTooManyRequests, "rate limit exceeded")
+		}
+		return c.Next()
+	}
+}
+
+func seconds(s float64) int {
+	if s <= 0 {
+		return 0
+	}
+	return int(math.Ceil(s))
+}
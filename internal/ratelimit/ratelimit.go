@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"atlasq/internal/tenant"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Tier is the token-bucket and quota configuration for one tenant type.
+type Tier struct {
+	Rate       float64 // tokens per second
+	Burst      int     // bucket size
+	DailyQuota int64   // requests per UTC day, 0 = unlimited
+}
+
+var defaultTiers = map[string]Tier{
+	tenant.TypeNormal:     {Rate: 10, Burst: 20, DailyQuota: 100000},
+	tenant.TypePremium:    {Rate: 50, Burst: 100, DailyQuota: 1000000},
+	tenant.TypeEnterprise: {Rate: 200, Burst: 400},
+}
+
+// token bucket ทำใน Lua เพื่อให้อ่าน/เขียนเป็น atomic และใช้เวลาจาก redis
+var tokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(data[1])
+local ts = tonumber(data[2])
+if tokens == nil then
+  tokens = burst
+  ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+type Limiter struct {
+	rdb   *redis.Client
+	tiers map[string]Tier
+}
+
+// New returns a Limiter using the default tiers, overridable per type with
+// RATE_LIMIT_<TYPE>=rate:burst[:daily_quota], e.g. RATE_LIMIT_NORMAL=5:10:50000.
+func New(rdb *redis.Client) *Limiter {
+	tiers := make(map[string]Tier, len(defaultTiers))
+	for typ, tier := range defaultTiers {
+		if v := os.Getenv("RATE_LIMIT_" + typ); v != "" {
+			if t, err := parseTier(v); err == nil {
+				tier = t
+			}
+		}
+		tiers[typ] = tier
+	}
+	return &Limiter{rdb: rdb, tiers: tiers}
+}
+
+func parseTier(v string) (Tier, error) {
+	parts := strings.Split(v, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Tier{}, fmt.Errorf("invalid tier %q", v)
+	}
+	rate, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || rate <= 0 {
+		return Tier{}, fmt.Errorf("invalid rate %q", parts[0])
+	}
+	burst, err := strconv.Atoi(parts[1])
+	if err != nil || burst <= 0 {
+		return Tier{}, fmt.Errorf("invalid burst %q", parts[1])
+	}
+	t := Tier{Rate: rate, Burst: burst}
+	if len(parts) == 3 {
+		if t.DailyQuota, err = strconv.ParseInt(parts[2], 10, 64); err != nil || t.DailyQuota < 0 {
+			return Tier{}, fmt.Errorf("invalid quota %q", parts[2])
+		}
+	}
+	return t, nil
+}
+
+// Tier returns the limits for a tenant type; unknown types get NORMAL limits.
+func (l *Limiter) Tier(tenantType string) Tier {
+	if t, ok := l.tiers[tenantType]; ok {
+		return t
+	}
+	return l.tiers[tenant.TypeNormal]
+}
+
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // time until the next token, when not allowed
+	Reset      time.Duration // time until the bucket is full again
+
+	QuotaLimit     int64 // 0 = unlimited
+	QuotaRemaining int64
+	QuotaExceeded  bool
+}
+
+// Allow takes one token from the tenant's bucket and counts the request
+// against its daily quota.
+func (l *Limiter) Allow(ctx context.Context, tenantID int64, tenantType string) (Result, error) {
+	tier := l.Tier(tenantType)
+	res := Result{Limit: tier.Burst, QuotaLimit: tier.DailyQuota}
+
+	v, err := tokenBucket.Run(ctx, l.rdb, []string{fmt.Sprintf("ratelimit:%d", tenantID)}, tier.Rate, tier.Burst).Slice()
+	if err != nil {
+		return res, err
+	}
+	allowed, _ := v[0].(int64)
+	tokens, _ := strconv.ParseFloat(fmt.Sprint(v[1]), 64)
+
+	res.Allowed = allowed == 1
+	res.Remaining = int(math.Floor(tokens))
+	res.Reset = time.Duration((float64(tier.Burst) - tokens) / tier.Rate * float64(time.Second))
+	if !res.Allowed {
+		res.RetryAfter = time.Duration((1 - tokens) / tier.Rate * float64(time.Second))
+		return res, nil
+	}
+
+	if tier.DailyQuota > 0 {
+		key := fmt.Sprintf("quota:%d:%s", tenantID, time.Now().UTC().Format("20060102"))
+		used, err := l.rdb.Incr(ctx, key).Result()
+		if err != nil {
+			return res, err
+		}
+		if used == 1 {
+			l.rdb.Expire(ctx, key, 48*time.Hour)
+		}
+		res.QuotaRemaining = tier.DailyQuota - used
+		if res.QuotaRemaining < 0 {
+			res.QuotaRemaining = 0
+			res.QuotaExceeded = true
+			res.Allowed = false
+			res.RetryAfter = time.Until(time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour))
+		}
+	}
+	return res, nil
+}
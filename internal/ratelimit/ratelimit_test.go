@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"atlasq/internal/tenant"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestParseTier(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Tier
+		wantErr bool
+	}{
+		{in: "5:10", want: Tier{Rate: 5, Burst: 10}},
+		{in: "0.5:1:50000", want: Tier{Rate: 0.5, Burst: 1, DailyQuota: 50000}},
+		{in: "5:10:0", want: Tier{Rate: 5, Burst: 10}},
+		{in: "5", wantErr: true},
+		{in: "5:10:1:2", wantErr: true},
+		{in: "x:10", wantErr: true},
+		{in: "0:10", wantErr: true},
+		{in: "5:0", wantErr: true},
+		{in: "5:1.5", wantErr: true},
+		{in: "5:10:-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseTier(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("parseTier = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTierOverride(t *testing.T) {
+	t.Setenv("RATE_LIMIT_"+tenant.TypePremium, "7:14:700")
+	t.Setenv("RATE_LIMIT_"+tenant.TypeNormal, "bogus")
+	l := New(nil)
+
+	if got, want := l.Tier(tenant.TypePremium), (Tier{Rate: 7, Burst: 14, DailyQuota: 700}); got != want {
+		t.Errorf("premium tier = %+v, want %+v", got, want)
+	}
+	// ค่า env ที่ parse ไม่ได้ใช้ default เดิม
+	if got, want := l.Tier(tenant.TypeNormal), defaultTiers[tenant.TypeNormal]; got != want {
+		t.Errorf("normal tier = %+v, want %+v", got, want)
+	}
+	// type ที่ไม่รู้จักใช้ tier ของ normal
+	if got, want := l.Tier("unknown"), defaultTiers[tenant.TypeNormal]; got != want {
+		t.Errorf("unknown tier = %+v, want %+v", got, want)
+	}
+}
+
+// รัน token bucket script กับ redis จริง ตั้ง REDIS_ADDR เพื่อเปิด test นี้
+func TestAllowTokenBucket(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR not set")
+	}
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	defer rdb.Close()
+
+	tenantID := time.Now().UnixNano()
+	quotaKey := fmt.Sprintf("quota:%d:%s", tenantID, time.Now().UTC().Format("20060102"))
+	defer rdb.Del(ctx, fmt.Sprintf("ratelimit:%d", tenantID), quotaKey)
+
+	// refill ช้ามากจนไม่มี token เพิ่มระหว่าง test
+	l := &Limiter{rdb: rdb, tiers: map[string]Tier{tenant.TypeNormal: {Rate: 0.001, Burst: 3, DailyQuota: 2}}}
+
+	tests := []struct {
+		name          string
+		wantAllowed   bool
+		wantRemaining int
+		wantQuota     bool
+	}{
+		{name: "first request", wantAllowed: true, wantRemaining: 2},
+		{name: "second request", wantAllowed: true, wantRemaining: 1},
+		{name: "quota exceeded", wantAllowed: false, wantRemaining: 0, wantQuota: true},
+		{name: "bucket empty", wantAllowed: false, wantRemaining: 0},
+	}
+	for _, tt := range tests {
+		res, err := l.Allow(ctx, tenantID, tenant.TypeNormal)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if res.Allowed != tt.wantAllowed || res.Remaining != tt.wantRemaining || res.QuotaExceeded != tt.wantQuota {
+			t.Errorf("%s: got allowed=%v remaining=%d quota_exceeded=%v, want %v %d %v",
+				tt.name, res.Allowed, res.Remaining, res.QuotaExceeded, tt.wantAllowed, tt.wantRemaining, tt.wantQuota)
+		}
+		if !res.Allowed && res.RetryAfter <= 0 {
+			t.Errorf("%s: RetryAfter = %v, want > 0", tt.name, res.RetryAfter)
+		}
+	}
+}
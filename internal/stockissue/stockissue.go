@@ -0,0 +1,161 @@
+// Package stockissue takes stock out of a warehouse. Every path that
+// deducts stock (/stock-issue, order issue, the order queue worker and
+// /orders-old) goes through Line, so available = balance - reserve, lots,
+// bins and stock_balance stay in step whichever path shipped the goods.
+package stockissue
+
+import (
+	"context"
+	"fmt"
+
+	"atlasq/internal/inventory"
+	"atlasq/internal/location"
+	"atlasq/internal/tenant"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Request is what an issue is for. OrderID links the movements to an
+// "order" row so cancel and return can reverse them; FromReservation takes
+// the quantity out of what the order already reserved.
+type Request struct {
+	AppID           int64
+	StoreID         int64
+	WarehouseID     int64
+	Model           string
+	OrderID         *int64
+	FromReservation bool
+}
+
+// Line ตัด stock, stock_balance, lot และ bin ของ product หนึ่งตัวใน req.WarehouseID
+// ต้องมี available = balance - reserve พอ เว้นแต่ tenant เปิด allow_backorders
+func Line(ctx context.Context, tx pgx.Tx, tenantID int64, settings tenant.Settings, req Request, line inventory.Line) error {
+	// Lock stock row
+	var stockID int64
+	var balance, reserve, onHand float64
+	err := tx.QueryRow(ctx, `
+		SELECT id, balance, reserve, on_hand 
+		FROM stock 
+		WHERE product_id=$1 AND warehouse_id=$2 AND tenant_id=$3
+		FOR UPDATE
+	`, line.ProductID, req.WarehouseID, tenantID).Scan(&stockID, &balance, &reserve, &onHand)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("product_id=%d warehouse_id=%d: %w", line.ProductID, req.WarehouseID, inventory.ErrStockNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch stock: %w", err)
+	}
+
+	// available = balance - reserve; ตัดจาก reservation ใช้ยอดที่จองไว้แล้วได้
+	available := balance - reserve
+	reserveChange := 0.0
+	if req.FromReservation {
+		available = balance
+		reserveChange = line.Quantity
+	}
+	if available < line.Quantity && !settings.AllowBackorders {
+		return fmt.Errorf("insufficient stock balance for product_id=%d: %w", line.ProductID, inventory.ErrInsufficientStock)
+	}
+
+	// stock + stock_balance (reserve ลดเฉพาะตอนตัดจาก reservation)
+	if err := inventory.AddBalance(ctx, tx, stockID, -line.Quantity, -reserveChange); err != nil {
+		return err
+	}
+
+	// ดึง lot ทั้งหมดก่อน
+	type Lot struct {
+		ID          int64
+		Balance     float64
+		CostFIFO    float64
+		CostAverage float64
+	}
+	lots := []Lot{}
+	rows, err := tx.Query(ctx, `
+		SELECT id, balance, cost_fifo, cost_average
+		FROM lot
+		WHERE stock_id=$1 AND balance > 0
+		ORDER BY created_date `+settings.LotOrder(), stockID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch lots: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l Lot
+		if err := rows.Scan(&l.ID, &l.Balance, &l.CostFIFO, &l.CostAverage); err != nil {
+			return err
+		}
+		lots = append(lots, l)
+	}
+	rows.Close() // ต้องปิดก่อนใช้ tx ต่อ
+
+	// Deduct lots ตามลำดับ FIFO/LIFO
+	remaining := line.Quantity
+	for _, lot := range lots {
+		toDeduct := remaining
+		if lot.Balance < toDeduct {
+			toDeduct = lot.Balance
+		}
+
+		// Update lot
+		if _, err := tx.Exec(ctx, `UPDATE lot SET balance = balance - $1 WHERE id=$2`, toDeduct, lot.ID); err != nil {
+			return fmt.Errorf("failed to update lot: %w", err)
+		}
+
+		// หยิบจาก bin ตามลำดับ pick แล้วบันทึก movement แยกตาม bin
+		picks, err := location.Take(ctx, tx, tenantID, lot.ID, lot.Balance, toDeduct)
+		if err != nil {
+			return err
+		}
+		for _, pick := range picks {
+			pickReserve := 0.0
+			if req.FromReservation {
+				pickReserve = pick.Quantity
+			}
+			_, err = tx.Exec(ctx, `
+				INSERT INTO stock_movement (
+					app_id, store_id, stock_id, lot_id, balance_before, balance_after, balance_change,
+					reserve_before, reserve_after, reserve_change, cost_fifo, cost_average,
+					action, model, bundle_product_id, location_id, order_id, created_date, updated_date
+				) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,'issue',$13,$14,$15,$16,NOW(),NOW())`,
+				req.AppID, req.StoreID, stockID, lot.ID, balance, balance-pick.Quantity, -pick.Quantity,
+				reserve, reserve-pickReserve, -pickReserve, lot.CostFIFO, lot.CostAverage, req.Model,
+				line.BundleProductID, pick.LocationID, req.OrderID)
+			if err != nil {
+				return fmt.Errorf("failed to insert stock_movement: %w", err)
+			}
+			balance -= pick.Quantity
+			reserve -= pickReserve
+		}
+
+		remaining -= toDeduct
+
+		if remaining <= 0 {
+			break
+		}
+	}
+
+	if remaining > 0 {
+		if !settings.AllowBackorders {
+			return fmt.Errorf("not enough lot quantity to fulfill the request: %w", inventory.ErrInsufficientStock)
+		}
+		// backorder: ส่วนที่ไม่มี lot รองรับบันทึกเป็น movement ที่ไม่มี lot_id
+		remainingReserve := 0.0
+		if req.FromReservation {
+			remainingReserve = remaining
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO stock_movement (
+				app_id, store_id, stock_id, lot_id, balance_before, balance_after, balance_change,
+				reserve_before, reserve_after, reserve_change, cost_fifo, cost_average,
+				action, model, bundle_product_id, order_id, created_date, updated_date
+			) VALUES ($1,$2,$3,NULL,$4,$5,$6,$7,$8,$9,0,0,'issue',$10,$11,$12,NOW(),NOW())`,
+			req.AppID, req.StoreID, stockID, balance, balance-remaining, -remaining,
+			reserve, reserve-remainingReserve, -remainingReserve, req.Model, line.BundleProductID, req.OrderID)
+		if err != nil {
+			return fmt.Errorf("failed to insert stock_movement: %w", err)
+		}
+	}
+
+	return nil
+}
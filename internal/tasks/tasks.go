@@ -1,9 +1,13 @@
 package tasks
 
+import "time"
+
 // ข้อมูลของแต่ละ item ที่อยู่ใน order
 type OrderItem struct {
-	ProductID int64 `json:"product_id"`
-	Quantity  int64 `json:"quantity"`
+	ProductID int64  `json:"product_id"`
+	SKU       string `json:"sku,omitempty"` // ใช้แทน product_id ได้ API จะ resolve เป็น product_id ก่อนเข้าคิว
+	Quantity  int64  `json:"quantity"`
+	Unit      string `json:"unit,omitempty"` // API แปลงเป็นหน่วยฐานก่อนเข้าคิว ใน payload จึงว่างเสมอ
 }
 
 // Payload ที่ใช้ส่งเข้า queue
@@ -11,7 +15,10 @@ type DeductStockPayload struct {
 	TenantID    int64       `json:"tenant_id"`
 	OrderNumber string      `json:"order_number"`
 	WarehouseID int64       `json:"warehouse_id"`
+	Allocation  string      `json:"allocation,omitempty"` // ไม่ว่าง = ไม่ระบุ warehouse ให้ worker แบ่งตาม strategy
 	OrderID     int64       `json:"order_id"`
+	AppID       int64       `json:"app_id,omitempty"`
+	StoreID     int64       `json:"store_id,omitempty"`
 	Items       []OrderItem `json:"items"`
 }
 
@@ -19,6 +26,61 @@ type DeductStockPayload struct {
 type OrderRequest struct {
 	OrderID     int64       `json:"order_id"`
 	WarehouseID int64       `json:"warehouse_id"`
+	Allocation  string      `json:"allocation,omitempty"` // priority, most_stock, fewest_splits (ห้ามส่งคู่กับ warehouse_id)
 	Items       []OrderItem `json:"items"`
 	OrderNumber string      `json:"order_number"`
+	AppID       int64       `json:"app_id,omitempty"` // บันทึกลง stock_movement ของ line ที่ตัด
+	StoreID     int64       `json:"store_id,omitempty"`
+}
+
+// Allocation บอกว่า line ไหน (หลังแตก bundle) ถูกตัดจาก warehouse ไหนเท่าไร
+type Allocation struct {
+	ProductID       int64   `json:"product_id"`
+	BundleProductID *int64  `json:"bundle_product_id,omitempty"`
+	WarehouseID     int64   `json:"warehouse_id"`
+	Quantity        float64 `json:"quantity"`
+}
+
+const (
+	TypeDeductStock    = "order:deduct_stock"
+	TypeTenantCallback = "tenant:callback"
+	TypeUsageRollup    = "usage:rollup"
+	TypeTenantPurge    = "tenant:purge"
+	TypeProductImport  = "product:import"
+)
+
+// queue แยกของ callback เพื่อให้ดู dead-letter (archived) ได้ง่าย
+const QueueCallbacks = "callbacks"
+
+const (
+	CallbackOrderDeducted = "order.deducted"
+	CallbackOrderFailed   = "order.failed"
+)
+
+// Payload ของ callback ที่จะ POST ไปยัง tenants.callback_url
+type CallbackPayload struct {
+	Event       string       `json:"event"`
+	TenantID    int64        `json:"tenant_id"`
+	OrderID     int64        `json:"order_id"`
+	OrderNumber string       `json:"order_number"`
+	WarehouseID int64        `json:"warehouse_id"`
+	Items       []OrderItem  `json:"items"`
+	Allocations []Allocation `json:"allocations,omitempty"`
+	Status      string       `json:"status"`
+	Error       string       `json:"error,omitempty"`
+	OccurredAt  time.Time    `json:"occurred_at"`
+}
+
+// Payload ของ job ลบข้อมูล tenant ถาวร DryRun=true จะนับแถวอย่างเดียว
+type TenantPurgePayload struct {
+	TenantID int64 `json:"tenant_id"`
+	DryRun   bool  `json:"dry_run"`
+}
+
+// Payload ของ bulk import ไฟล์ทั้งไฟล์อยู่ใน Data (จำกัดขนาดที่ productimport.MaxBytes)
+type ProductImportPayload struct {
+	TenantID int64  `json:"tenant_id"`
+	ImportID int64  `json:"import_id"`
+	Format   string `json:"format"`
+	Data     []byte `json:"data"`
 }
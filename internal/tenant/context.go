@@ -0,0 +1,16 @@
+package tenant
+
+import "context"
+
+type ctxKey struct{}
+
+// WithID returns a copy of ctx that carries the authenticated tenant ID.
+func WithID(ctx context.Context, id int64) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// IDFromContext returns the tenant ID stored by WithID.
+func IDFromContext(ctx context.Context) (int64, bool) {
+	id, ok := ctx.Value(ctxKey{}).(int64)
+	return id, ok && id > 0
+}
@@ -0,0 +1,79 @@
+package tenant
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Costing methods decide the order lots are consumed in.
+const (
+	CostingFIFO = "FIFO"
+	CostingLIFO = "LIFO"
+)
+
+type Settings struct {
+	DefaultWarehouseID *int64 `json:"default_warehouse_id"`
+	Currency           string `json:"currency"`
+	Timezone           string `json:"timezone"`
+	AutoCreateStock    bool   `json:"auto_create_stock"`
+	AllowBackorders    bool   `json:"allow_backorders"`
+	CostingMethod      string `json:"costing_method"`
+	// variance (หน่วยฐาน) ที่เกินค่านี้ต้อง approve ก่อน adjust, 0 = ไม่ต้อง approve
+	AdjustmentApprovalThreshold float64 `json:"adjustment_approval_threshold"`
+}
+
+// DefaultSettings matches the column defaults in tenant_settings.
+func DefaultSettings() Settings {
+	return Settings{
+		Currency:        "THB",
+		Timezone:        "Asia/Bangkok",
+		AutoCreateStock: true,
+		AllowBackorders: false,
+		CostingMethod:   CostingFIFO,
+	}
+}
+
+const SettingsColumns = `default_warehouse_id, currency, timezone, auto_create_stock, allow_backorders, costing_method,
+	adjustment_approval_threshold`
+
+func ScanSettings(row pgx.Row, s *Settings) error {
+	return row.Scan(&s.DefaultWarehouseID, &s.Currency, &s.Timezone, &s.AutoCreateStock, &s.AllowBackorders, &s.CostingMethod,
+		&s.AdjustmentApprovalThreshold)
+}
+
+// LoadSettings returns the tenant's settings, or the defaults when the
+// tenant has no tenant_settings row yet.
+func LoadSettings(ctx context.Context, q Querier, tenantID int64) (Settings, error) {
+	s := DefaultSettings()
+	err := ScanSettings(q.QueryRow(ctx, `SELECT `+SettingsColumns+` FROM tenant_settings WHERE tenant_id=$1`, tenantID), &s)
+	if err == pgx.ErrNoRows {
+		return DefaultSettings(), nil
+	}
+	return s, err
+}
+
+// WarehouseOrDefault คืน warehouseID ถ้าระบุมา ไม่งั้นใช้ default warehouse ของ tenant (0 ถ้าไม่มี)
+func (s Settings) WarehouseOrDefault(warehouseID int64) int64 {
+	if warehouseID != 0 || s.DefaultWarehouseID == nil {
+		return warehouseID
+	}
+	return *s.DefaultWarehouseID
+}
+
+// NeedsApproval reports whether an adjustment of variance (either sign)
+// must be approved before it is applied.
+func (s Settings) NeedsApproval(variance float64) bool {
+	if variance < 0 {
+		variance = -variance
+	}
+	return s.AdjustmentApprovalThreshold > 0 && variance > s.AdjustmentApprovalThreshold
+}
+
+// LotOrder คืนทิศ ORDER BY created_date ของ lot ตาม costing_method (ASC = FIFO)
+func (s Settings) LotOrder() string {
+	if s.CostingMethod == CostingLIFO {
+		return "DESC"
+	}
+	return "ASC"
+}
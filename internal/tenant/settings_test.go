@@ -0,0 +1,49 @@
+package tenant
+
+import "testing"
+
+func TestLotOrder(t *testing.T) {
+	tests := []struct {
+		method string
+		want   string
+	}{
+		{method: CostingFIFO, want: "ASC"},
+		{method: CostingLIFO, want: "DESC"},
+		{method: "", want: "ASC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			if got := (Settings{CostingMethod: tt.method}).LotOrder(); got != tt.want {
+				t.Errorf("LotOrder() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if got := DefaultSettings().LotOrder(); got != "ASC" {
+		t.Errorf("default LotOrder() = %q, want ASC", got)
+	}
+}
+
+func TestNeedsApproval(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold float64
+		variance  float64
+		want      bool
+	}{
+		{name: "no threshold", threshold: 0, variance: 1000, want: false},
+		{name: "below threshold", threshold: 10, variance: 9.5, want: false},
+		{name: "at threshold", threshold: 10, variance: 10, want: false},
+		{name: "above threshold", threshold: 10, variance: 10.5, want: true},
+		{name: "negative below threshold", threshold: 10, variance: -9, want: false},
+		{name: "negative above threshold", threshold: 10, variance: -11, want: true},
+		{name: "zero variance", threshold: 10, variance: 0, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Settings{AdjustmentApprovalThreshold: tt.threshold}
+			if got := s.NeedsApproval(tt.variance); got != tt.want {
+				t.Errorf("NeedsApproval(%v) = %v, want %v", tt.variance, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	TypeNormal     = "NORMAL"
+	TypePremium    = "PREMIUM"
+	TypeEnterprise = "ENTERPRISE"
+)
+
+// ActiveSQL is the condition a tenants row must satisfy to use the API.
+const ActiveSQL = "status = 1 AND activate = 1 AND deleted_date IS NULL"
+
+var ErrInactive = errors.New("tenant is inactive or deleted")
+
+// Querier is satisfied by pgxpool.Pool, pgx.Conn and pgx.Tx.
+type Querier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// ValidType reports whether t is a known tenant type.
+func ValidType(t string) bool {
+	switch t {
+	case TypeNormal, TypePremium, TypeEnterprise:
+		return true
+	}
+	return false
+}
+
+// EnsureActive returns ErrInactive unless the tenant exists, is enabled and
+// has not been soft-deleted.
+func EnsureActive(ctx context.Context, q Querier, id int64) error {
+	var active bool
+	err := q.QueryRow(ctx, `SELECT `+ActiveSQL+` FROM tenants WHERE id=$1`, id).Scan(&active)
+	if err == pgx.ErrNoRows || (err == nil && !active) {
+		return ErrInactive
+	}
+	return err
+}
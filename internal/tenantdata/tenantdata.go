@@ -0,0 +1,175 @@
+package tenantdata
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"atlasq/internal/database"
+	"atlasq/internal/tenant"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Table is a table holding tenant data. Where selects the tenant's rows
+// with the tenant id as $1; tables without a tenant column go through
+// their parent.
+type Table struct {
+	Name  string
+	Where string
+}
+
+const (
+	byTenant = `tenant_id = $1`
+	byStock  = `stock_id IN (SELECT id FROM stock WHERE tenant_id = $1)`
+	byOrder  = `order_id IN (SELECT id FROM "order" WHERE tenant_id = $1)`
+)
+
+// Tables เรียงจาก parent -> child (ลำดับ export) ตอน purge จะลบย้อนกลับ
+// ตารางใหม่ที่มีข้อมูลของ tenant ต้องเพิ่มที่นี่ด้วย
+var Tables = []Table{
+	{Name: "warehouses", Where: byTenant},
+	{Name: "warehouse_locations", Where: byTenant},
+	{Name: "tenant_settings", Where: byTenant},
+	{Name: "product", Where: byTenant},
+	{Name: "product_barcodes", Where: byTenant},
+	{Name: "product_bundle_components", Where: byTenant},
+	{Name: "product_units", Where: byTenant},
+	{Name: "product_imports", Where: byTenant},
+	{Name: "product_import_errors", Where: byTenant},
+	{Name: "price_lists", Where: byTenant},
+	{Name: "product_prices", Where: byTenant},
+	{Name: "stock", Where: byTenant},
+	{Name: "stock_balance", Where: byStock},
+	{Name: "lot", Where: byStock},
+	{Name: "stock_movement", Where: byStock},
+	{Name: "stock_transfers", Where: byTenant},
+	{Name: "stock_transfer_lines", Where: byTenant},
+	{Name: "stock_transfer_lots", Where: byTenant},
+	{Name: "lot_locations", Where: byTenant},
+	{Name: "location_moves", Where: byTenant},
+	{Name: "stock_adjustments", Where: byTenant},
+	{Name: `"order"`, Where: byTenant},
+	{Name: "order_item", Where: byOrder},
+	{Name: "order_allocations", Where: byTenant},
+	{Name: "stock_reservations", Where: byTenant},
+	{Name: "transaction", Where: `teanant_id = $1`},
+}
+
+var (
+	ErrTenantNotFound = errors.New("tenant not found")
+	ErrNotDeleted     = errors.New("tenant must be deleted before it can be purged")
+)
+
+// CheckPurgeable ลบถาวรได้เฉพาะ tenant ที่ถูก soft delete แล้ว
+func CheckPurgeable(ctx context.Context, q tenant.Querier, tenantID int64) error {
+	var deleted bool
+	err := q.QueryRow(ctx, `SELECT deleted_date IS NOT NULL FROM tenants WHERE id=$1`, tenantID).Scan(&deleted)
+	if err == pgx.ErrNoRows {
+		return ErrTenantNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrNotDeleted
+	}
+	return nil
+}
+
+// TableCount is the number of rows a tenant has in one table.
+type TableCount struct {
+	Table string `json:"table"`
+	Rows  int64  `json:"rows"`
+}
+
+type record struct {
+	Table string          `json:"table"`
+	Row   json.RawMessage `json:"row"`
+}
+
+// Export writes every row the tenant owns to w as NDJSON, one
+// {"table": ..., "row": {...}} object per line. All tables are read from
+// the same snapshot.
+func Export(ctx context.Context, pool *database.LoggingPool, tenantID int64, w io.Writer) error {
+	ctx = tenant.WithID(ctx, tenantID)
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, t := range Tables {
+		if err := exportTable(ctx, tx, t, tenantID, enc); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func exportTable(ctx context.Context, tx pgx.Tx, t Table, tenantID int64, enc *json.Encoder) error {
+	rows, err := tx.Query(ctx, `SELECT row_to_json(t) FROM `+t.Name+` t WHERE `+t.Where, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to export %s: %w", t.Name, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row json.RawMessage
+		if err := rows.Scan(&row); err != nil {
+			return fmt.Errorf("failed to export %s: %w", t.Name, err)
+		}
+		if err := enc.Encode(record{Table: t.Name, Row: row}); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Purge deletes every row the tenant owns, children first, in a single
+// transaction. With dryRun it only counts the rows that would be deleted.
+// The tenants row and its usage history are kept for billing and audit.
+func Purge(ctx context.Context, pool *database.LoggingPool, tenantID int64, dryRun bool) ([]TableCount, error) {
+	ctx = tenant.WithID(ctx, tenantID)
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if !dryRun {
+		if err := CheckPurgeable(ctx, tx, tenantID); err != nil {
+			return nil, err
+		}
+		// tenant_settings อ้าง warehouses อยู่ ต้องตัด reference ก่อนลบ
+		if _, err := tx.Exec(ctx, `UPDATE tenant_settings SET default_warehouse_id = NULL WHERE tenant_id = $1`, tenantID); err != nil {
+			return nil, fmt.Errorf("failed to clear default warehouse: %w", err)
+		}
+	}
+
+	counts := make([]TableCount, 0, len(Tables))
+	for i := len(Tables) - 1; i >= 0; i-- {
+		t := Tables[i]
+		var n int64
+		if dryRun {
+			err = tx.QueryRow(ctx, `SELECT count(*) FROM `+t.Name+` WHERE `+t.Where, tenantID).Scan(&n)
+		} else {
+			tag, execErr := tx.Exec(ctx, `DELETE FROM `+t.Name+` WHERE `+t.Where, tenantID)
+			n, err = tag.RowsAffected(), execErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to purge %s: %w", t.Name, err)
+		}
+		counts = append(counts, TableCount{Table: t.Name, Rows: n})
+	}
+
+	if dryRun {
+		return counts, nil
+	}
+	return counts, tx.Commit(ctx)
+}
@@ -0,0 +1,422 @@
+// Package transfer moves stock between two warehouses of a tenant. Ship
+// issues the source lots into an in-transit balance (stock_transfer_lots)
+// and Receive moves it, in one or more parts, into the destination.
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"atlasq/internal/inventory"
+	"atlasq/internal/location"
+	"atlasq/internal/tenant"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	StatusInTransit         = "in_transit"
+	StatusPartiallyReceived = "partially_received"
+	StatusReceived          = "received"
+)
+
+// model ของ stock_movement ทั้งสองขา
+const movementModel = "transfer"
+
+var (
+	ErrNotFound         = errors.New("transfer not found")
+	ErrLineNotFound     = errors.New("transfer line not found")
+	ErrSameWarehouse    = errors.New("source and destination warehouse must differ")
+	ErrAlreadyReceived  = errors.New("transfer is already received")
+	ErrOverReceive      = errors.New("quantity is more than what is in transit")
+	ErrNoStockRecord    = errors.New("destination warehouse has no stock for product")
+	ErrInvalidQuantity  = errors.New("quantity must be > 0")
+	ErrNothingToReceive = errors.New("nothing to receive")
+)
+
+// IsInvalid reports whether err is a client error from Ship or Receive.
+func IsInvalid(err error) bool {
+	return inventory.IsInvalid(err) || errors.Is(err, ErrLineNotFound) || errors.Is(err, ErrSameWarehouse) ||
+		errors.Is(err, ErrAlreadyReceived) || errors.Is(err, ErrOverReceive) || errors.Is(err, ErrNoStockRecord) ||
+		errors.Is(err, ErrInvalidQuantity) || errors.Is(err, ErrNothingToReceive)
+}
+
+// Request is a transfer to ship. Lines are in base units with bundles
+// already exploded.
+type Request struct {
+	SourceWarehouseID      int64
+	DestinationWarehouseID int64
+	AppID                  int64
+	StoreID                int64
+	Note                   string
+	Lines                  []inventory.Line
+}
+
+// Receipt is a quantity (base unit) received for one transfer line.
+type Receipt struct {
+	LineID   int64   `json:"line_id"`
+	Quantity float64 `json:"quantity"`
+}
+
+// Ship creates the transfer and takes its lines out of the source
+// warehouse in the tenant's costing order. Transfers never backorder: the
+// source must have lots for the whole quantity.
+func Ship(ctx context.Context, tx pgx.Tx, tenantID int64, settings tenant.Settings, req Request) (int64, error) {
+	if req.SourceWarehouseID == req.DestinationWarehouseID {
+		return 0, ErrSameWarehouse
+	}
+	for _, id := range []int64{req.SourceWarehouseID, req.DestinationWarehouseID} {
+		if err := inventory.EnsureWarehouseActive(ctx, tx, tenantID, id); err != nil {
+			return 0, err
+		}
+	}
+
+	var transferID int64
+	err := tx.QueryRow(ctx,
+		`INSERT INTO stock_transfers (tenant_id, source_warehouse_id, destination_warehouse_id, app_id, store_id, note)
+		 VALUES ($1,$2,$3,$4,$5,NULLIF($6,'')) RETURNING id`,
+		tenantID, req.SourceWarehouseID, req.DestinationWarehouseID, req.AppID, req.StoreID, req.Note,
+	).Scan(&transferID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create transfer: %w", err)
+	}
+
+	for _, line := range req.Lines {
+		if line.Quantity <= 0 {
+			return 0, ErrInvalidQuantity
+		}
+		var lineID int64
+		err := tx.QueryRow(ctx,
+			`INSERT INTO stock_transfer_lines (tenant_id, transfer_id, product_id, quantity) VALUES ($1,$2,$3,$4) RETURNING id`,
+			tenantID, transferID, line.ProductID, line.Quantity,
+		).Scan(&lineID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create transfer line: %w", err)
+		}
+		if err := shipLine(ctx, tx, tenantID, settings, transferID, lineID, req, line); err != nil {
+			return 0, err
+		}
+	}
+	return transferID, nil
+}
+
+// shipLine ตัด lot ของ source แล้วเก็บแต่ละ lot ไว้ใน stock_transfer_lots (ยอด in-transit)
+func shipLine(ctx context.Context, tx pgx.Tx, tenantID int64, settings tenant.Settings, transferID, lineID int64, req Request, line inventory.Line) error {
+	var stockID int64
+	var balance, reserve float64
+	err := tx.QueryRow(ctx,
+		`SELECT id, balance, reserve FROM stock WHERE product_id=$1 AND warehouse_id=$2 AND tenant_id=$3 FOR UPDATE`,
+		line.ProductID, req.SourceWarehouseID, tenantID,
+	).Scan(&stockID, &balance, &reserve)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("product_id=%d: %w", line.ProductID, inventory.ErrInsufficientStock)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch stock: %w", err)
+	}
+	// ของที่ reserve ให้ order ไว้ย้ายออกไม่ได้
+	if balance-reserve < line.Quantity {
+		return fmt.Errorf("product_id=%d: %w", line.ProductID, inventory.ErrInsufficientStock)
+	}
+
+	type lot struct {
+		ID          int64
+		Balance     float64
+		CostFIFO    float64
+		CostAverage float64
+		CreatedDate time.Time
+	}
+	lots := []lot{}
+	rows, err := tx.Query(ctx, `
+		SELECT id, balance, cost_fifo, cost_average, created_date
+		FROM lot
+		WHERE stock_id=$1 AND balance > 0
+		ORDER BY created_date `+settings.LotOrder()+`
+		FOR UPDATE`, stockID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch lots: %w", err)
+	}
+	for rows.Next() {
+		var l lot
+		if err := rows.Scan(&l.ID, &l.Balance, &l.CostFIFO, &l.CostAverage, &l.CreatedDate); err != nil {
+			rows.Close()
+			return err
+		}
+		lots = append(lots, l)
+	}
+	rows.Close() // ต้องปิดก่อนใช้ tx ต่อ
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to fetch lots: %w", err)
+	}
+
+	remaining := line.Quantity
+	for _, l := range lots {
+		if remaining <= 0 {
+			break
+		}
+		take := remaining
+		if l.Balance < take {
+			take = l.Balance
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE lot SET balance = balance - $1 WHERE id=$2`, take, l.ID); err != nil {
+			return fmt.Errorf("failed to update lot: %w", err)
+		}
+		_, err := tx.Exec(ctx,
+			`INSERT INTO stock_transfer_lots (tenant_id, transfer_line_id, source_lot_id, quantity, cost_fifo, cost_average, lot_created_date)
+			 VALUES ($1,$2,$3,$4,$5,$6,$7)`,
+			tenantID, lineID, l.ID, take, l.CostFIFO, l.CostAverage, l.CreatedDate,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record in-transit lot: %w", err)
+		}
+		picks, err := location.Take(ctx, tx, tenantID, l.ID, l.Balance, take)
+		if err != nil {
+			return err
+		}
+		for _, pick := range picks {
+			if err := insertMovement(ctx, tx, req.AppID, req.StoreID, stockID, l.ID, pick.LocationID, balance, reserve, -pick.Quantity,
+				l.CostFIFO, l.CostAverage, "transfer_out", transferID, line.BundleProductID); err != nil {
+				return err
+			}
+			balance -= pick.Quantity
+		}
+		remaining -= take
+	}
+	if remaining > 0 {
+		return fmt.Errorf("product_id=%d: not enough lot quantity: %w", line.ProductID, inventory.ErrInsufficientStock)
+	}
+
+	return inventory.AddBalance(ctx, tx, stockID, -line.Quantity, 0)
+}
+
+// Receive moves quantities of the transfer's lines from in transit into
+// the destination warehouse. With no receipts everything still in transit
+// is received. Each source lot becomes (or adds to) a destination lot with
+// the same cost and created_date.
+func Receive(ctx context.Context, tx pgx.Tx, tenantID int64, settings tenant.Settings, transferID int64, receipts []Receipt) error {
+	var status string
+	var destWarehouseID, appID, storeID int64
+	err := tx.QueryRow(ctx,
+		`SELECT status, destination_warehouse_id, app_id, store_id FROM stock_transfers
+		 WHERE id=$1 AND tenant_id=$2 FOR UPDATE`,
+		transferID, tenantID,
+	).Scan(&status, &destWarehouseID, &appID, &storeID)
+	if err == pgx.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch transfer: %w", err)
+	}
+	if status == StatusReceived {
+		return ErrAlreadyReceived
+	}
+	if err := inventory.EnsureWarehouseActive(ctx, tx, tenantID, destWarehouseID); err != nil {
+		return err
+	}
+
+	type line struct {
+		ProductID   int64
+		Outstanding float64
+		Receive     float64
+	}
+	lines := map[int64]*line{}
+	order := []int64{}
+	rows, err := tx.Query(ctx,
+		`SELECT id, product_id, quantity - received_quantity FROM stock_transfer_lines
+		 WHERE transfer_id=$1 AND tenant_id=$2 ORDER BY id FOR UPDATE`,
+		transferID, tenantID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to fetch transfer lines: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		var l line
+		if err := rows.Scan(&id, &l.ProductID, &l.Outstanding); err != nil {
+			rows.Close()
+			return err
+		}
+		lines[id] = &l
+		order = append(order, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to fetch transfer lines: %w", err)
+	}
+
+	if len(receipts) == 0 {
+		for _, l := range lines {
+			l.Receive = l.Outstanding
+		}
+	}
+	for _, r := range receipts {
+		l, ok := lines[r.LineID]
+		if !ok {
+			return fmt.Errorf("line_id=%d: %w", r.LineID, ErrLineNotFound)
+		}
+		if r.Quantity <= 0 {
+			return fmt.Errorf("line_id=%d: %w", r.LineID, ErrInvalidQuantity)
+		}
+		l.Receive += r.Quantity
+		if l.Receive > l.Outstanding {
+			return fmt.Errorf("line_id=%d: %w", r.LineID, ErrOverReceive)
+		}
+	}
+
+	received := false
+	for _, id := range order {
+		l := lines[id]
+		if l.Receive <= 0 {
+			continue
+		}
+		if err := receiveLine(ctx, tx, tenantID, settings, transferID, id, destWarehouseID, appID, storeID, l.ProductID, l.Receive); err != nil {
+			return err
+		}
+		received = true
+	}
+	if !received {
+		return ErrNothingToReceive
+	}
+
+	// รับครบทุก line แล้ว -> received ไม่งั้น partially_received
+	_, err = tx.Exec(ctx,
+		`UPDATE stock_transfers t SET
+		   status = CASE WHEN done THEN 'received' ELSE 'partially_received' END,
+		   received_date = CASE WHEN done THEN CURRENT_TIMESTAMP END,
+		   updated_date = CURRENT_TIMESTAMP, row_updated_date = CURRENT_TIMESTAMP
+		 FROM (SELECT bool_and(received_quantity = quantity) AS done FROM stock_transfer_lines WHERE transfer_id=$1) l
+		 WHERE t.id=$1`,
+		transferID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update transfer: %w", err)
+	}
+	return nil
+}
+
+func receiveLine(ctx context.Context, tx pgx.Tx, tenantID int64, settings tenant.Settings, transferID, lineID, warehouseID, appID, storeID, productID int64, quantity float64) error {
+	var stockID int64
+	var balance, reserve float64
+	err := tx.QueryRow(ctx,
+		`SELECT id, balance, reserve FROM stock WHERE product_id=$1 AND warehouse_id=$2 AND tenant_id=$3 FOR UPDATE`,
+		productID, warehouseID, tenantID,
+	).Scan(&stockID, &balance, &reserve)
+	if err == pgx.ErrNoRows {
+		if !settings.AutoCreateStock {
+			return fmt.Errorf("product_id=%d: %w", productID, ErrNoStockRecord)
+		}
+		err = tx.QueryRow(ctx,
+			`INSERT INTO stock (
+				tenant_id, warehouse_id, product_id,
+				minimum, quantity, balance, reserve, on_hand, status,
+				create_date, update_date, row_create_date, row_update_date
+			) VALUES ($1,$2,$3,0,0,0,0,0,true,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP)
+			RETURNING id, balance, reserve`,
+			tenantID, warehouseID, productID,
+		).Scan(&stockID, &balance, &reserve)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch stock: %w", err)
+	}
+
+	type transitLot struct {
+		ID               int64
+		DestinationLotID *int64
+		Outstanding      float64
+		CostFIFO         float64
+		CostAverage      float64
+		LotCreatedDate   time.Time
+	}
+	lots := []transitLot{}
+	rows, err := tx.Query(ctx,
+		`SELECT id, destination_lot_id, quantity - received_quantity, cost_fifo, cost_average, lot_created_date
+		 FROM stock_transfer_lots
+		 WHERE transfer_line_id=$1 AND received_quantity < quantity
+		 ORDER BY id FOR UPDATE`,
+		lineID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to fetch in-transit lots: %w", err)
+	}
+	for rows.Next() {
+		var l transitLot
+		if err := rows.Scan(&l.ID, &l.DestinationLotID, &l.Outstanding, &l.CostFIFO, &l.CostAverage, &l.LotCreatedDate); err != nil {
+			rows.Close()
+			return err
+		}
+		lots = append(lots, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to fetch in-transit lots: %w", err)
+	}
+
+	remaining := quantity
+	for _, l := range lots {
+		if remaining <= 0 {
+			break
+		}
+		take := remaining
+		if l.Outstanding < take {
+			take = l.Outstanding
+		}
+
+		// รับบางส่วนหลายครั้งจาก source lot เดียวกันจะเติมเข้า destination lot เดิม
+		var lotID int64
+		if l.DestinationLotID == nil {
+			err = tx.QueryRow(ctx,
+				`INSERT INTO lot (stock_id, balance, cost_fifo, cost_average, created_date) VALUES ($1,$2,$3,$4,$5) RETURNING id`,
+				stockID, take, l.CostFIFO, l.CostAverage, l.LotCreatedDate,
+			).Scan(&lotID)
+		} else {
+			lotID = *l.DestinationLotID
+			_, err = tx.Exec(ctx, `UPDATE lot SET balance = balance + $1 WHERE id=$2`, take, lotID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update destination lot: %w", err)
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE stock_transfer_lots SET received_quantity = received_quantity + $1, destination_lot_id=$2 WHERE id=$3`,
+			take, lotID, l.ID,
+		); err != nil {
+			return fmt.Errorf("failed to update in-transit lot: %w", err)
+		}
+		if err := insertMovement(ctx, tx, appID, storeID, stockID, lotID, nil, balance, reserve, take,
+			l.CostFIFO, l.CostAverage, "transfer_in", transferID, nil); err != nil {
+			return err
+		}
+
+		remaining -= take
+		balance += take
+	}
+	if remaining > 0 {
+		// line กับ lot ไม่ตรงกัน ไม่ควรเกิดถ้า Ship สำเร็จ
+		return fmt.Errorf("line_id=%d: in-transit lots do not cover %v", lineID, remaining)
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE stock_transfer_lines SET received_quantity = received_quantity + $1 WHERE id=$2`, quantity, lineID,
+	); err != nil {
+		return fmt.Errorf("failed to update transfer line: %w", err)
+	}
+	return inventory.AddBalance(ctx, tx, stockID, quantity, 0)
+}
+
+func insertMovement(ctx context.Context, tx pgx.Tx, appID, storeID, stockID, lotID int64, locationID *int64, balance, reserve, change, costFIFO, costAverage float64, action string, transferID int64, bundleProductID *int64) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO stock_movement (
+			app_id, store_id, stock_id, lot_id, balance_before, balance_after, balance_change,
+			reserve_before, reserve_after, reserve_change, cost_fifo, cost_average,
+			action, model, bundle_product_id, transfer_id, location_id, created_date, updated_date
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,NOW(),NOW())`,
+		appID, storeID, stockID, lotID, balance, balance+change, change,
+		reserve, reserve, 0, costFIFO, costAverage,
+		action, movementModel, bundleProductID, transferID, locationID)
+	if err != nil {
+		return fmt.Errorf("failed to insert stock_movement: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package usage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"atlasq/internal/auth"
+	"atlasq/internal/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+// Metrics counted in Redis and rolled up into tenant_usage_monthly.
+// stock_movements ไม่ได้นับใน redis แต่นับจากตาราง stock_movement ตอน rollup
+const (
+	MetricAPICalls       = "api_calls"
+	MetricOrdersEnqueued = "orders_enqueued"
+	MetricWebhookPushes  = "webhook_pushes"
+	MetricStockMovements = "stock_movements"
+)
+
+var redisMetrics = []string{MetricAPICalls, MetricOrdersEnqueued, MetricWebhookPushes}
+
+const (
+	liveKeyPrefix   = "usage:"
+	rollupKeyPrefix = "usage-rollup:"
+)
+
+type Meter struct {
+	rdb *redis.Client
+}
+
+func NewMeter(rdb *redis.Client) *Meter {
+	return &Meter{rdb: rdb}
+}
+
+// key: usage:{yyyymm}:{tenant_id} เป็น hash ของ metric -> count
+func liveKey(tenantID int64, t time.Time) string {
+	return fmt.Sprintf("%s%s:%d", liveKeyPrefix, t.UTC().Format("200601"), tenantID)
+}
+
+// Add counts n events for the tenant in the current month. Metering is best
+// effort: a Redis error is logged and never fails the caller.
+func (m *Meter) Add(ctx context.Context, tenantID int64, metric string, n int64) {
+	if m == nil || tenantID == 0 {
+		return
+	}
+	if err := m.rdb.HIncrBy(ctx, liveKey(tenantID, time.Now()), metric, n).Err(); err != nil {
+		log.Printf("usage: failed to count %s tenant=%d: %v", metric, tenantID, err)
+	}
+}
+
+// Middleware counts one API call per authenticated request.
+func (m *Meter) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if tenantID, ok := c.Locals(auth.LocalTenantID).(int64); ok {
+			m.Add(c.UserContext(), tenantID, MetricAPICalls, 1)
+		}
+		return c.Next()
+	}
+}
+
+// Rollup moves the Redis counters into tenant_usage_monthly and refreshes
+// the stock_movements count for the current and previous month.
+func (m *Meter) Rollup(ctx context.Context, pool *database.LoggingPool) error {
+	// key ที่ค้างจากรอบก่อน (เขียน DB ไม่สำเร็จ) ทำก่อน
+	if err := m.flushKeys(ctx, pool, rollupKeyPrefix+"*"); err != nil {
+		return err
+	}
+
+	var live []string
+	iter := m.rdb.Scan(ctx, 0, liveKeyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		live = append(live, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	for _, key := range live {
+		// rename ก่อนอ่าน เพื่อไม่ให้ HINCRBY ที่เข้ามาระหว่าง rollup หาย
+		// ถ้า key ปลายทางยังค้างอยู่ RenameNX จะไม่ทำอะไร แล้วรอรอบถัดไป
+		err := m.rdb.RenameNX(ctx, key, rollupKeyPrefix+strings.TrimPrefix(key, liveKeyPrefix)).Err()
+		if err != nil && !strings.Contains(err.Error(), "no such key") {
+			return err
+		}
+	}
+	if err := m.flushKeys(ctx, pool, rollupKeyPrefix+"*"); err != nil {
+		return err
+	}
+
+	return m.countStockMovements(ctx, pool)
+}
+
+func (m *Meter) flushKeys(ctx context.Context, pool *database.LoggingPool, pattern string) error {
+	var keys []string
+	iter := m.rdb.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		parts := strings.Split(strings.TrimPrefix(key, rollupKeyPrefix), ":")
+		if len(parts) != 2 {
+			continue
+		}
+		month, err := time.Parse("200601", parts[0])
+		if err != nil {
+			continue
+		}
+		tenantID, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		counts, err := m.rdb.HGetAll(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		values := make([]int64, len(redisMetrics))
+		for i, metric := range redisMetrics {
+			values[i], _ = strconv.ParseInt(counts[metric], 10, 64)
+		}
+
+		_, err = pool.Exec(ctx, `
+			INSERT INTO tenant_usage_monthly (tenant_id, year_month, api_calls, orders_enqueued, webhook_pushes)
+			VALUES ($1,$2,$3,$4,$5)
+			ON CONFLICT (tenant_id, year_month) DO UPDATE SET
+				api_calls = tenant_usage_monthly.api_calls + EXCLUDED.api_calls,
+				orders_enqueued = tenant_usage_monthly.orders_enqueued + EXCLUDED.orders_enqueued,
+				webhook_pushes = tenant_usage_monthly.webhook_pushes + EXCLUDED.webhook_pushes,
+				updated_date = CURRENT_TIMESTAMP
+		`, tenantID, month, values[0], values[1], values[2])
+		if err != nil {
+			return fmt.Errorf("failed to roll up %s: %w", key, err)
+		}
+		if err := m.rdb.Del(ctx, key).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stock_movement อยู่ใต้ RLS จึงต้องนับทีละ tenant
+func (m *Meter) countStockMovements(ctx context.Context, pool *database.LoggingPool) error {
+	rows, err := pool.Query(ctx, `SELECT id FROM tenants`)
+	if err != nil {
+		return err
+	}
+	var tenantIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		tenantIDs = append(tenantIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	current := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	months := []time.Time{current.AddDate(0, -1, 0), current}
+
+	for _, tenantID := range tenantIDs {
+		if err := countTenantMovements(ctx, pool, tenantID, months); err != nil {
+			return fmt.Errorf("failed to count stock movements tenant=%d: %w", tenantID, err)
+		}
+	}
+	return nil
+}
+
+func countTenantMovements(ctx context.Context, pool *database.LoggingPool, tenantID int64, months []time.Time) error {
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := database.SetTenant(ctx, tx, tenantID); err != nil {
+		return err
+	}
+
+	for _, month := range months {
+		var count int64
+		err := tx.QueryRow(ctx,
+			`SELECT COUNT(*) FROM stock_movement WHERE created_date >= $1 AND created_date < $2`,
+			month, month.AddDate(0, 1, 0),
+		).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			continue
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO tenant_usage_monthly (tenant_id, year_month, stock_movements)
+			VALUES ($1,$2,$3)
+			ON CONFLICT (tenant_id, year_month) DO UPDATE SET
+				stock_movements = EXCLUDED.stock_movements,
+				updated_date = CURRENT_TIMESTAMP
+		`, tenantID, month, count)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}